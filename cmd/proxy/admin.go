@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// adminClient talks to the management endpoints of a running proxy
+type adminClient struct {
+	baseURL string
+	token   string
+}
+
+func (c *adminClient) register(fs *flag.FlagSet) {
+	defaultURL := os.Getenv("ADMIN_URL")
+	if defaultURL == "" {
+		defaultURL = "http://127.0.0.1:1488"
+	}
+	fs.StringVar(&c.baseURL, "admin", defaultURL, "admin API: http://host:port or unix:/path/to.sock")
+	fs.StringVar(&c.token, "token", os.Getenv("ADMIN_TOKEN"), "admin API token (default $ADMIN_TOKEN)")
+}
+
+// client returns an HTTP client and base URL, dialing Unix sockets directly
+func (c *adminClient) client() (*http.Client, string) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	socket, isUnix := strings.CutPrefix(c.baseURL, "unix:")
+	if !isUnix {
+		return client, strings.TrimRight(c.baseURL, "/")
+	}
+
+	client.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+	return client, "http://admin"
+}
+
+// do sends a request and returns the body of a 2xx response
+func (c *adminClient) do(method, path string, form url.Values) ([]byte, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	client, base := c.client()
+	req, err := http.NewRequest(method, base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("admin API unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return data, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+func (c *adminClient) get(path string) ([]byte, error) {
+	return c.do(http.MethodGet, path, nil)
+}
+
+// cacheStats mirrors the /stats response
+type cacheStats struct {
+	Hits    uint64  `json:"cache_hits"`
+	Misses  uint64  `json:"cache_misses"`
+	Size    int64   `json:"cache_size_bytes"`
+	Entries int     `json:"cache_entries"`
+	HitRate float64 `json:"hit_rate"`
+	// Rejected counts responses kept out by the admission policy
+	Rejected uint64 `json:"cache_rejected"`
+	Backend  string `json:"cache_backend"`
+	// TierHits splits Hits by tier: memory, disk and/or redis
+	TierHits map[string]uint64 `json:"tier_hits"`
+}
+
+// runCache handles "cache stats|purge|invalidate|clear"
+func runCache(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: cache stats|purge <url>|invalidate <pattern>|clear")
+	}
+
+	switch args[0] {
+	case "stats":
+		return runCacheStats(args[1:])
+	case "purge":
+		return runCachePurge(args[1:])
+	case "invalidate":
+		return runCacheInvalidate(args[1:])
+	case "clear":
+		return runCacheClear(args[1:])
+	default:
+		return fmt.Errorf("unknown cache command %q", args[0])
+	}
+}
+
+func runCacheStats(args []string) error {
+	fs := flag.NewFlagSet("cache stats", flag.ContinueOnError)
+	var admin adminClient
+	admin.register(fs)
+	asJSON := fs.Bool("json", false, "print the raw JSON response")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	data, err := admin.get("/stats")
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		fmt.Println(strings.TrimSpace(string(data)))
+		return nil
+	}
+
+	var stats cacheStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return fmt.Errorf("unexpected /stats response: %w", err)
+	}
+	if stats.Backend != "" {
+		fmt.Printf("Backend:   %s\n", stats.Backend)
+	}
+	fmt.Printf("Entries:   %d\n", stats.Entries)
+	fmt.Printf("Size:      %.1f MB\n", float64(stats.Size)/(1024*1024))
+	fmt.Printf("Hits:      %d\n", stats.Hits)
+	for _, tier := range []string{"memory", "disk", "redis"} {
+		if n, ok := stats.TierHits[tier]; ok && len(stats.TierHits) > 1 {
+			fmt.Printf("  %-8s %d\n", tier+":", n)
+		}
+	}
+	fmt.Printf("Misses:    %d\n", stats.Misses)
+	fmt.Printf("Hit rate:  %.1f%%\n", stats.HitRate*100)
+	fmt.Printf("Rejected:  %d\n", stats.Rejected)
+	return nil
+}
+
+func runCachePurge(args []string) error {
+	fs := flag.NewFlagSet("cache purge", flag.ContinueOnError)
+	var admin adminClient
+	admin.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: cache purge [-admin URL] <url>")
+	}
+
+	data, err := admin.do(http.MethodPost, "/cache/purge", url.Values{"url": {fs.Arg(0)}})
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Entries int `json:"entries"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("unexpected purge response: %w", err)
+	}
+	fmt.Printf("Purged %d cache entries for %s\n", result.Entries, fs.Arg(0))
+	return nil
+}
+
+func runCacheInvalidate(args []string) error {
+	fs := flag.NewFlagSet("cache invalidate", flag.ContinueOnError)
+	var admin adminClient
+	admin.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: cache invalidate [-admin URL] <url-prefix|url-glob|host>")
+	}
+
+	data, err := admin.do(http.MethodPost, "/cache/invalidate", url.Values{"pattern": {fs.Arg(0)}})
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Memory int `json:"memory"`
+		Disk   int `json:"disk"`
+		Redis  int `json:"redis"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("unexpected invalidate response: %w", err)
+	}
+	fmt.Printf("Invalidated %s: %d memory, %d disk, %d redis entries\n", fs.Arg(0), result.Memory, result.Disk, result.Redis)
+	return nil
+}
+
+func runCacheClear(args []string) error {
+	fs := flag.NewFlagSet("cache clear", flag.ContinueOnError)
+	var admin adminClient
+	admin.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if _, err := admin.do(http.MethodPost, "/cache/clear", nil); err != nil {
+		return err
+	}
+	fmt.Println("Cache cleared")
+	return nil
+}
+
+// runHealth exits non-zero unless the running proxy reports healthy; it is
+// used by container health checks
+func runHealth(args []string) error {
+	fs := flag.NewFlagSet("health", flag.ContinueOnError)
+	var admin adminClient
+	admin.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	_, err := admin.get("/health")
+	return err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/onixus/4ebur-net/internal/config"
+)
+
+func TestCacheCommands(t *testing.T) {
+	var purged, invalidated string
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/stats":
+			w.Write([]byte(`{"cache_hits":3,"cache_misses":1,"cache_size_bytes":10,"cache_entries":2,"hit_rate":0.75,"cache_backend":"tiered","tier_hits":{"memory":2,"redis":1}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/cache/purge":
+			purged = r.FormValue("url")
+			w.Write([]byte(`{"status":"purged","entries":1}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/cache/invalidate":
+			invalidated = r.FormValue("pattern")
+			w.Write([]byte(`{"status":"invalidated","memory":1,"disk":0,"redis":2}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/cache/clear":
+			w.Write([]byte(`{"status":"cleared"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer admin.Close()
+
+	if err := run([]string{"cache", "stats", "-admin", admin.URL}); err != nil {
+		t.Errorf("cache stats failed: %v", err)
+	}
+	if err := run([]string{"cache", "purge", "-admin", admin.URL, "https://example.com/a?b=1"}); err != nil {
+		t.Errorf("cache purge failed: %v", err)
+	}
+	if purged != "https://example.com/a?b=1" {
+		t.Errorf("Purge sent wrong url: %q", purged)
+	}
+	if err := run([]string{"cache", "purge", "-admin", admin.URL}); err == nil {
+		t.Error("cache purge without a url should fail")
+	}
+	if err := run([]string{"cache", "invalidate", "-admin", admin.URL, "https://example.com/*.js"}); err != nil {
+		t.Errorf("cache invalidate failed: %v", err)
+	}
+	if invalidated != "https://example.com/*.js" {
+		t.Errorf("Invalidate sent wrong pattern: %q", invalidated)
+	}
+	if err := run([]string{"cache", "clear", "-admin", admin.URL}); err != nil {
+		t.Errorf("cache clear failed: %v", err)
+	}
+	if err := run([]string{"health", "-admin", admin.URL}); err == nil {
+		t.Error("health should fail on a 404")
+	}
+}
+
+func TestServeFlagsOverrideConfig(t *testing.T) {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	var flags serveFlags
+	flags.register(fs)
+	if err := fs.Parse([]string{"-cache-max-age", "1h", "-deny-hosts", "*.ads.example, tracker.example"}); err != nil {
+		t.Fatal(err)
+	}
+	flags.set = map[string]bool{"cache-max-age": true, "deny-hosts": true}
+
+	cfg := config.Default()
+	cfg.Listen.Proxy = ":3128"
+	flags.apply(cfg)
+
+	if cfg.Cache.MaxAge.Std() != time.Hour {
+		t.Errorf("Expected -cache-max-age to override, got %v", cfg.Cache.MaxAge.Std())
+	}
+	if len(cfg.ACL.DenyHosts) != 2 || cfg.ACL.DenyHosts[1] != "tracker.example" {
+		t.Errorf("Unexpected deny hosts: %q", cfg.ACL.DenyHosts)
+	}
+	if cfg.Listen.Proxy != ":3128" {
+		t.Errorf("Unset flags must not override config, got listen %q", cfg.Listen.Proxy)
+	}
+
+	if err := run([]string{"serve", "-check-config", "-listen", ":9999", "-deny-hosts", "*.ads.example, tracker.example"}); err != nil {
+		t.Errorf("serve -check-config failed: %v", err)
+	}
+	if err := run([]string{"serve", "-check-config", "-listen", "no-port"}); err == nil {
+		t.Error("Invalid -listen should fail validation")
+	}
+	if err := run([]string{"bogus"}); err == nil {
+		t.Error("Unknown command should fail")
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/onixus/4ebur-net/internal/cert"
+	"github.com/onixus/4ebur-net/internal/config"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// runCA handles "ca export|generate|inspect"
+func runCA(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: ca export|generate|inspect")
+	}
+
+	switch args[0] {
+	case "export":
+		return runCAExport(args[1:])
+	case "generate":
+		return runCAGenerate(args[1:])
+	case "inspect":
+		return runCAInspect(args[1:])
+	default:
+		return fmt.Errorf("unknown ca command %q", args[0])
+	}
+}
+
+// caSource locates the CA: explicit files, the config file, or a running
+// instance's /ca.crt
+type caSource struct {
+	configPath string
+	certFile   string
+	keyFile    string
+	admin      adminClient
+}
+
+func (s *caSource) register(fs *flag.FlagSet) {
+	fs.StringVar(&s.configPath, "config", os.Getenv("CONFIG_FILE"), "config file to read cert.ca_file/key_file from")
+	fs.StringVar(&s.certFile, "cert", "", "CA certificate PEM file")
+	fs.StringVar(&s.keyFile, "key", "", "CA private key PEM file")
+	s.admin.register(fs)
+}
+
+// resolveFiles fills certFile/keyFile from the config when not given
+func (s *caSource) resolveFiles() error {
+	if s.certFile != "" {
+		return nil
+	}
+	cfg, err := config.Load(s.configPath)
+	if err != nil {
+		return err
+	}
+	s.certFile, s.keyFile = cfg.Cert.CAFile, cfg.Cert.KeyFile
+	return nil
+}
+
+// certificate returns the CA certificate from a file or the running proxy
+func (s *caSource) certificate() (*x509.Certificate, error) {
+	if err := s.resolveFiles(); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	var err error
+	if s.certFile != "" {
+		data, err = os.ReadFile(s.certFile)
+	} else {
+		// The proxy generated its CA at startup, so ask it
+		data, err = s.admin.get("/ca.crt")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseCertificate(data)
+}
+
+// parseCertificate accepts PEM or DER
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("expected CERTIFICATE PEM block, got %s", block.Type)
+		}
+		data = block.Bytes
+	}
+	return x509.ParseCertificate(data)
+}
+
+// runCAExport writes the CA certificate as PEM, DER or PKCS#12
+func runCAExport(args []string) error {
+	fs := flag.NewFlagSet("ca export", flag.ContinueOnError)
+	var src caSource
+	src.register(fs)
+	format := fs.String("format", "pem", "output format: pem, der or p12")
+	output := fs.String("o", "", "output file (default stdout)")
+	password := fs.String("password", os.Getenv("P12_PASSWORD"), "PKCS#12 password, required for p12 (default $P12_PASSWORD)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	caCert, err := src.certificate()
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	mode := os.FileMode(0o644)
+	switch strings.ToLower(*format) {
+	case "pem":
+		data = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})
+	case "der":
+		data = caCert.Raw
+	case "p12", "pfx", "pkcs12":
+		// PKCS#12 bundles the private key, which only exists on disk
+		if src.certFile == "" || src.keyFile == "" {
+			return errors.New("p12 export needs the CA key: pass -cert and -key or set cert.ca_file/key_file")
+		}
+		if *password == "" {
+			return errors.New("p12 export needs a password: pass -password or set P12_PASSWORD")
+		}
+		manager, err := cert.NewCertManagerFromFiles(src.certFile, src.keyFile)
+		if err != nil {
+			return err
+		}
+		if data, err = pkcs12.Modern.Encode(manager.CAKey(), manager.CACertificate(), nil, *password); err != nil {
+			return err
+		}
+		mode = 0o600
+	default:
+		return fmt.Errorf("unknown format %q (want pem, der or p12)", *format)
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*output, data, mode); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s (%s)\n", *output, *format)
+	return nil
+}
+
+// runCAGenerate creates a new CA certificate and key
+func runCAGenerate(args []string) error {
+	fs := flag.NewFlagSet("ca generate", flag.ContinueOnError)
+	certFile := fs.String("cert", "4ebur-net-ca.crt", "output certificate file")
+	keyFile := fs.String("key", "4ebur-net-ca.key", "output private key file")
+	commonName := fs.String("cn", "4ebur-net CA", "certificate common name")
+	validity := fs.Duration("validity", 10*365*24*time.Hour, "certificate lifetime")
+	force := fs.Bool("force", false, "overwrite existing files")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if !*force {
+		for _, path := range []string{*certFile, *keyFile} {
+			if _, err := os.Stat(path); err == nil {
+				return fmt.Errorf("%s already exists (use -force to overwrite)", path)
+			}
+		}
+	}
+
+	caCert, caKey, err := cert.GenerateCA(*commonName, *validity)
+	if err != nil {
+		return err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})
+	if err := os.WriteFile(*certFile, certPEM, 0o644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(*keyFile, cert.EncodeKeyPEM(caKey), 0o600); err != nil {
+		return err
+	}
+
+	fmt.Printf("Generated CA %q valid until %s\n", *commonName, caCert.NotAfter.Format(time.RFC3339))
+	fmt.Printf("  certificate: %s\n  private key: %s\n", *certFile, *keyFile)
+	fmt.Printf("Use it with: -ca-cert %s -ca-key %s\n", *certFile, *keyFile)
+	return nil
+}
+
+// runCAInspect prints the details of a CA certificate
+func runCAInspect(args []string) error {
+	fs := flag.NewFlagSet("ca inspect", flag.ContinueOnError)
+	var src caSource
+	src.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		src.certFile = fs.Arg(0)
+	}
+
+	caCert, err := src.certificate()
+	if err != nil {
+		return err
+	}
+	printCertificate(os.Stdout, caCert, time.Now())
+	return nil
+}
+
+// printCertificate writes a human-readable summary of c
+func printCertificate(w io.Writer, c *x509.Certificate, now time.Time) {
+	status := "valid"
+	switch {
+	case now.Before(c.NotBefore):
+		status = "not yet valid"
+	case now.After(c.NotAfter):
+		status = "EXPIRED"
+	case c.NotAfter.Sub(now) < 30*24*time.Hour:
+		status = "expires soon"
+	}
+
+	keyDesc := c.PublicKeyAlgorithm.String()
+	switch key := c.PublicKey.(type) {
+	case *rsa.PublicKey:
+		keyDesc = fmt.Sprintf("RSA %d bits", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		keyDesc = fmt.Sprintf("ECDSA %s", key.Curve.Params().Name)
+	}
+
+	sha256Sum := sha256.Sum256(c.Raw)
+	sha1Sum := sha1.Sum(c.Raw)
+
+	fmt.Fprintf(w, "Subject:      %s\n", c.Subject)
+	fmt.Fprintf(w, "Issuer:       %s\n", c.Issuer)
+	fmt.Fprintf(w, "Serial:       %X\n", c.SerialNumber)
+	fmt.Fprintf(w, "Not before:   %s\n", c.NotBefore.UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "Not after:    %s (%s)\n", c.NotAfter.UTC().Format(time.RFC3339), status)
+	fmt.Fprintf(w, "Is CA:        %v\n", c.IsCA)
+	fmt.Fprintf(w, "Public key:   %s\n", keyDesc)
+	fmt.Fprintf(w, "Signature:    %s\n", c.SignatureAlgorithm)
+	fmt.Fprintf(w, "SHA-256:      %s\n", fingerprint(sha256Sum[:]))
+	fmt.Fprintf(w, "SHA-1:        %s\n", fingerprint(sha1Sum[:]))
+}
+
+// fingerprint formats sum as colon-separated hex
+func fingerprint(sum []byte) string {
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestCAGenerateInspectExport(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "ca.crt")
+	keyFile := filepath.Join(dir, "ca.key")
+
+	if err := run([]string{"ca", "generate", "-cert", certFile, "-key", keyFile, "-cn", "Test CA"}); err != nil {
+		t.Fatalf("ca generate failed: %v", err)
+	}
+	if err := run([]string{"ca", "generate", "-cert", certFile, "-key", keyFile}); err == nil {
+		t.Error("ca generate should refuse to overwrite without -force")
+	}
+
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Key file should be private, got %v", info.Mode().Perm())
+	}
+
+	for _, format := range []string{"pem", "der", "p12"} {
+		out := filepath.Join(dir, "export."+format)
+		args := []string{"ca", "export", "-cert", certFile, "-key", keyFile, "-format", format, "-o", out, "-password", "secret"}
+		if err := run(args); err != nil {
+			t.Errorf("ca export -format %s failed: %v", format, err)
+			continue
+		}
+		data, _ := os.ReadFile(out)
+		if format != "p12" {
+			if _, err := parseCertificate(data); err != nil {
+				t.Errorf("Exported %s is not a certificate: %v", format, err)
+			}
+			continue
+		}
+
+		key, caCert, _, err := pkcs12.DecodeChain(data, "secret")
+		if err != nil {
+			t.Fatalf("Exported p12 does not decode: %v", err)
+		}
+		if caCert.Subject.CommonName != "Test CA" || key == nil {
+			t.Errorf("Unexpected p12 contents: %s", caCert.Subject)
+		}
+		if info, _ := os.Stat(out); info.Mode().Perm() != 0o600 {
+			t.Errorf("p12 holds the CA key and should be private, got %v", info.Mode().Perm())
+		}
+	}
+
+	if err := run([]string{"ca", "export", "-cert", certFile, "-format", "p12", "-password", "secret"}); err == nil {
+		t.Error("p12 export without a key should fail")
+	}
+	t.Setenv("P12_PASSWORD", "")
+	if err := run([]string{"ca", "export", "-cert", certFile, "-key", keyFile, "-format", "p12", "-o", filepath.Join(dir, "open.p12")}); err == nil {
+		t.Error("p12 export without a password should fail")
+	}
+}
+
+func TestPrintCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "ca.crt")
+	if err := run([]string{"ca", "generate", "-cert", certFile, "-key", filepath.Join(dir, "ca.key"), "-cn", "Inspect CA", "-validity", "240h"}); err != nil {
+		t.Fatalf("ca generate failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(certFile)
+	caCert, err := parseCertificate(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	printCertificate(&buf, caCert, time.Now())
+	out := buf.String()
+	for _, want := range []string{"CN=Inspect CA", "Is CA:        true", "RSA 2048 bits", "expires soon"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Output missing %q:\n%s", want, out)
+		}
+	}
+
+	buf.Reset()
+	printCertificate(&buf, caCert, time.Now().Add(365*24*time.Hour))
+	if !strings.Contains(buf.String(), "EXPIRED") {
+		t.Errorf("Expected EXPIRED status:\n%s", buf.String())
+	}
+}
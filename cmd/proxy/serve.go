@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/onixus/4ebur-net/internal/admin"
+	"github.com/onixus/4ebur-net/internal/config"
+	"github.com/onixus/4ebur-net/internal/proxy"
+)
+
+// serveFlags mirror the config file; only flags given on the command line
+// override it
+type serveFlags struct {
+	set map[string]bool
+
+	listen          string
+	adminListen     string
+	transparent     string
+	transparentMode string
+	caCert          string
+	caKey           string
+	cacheSizeMB     int64
+	cacheMaxAge     time.Duration
+	maxObjectSizeMB int64
+	parentProxy     string
+	allowClients    string
+	denyHosts       string
+	drainTimeout    time.Duration
+	wsLogFrames     bool
+	fixturesMode    string
+	fixturesDir     string
+}
+
+// register adds the config flags to fs
+func (f *serveFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.listen, "listen", "", "proxy listen address (listen.proxy)")
+	fs.StringVar(&f.adminListen, "admin-listen", "", "admin listener host:port or unix:/path (admin.listen)")
+	fs.StringVar(&f.transparent, "transparent", "", "transparent listener address (listen.transparent)")
+	fs.StringVar(&f.transparentMode, "transparent-mode", "", "redirect or tproxy (listen.transparent_mode)")
+	fs.StringVar(&f.caCert, "ca-cert", "", "CA certificate PEM file (cert.ca_file)")
+	fs.StringVar(&f.caKey, "ca-key", "", "CA private key PEM file (cert.key_file)")
+	fs.Int64Var(&f.cacheSizeMB, "cache-size-mb", 0, "cache size in MB (cache.size_mb)")
+	fs.DurationVar(&f.cacheMaxAge, "cache-max-age", 0, "default cache TTL (cache.max_age)")
+	fs.Int64Var(&f.maxObjectSizeMB, "cache-max-object-size-mb", 0, "largest cached response in MB (cache.max_object_size_mb)")
+	fs.StringVar(&f.parentProxy, "parent-proxy", "", "upstream proxy URL (upstream.parent_proxy)")
+	fs.StringVar(&f.allowClients, "allow-clients", "", "comma-separated client CIDRs (acl.allow_clients)")
+	fs.StringVar(&f.denyHosts, "deny-hosts", "", "comma-separated host globs (acl.deny_hosts)")
+	fs.DurationVar(&f.drainTimeout, "drain-timeout", 0, "graceful shutdown timeout (shutdown.drain_timeout)")
+	fs.BoolVar(&f.wsLogFrames, "websocket-log-frames", false, "log WebSocket frames (websocket.log_frames)")
+	fs.StringVar(&f.fixturesMode, "fixtures-mode", "", "off, record or replay (fixtures.mode)")
+	fs.StringVar(&f.fixturesDir, "fixtures-dir", "", "recorded fixture directory (fixtures.dir)")
+}
+
+// apply overrides cfg with the flags that were set explicitly
+func (f *serveFlags) apply(cfg *config.Config) {
+	if f.set["listen"] {
+		cfg.Listen.Proxy = f.listen
+	}
+	if f.set["admin-listen"] {
+		cfg.Admin.Listen = f.adminListen
+	}
+	if f.set["transparent"] {
+		cfg.Listen.Transparent = f.transparent
+	}
+	if f.set["transparent-mode"] {
+		cfg.Listen.TransparentMode = f.transparentMode
+	}
+	if f.set["ca-cert"] {
+		cfg.Cert.CAFile = f.caCert
+	}
+	if f.set["ca-key"] {
+		cfg.Cert.KeyFile = f.caKey
+	}
+	if f.set["cache-size-mb"] {
+		cfg.Cache.SizeMB = f.cacheSizeMB
+	}
+	if f.set["cache-max-age"] {
+		cfg.Cache.MaxAge = config.Duration(f.cacheMaxAge)
+	}
+	if f.set["cache-max-object-size-mb"] {
+		cfg.Cache.MaxObjectSizeMB = f.maxObjectSizeMB
+	}
+	if f.set["parent-proxy"] {
+		cfg.Upstream.ParentProxy = f.parentProxy
+	}
+	if f.set["allow-clients"] {
+		cfg.ACL.AllowClients = splitList(f.allowClients)
+	}
+	if f.set["deny-hosts"] {
+		cfg.ACL.DenyHosts = splitList(f.denyHosts)
+	}
+	if f.set["drain-timeout"] {
+		cfg.Shutdown.DrainTimeout = config.Duration(f.drainTimeout)
+	}
+	if f.set["websocket-log-frames"] {
+		cfg.WebSocket.LogFrames = f.wsLogFrames
+	}
+	if f.set["fixtures-mode"] {
+		cfg.Fixtures.Mode = f.fixturesMode
+	}
+	if f.set["fixtures-dir"] {
+		cfg.Fixtures.Dir = f.fixturesDir
+	}
+}
+
+// runServe starts the proxy and blocks until it has shut down
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "path to YAML config file")
+	checkConfig := fs.Bool("check-config", false, "validate configuration and exit")
+	var flags serveFlags
+	flags.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	flags.set = make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { flags.set[f.Name] = true })
+
+	// Defaults, then environment variables, then the config file, then flags
+	loadConfig := func() (*config.Config, error) {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			return nil, err
+		}
+		flags.apply(cfg)
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+		return cfg, nil
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	if *checkConfig {
+		fmt.Println("Configuration OK")
+		return nil
+	}
+
+	// Создаем прокси-сервер
+	proxyServer, err := proxy.NewProxyServerWithConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create proxy server: %w", err)
+	}
+
+	_, port, _ := net.SplitHostPort(cfg.Listen.Proxy)
+
+	// reloadConfig re-reads the config file and applies runtime settings
+	var cfgMu sync.Mutex
+	var adminServer *admin.Server
+	reloadConfig := func() error {
+		next, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		if _, err := proxyServer.ApplyConfig(next); err != nil {
+			return err
+		}
+		adminServer.SetToken(next.Admin.Token)
+		cfgMu.Lock()
+		cfg = next
+		cfgMu.Unlock()
+		log.Printf("🔄 Configuration reloaded")
+		return nil
+	}
+
+	adminServer = admin.New(proxyServer, reloadConfig, port)
+	adminServer.SetToken(cfg.Admin.Token)
+	adminServer.Handle("/metrics", proxyServer.Metrics().Handler())
+	adminServer.HandlePrivate("/har/", proxyServer.HAR().Handler())
+
+	// Without a dedicated admin listener, management routes answer
+	// origin-form requests on the proxy port; proxied requests always
+	// carry a host and go upstream
+	var handler http.Handler = proxyServer
+	if cfg.Admin.Listen == "" {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodConnect && r.URL.Host == "" {
+				adminServer.ServeHTTP(w, r)
+				return
+			}
+			proxyServer.ServeHTTP(w, r)
+		})
+	}
+
+	// Настраиваем HTTP сервер с оптимальными параметрами
+	server := &http.Server{
+		Addr:        cfg.Listen.Proxy,
+		Handler:     handler,
+		ConnState:   proxyServer.ConnState,
+		ReadTimeout: 30 * time.Second,
+		// No WriteTimeout: streaming responses (SSE, long-poll, large
+		// downloads) are bounded by per-route idle/total timeouts instead
+		IdleTimeout:       120 * time.Second,
+		ReadHeaderTimeout: 10 * time.Second,
+		MaxHeaderBytes:    1 << 20, // 1MB
+	}
+
+	log.Println("╔═══════════════════════════════════════════════════════════╗")
+	log.Println("║         4ebur-net MITM Proxy Server Started              ║")
+	log.Println("╚═══════════════════════════════════════════════════════════╝")
+	log.Printf("🚀 Listening on port: %s", port)
+	adminBase := "http://localhost:" + port
+	if listen := cfg.Admin.Listen; strings.HasPrefix(listen, "unix:") {
+		adminBase = listen
+	} else if listen != "" {
+		host, adminPort, _ := net.SplitHostPort(listen)
+		if host == "" {
+			host = "localhost"
+		}
+		adminBase = "http://" + net.JoinHostPort(host, adminPort)
+	}
+	log.Printf("🌐 Web interface: %s/", adminBase)
+	log.Printf("📥 Download CA certificate: %s/ca.crt", adminBase)
+	log.Printf("📊 Cache stats: %s/stats", adminBase)
+	log.Printf("💚 Health check: %s/health", adminBase)
+	log.Printf("🔧 Configure proxy: localhost:%s", port)
+	log.Println("⚠️  Remember to install CA certificate in your trust store!")
+	log.Println("───────────────────────────────────────────────────────────")
+
+	// Optional dedicated admin listener; the proxy port then only proxies
+	var adminHTTP *http.Server
+	if cfg.Admin.Listen != "" {
+		listener, err := admin.Listen(cfg.Admin.Listen)
+		if err != nil {
+			return fmt.Errorf("failed to start admin listener: %w", err)
+		}
+		if cfg.Admin.Token == "" && !strings.HasPrefix(cfg.Admin.Listen, "unix:") {
+			log.Printf("⚠️  Admin listener %s has no token; only local clients may change state", cfg.Admin.Listen)
+		}
+		adminHTTP = &http.Server{
+			Handler:           adminServer,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			if err := adminHTTP.Serve(listener); err != nil && err != http.ErrServerClosed {
+				log.Printf("✗ Admin listener stopped: %v", err)
+			}
+		}()
+	}
+
+	// Optional transparent listener for REDIRECT/TPROXY gateway setups
+	if cfg.Listen.Transparent != "" {
+		tproxy := cfg.Listen.TransparentMode == "tproxy"
+		listener, err := proxy.ListenTransparent(cfg.Listen.Transparent, tproxy)
+		if err != nil {
+			return fmt.Errorf("failed to start transparent listener: %w", err)
+		}
+		log.Printf("🕵️  Transparent proxy listening on: %s (tproxy: %v)", cfg.Listen.Transparent, tproxy)
+		go func() {
+			if err := proxyServer.ServeTransparent(listener); err != nil {
+				log.Printf("✗ Transparent listener stopped: %v", err)
+			}
+		}()
+	}
+
+	// SIGHUP reloads the configuration
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reloadConfig(); err != nil {
+				log.Printf("✗ Config reload failed, keeping current settings:\n%v", err)
+			}
+		}
+	}()
+
+	// Stop on SIGTERM/SIGINT and drain in-flight requests and tunnels
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		return fmt.Errorf("server failed: %w", err)
+	case <-ctx.Done():
+		stop()
+	}
+
+	cfgMu.Lock()
+	shutdownCfg := cfg.Shutdown
+	cfgMu.Unlock()
+
+	// Report not-ready first so health checks can steer new clients away
+	proxyServer.BeginDrain()
+	if readyDelay := shutdownCfg.ReadyDelay.Std(); readyDelay > 0 {
+		log.Printf("🛑 Shutdown requested, reporting not-ready for %v", readyDelay)
+		time.Sleep(readyDelay)
+	}
+
+	drainTimeout := shutdownCfg.DrainTimeout.Std()
+	log.Printf("🛑 Shutting down, draining connections for up to %v", drainTimeout)
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := server.Shutdown(drainCtx); err != nil {
+			log.Printf("⚠️  HTTP server drain incomplete: %v", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := proxyServer.Shutdown(drainCtx); err != nil {
+			log.Printf("⚠️  Tunnel drain incomplete: %v", err)
+		}
+	}()
+	wg.Wait()
+
+	if adminHTTP != nil {
+		if err := adminHTTP.Shutdown(drainCtx); err != nil {
+			log.Printf("⚠️  Admin server drain incomplete: %v", err)
+		}
+	}
+
+	if err := proxyServer.Close(); err != nil {
+		log.Printf("✗ Failed to release resources: %v", err)
+	}
+	log.Println("👋 Shutdown complete")
+	return nil
+}
+
+// splitList splits a comma-separated flag value
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/onixus/4ebur-net/internal/config"
+)
+
+// runConfig handles "config validate"
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "validate" {
+		return errors.New("usage: config validate [file]")
+	}
+
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "config file to validate")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		*path = fs.Arg(0)
+	}
+
+	if _, err := config.Load(*path); err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	if *path == "" {
+		fmt.Println("Configuration OK (defaults and environment)")
+	} else {
+		fmt.Printf("%s: OK\n", *path)
+	}
+	return nil
+}
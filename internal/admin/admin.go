@@ -0,0 +1,313 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Proxy is the part of the proxy server exposed through the admin API
+type Proxy interface {
+	GetCacheStats() (hits, misses uint64, size int64, entries int, hitRate float64)
+	GetCacheTiers() (backend string, tierHits map[string]uint64)
+	GetCacheRejections() uint64
+	GetCACertificate() []byte
+	PurgeCache(url string) (int, error)
+	InvalidateCache(pattern string) (memory, disk, redis int, err error)
+	ClearCache() error
+	Draining() bool
+}
+
+// Server serves the management endpoints: health, stats, CA download,
+// config reload and cache purge. /health and /ca.crt are public; every
+// other route needs the bearer token when one is set, and without a token
+// state-changing and private routes only accept local clients.
+type Server struct {
+	proxy     Proxy
+	reload    func() error
+	proxyPort string
+	token     atomic.Value // string
+	mux       *http.ServeMux
+}
+
+// New creates the admin handler. reload re-reads the configuration;
+// proxyPort is shown on the index page.
+func New(proxy Proxy, reload func() error, proxyPort string) *Server {
+	s := &Server{
+		proxy:     proxy,
+		reload:    reload,
+		proxyPort: proxyPort,
+		mux:       http.NewServeMux(),
+	}
+	s.token.Store("")
+
+	s.mux.HandleFunc("/health", s.handleHealth)
+	s.mux.HandleFunc("/ca.crt", s.handleCACert)
+	s.Handle("/stats", http.HandlerFunc(s.handleStats))
+	s.Handle("/config/reload", http.HandlerFunc(s.handleReload))
+	s.Handle("/cache/purge", http.HandlerFunc(s.handlePurge))
+	s.Handle("/cache/invalidate", http.HandlerFunc(s.handleInvalidate))
+	s.Handle("/cache/clear", http.HandlerFunc(s.handleClear))
+	s.Handle("/", http.HandlerFunc(s.handleIndex))
+	return s
+}
+
+// SetToken changes the token required for protected routes; empty disables it
+func (s *Server) SetToken(token string) {
+	s.token.Store(token)
+}
+
+// Handle registers a protected route
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, s.requireAuth(handler, false))
+}
+
+// HandlePrivate registers a protected route whose reads expose traffic,
+// such as HAR captures: without a token even GET needs a local client
+func (s *Server) HandlePrivate(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, s.requireAuth(handler, true))
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// requireAuth checks the bearer token, or restricts state-changing
+// requests, and all requests to private routes, to local clients when no
+// token is configured
+func (s *Server) requireAuth(next http.Handler, private bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := s.token.Load().(string)
+		switch {
+		case token != "":
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="4ebur-net admin"`)
+				writeError(w, http.StatusUnauthorized, "invalid or missing admin token")
+				return
+			}
+		case private && !isLocal(r.RemoteAddr):
+			writeError(w, http.StatusForbidden, "only local clients may use this route without an admin token")
+			return
+		case r.Method != http.MethodGet && r.Method != http.MethodHead && !isLocal(r.RemoteAddr):
+			writeError(w, http.StatusForbidden, "only local clients may change state without an admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if s.proxy.Draining() {
+		// Not ready: let load balancers move traffic elsewhere
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"status":"draining","service":"4ebur-net"}`))
+		return
+	}
+	_, _ = w.Write([]byte(`{"status":"ok","service":"4ebur-net"}`))
+}
+
+func (s *Server) handleCACert(w http.ResponseWriter, r *http.Request) {
+	caCert := s.proxy.GetCACertificate()
+	w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+	w.Header().Set("Content-Disposition", `attachment; filename="4ebur-net-ca.crt"`)
+	_, _ = w.Write(caCert)
+	log.Printf("📥 CA certificate downloaded from %s", r.RemoteAddr)
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	hits, misses, size, entries, hitRate := s.proxy.GetCacheStats()
+	backend, tierHits := s.proxy.GetCacheTiers()
+	tiers, _ := json.Marshal(tierHits)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(fmt.Sprintf(
+		`{"cache_hits":%d,"cache_misses":%d,"cache_size_bytes":%d,"cache_entries":%d,"hit_rate":%.2f,"cache_rejected":%d,"cache_backend":%q,"tier_hits":%s}`,
+		hits, misses, size, entries, hitRate, s.proxy.GetCacheRejections(), backend, tiers,
+	)))
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	if err := s.reload(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"status":"reloaded"}`))
+}
+
+func (s *Server) handlePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	target := r.FormValue("url")
+	if target == "" {
+		writeError(w, http.StatusBadRequest, "missing url")
+		return
+	}
+
+	purged, err := s.proxy.PurgeCache(target)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	log.Printf("🧹 Purged %d cache entries for %s", purged, target)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "purged", "url": target, "entries": purged})
+}
+
+// handleInvalidate removes entries matching a URL prefix, host or glob
+// pattern on this and every instance sharing the Redis tier
+func (s *Server) handleInvalidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	pattern := r.FormValue("pattern")
+	if pattern == "" {
+		writeError(w, http.StatusBadRequest, "missing pattern")
+		return
+	}
+
+	memory, disk, redis, err := s.proxy.InvalidateCache(pattern)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("🧹 Invalidated %s: %d memory, %d disk, %d redis entries", pattern, memory, disk, redis)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "invalidated",
+		"pattern": pattern,
+		"memory":  memory,
+		"disk":    disk,
+		"redis":   redis,
+	})
+}
+
+func (s *Server) handleClear(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	if err := s.proxy.ClearCache(); err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	log.Printf("🧹 Cache cleared")
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"status":"cleared"}`))
+}
+
+// indexPage is the HTML served at /; the Host header comes from the client
+// and is escaped by html/template
+var indexPage = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<title>4ebur-net MITM Proxy</title>
+	<style>
+		body { font-family: monospace; margin: 40px; background: #1a1a1a; color: #00ff00; }
+		h1 { color: #00ff00; }
+		a { color: #00aaff; }
+		pre { background: #0a0a0a; padding: 10px; border: 1px solid #00ff00; }
+	</style>
+</head>
+<body>
+	<h1>🚀 4ebur-net MITM Proxy Server</h1>
+	<p><strong>Status:</strong> Running on port {{.Port}}</p>
+	
+	<h2>📥 Downloads:</h2>
+	<ul>
+		<li><a href="/ca.crt">Download CA Certificate</a></li>
+	</ul>
+	
+	<h2>📊 Endpoints:</h2>
+	<ul>
+		<li><a href="/stats">/stats</a> - Cache statistics (JSON)</li>
+		<li><a href="/health">/health</a> - Health check (JSON)</li>
+		<li><a href="/metrics">/metrics</a> - Prometheus metrics</li>
+		<li><a href="/har/captures">/har/captures</a> - HAR captures (JSON)</li>
+	</ul>
+	
+	<h2>🔧 Configuration:</h2>
+	<pre>export HTTP_PROXY=http://localhost:{{.Port}}
+export HTTPS_PROXY=http://localhost:{{.Port}}
+
+# Or with curl:
+curl -x http://localhost:{{.Port}} https://example.com</pre>
+	
+	<h2>📖 Installation:</h2>
+	<pre># 1. Download CA certificate
+curl http://{{.Host}}/ca.crt -o 4ebur-net-ca.crt
+
+# 2. Install (Arch Linux)
+sudo cp 4ebur-net-ca.crt /etc/ca-certificates/trust-source/anchors/
+sudo trust extract-compat
+
+# 3. Verify
+trust list | grep -i 4ebur</pre>
+</body>
+</html>`))
+
+// handleIndex shows information about the proxy
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = indexPage.Execute(w, struct{ Port, Host string }{s.proxyPort, r.Host})
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "error", "error": msg})
+}
+
+// isLocal reports whether remoteAddr is a loopback or Unix socket peer
+func isLocal(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		// Unix socket peers have no host:port address
+		return remoteAddr == "" || remoteAddr == "@"
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Listen opens the admin listener: "unix:/path/to.sock" for a Unix socket
+// (owner and group only), otherwise a TCP host:port
+func Listen(addr string) (net.Listener, error) {
+	path, isUnix := strings.CutPrefix(addr, "unix:")
+	if !isUnix {
+		return net.Listen("tcp", addr)
+	}
+
+	// Remove a stale socket left by an unclean exit
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o660); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
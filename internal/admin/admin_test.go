@@ -0,0 +1,257 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeProxy implements Proxy for tests
+type fakeProxy struct {
+	draining    bool
+	purged      []string
+	invalidated []string
+	cleared     int
+}
+
+func (f *fakeProxy) GetCacheStats() (uint64, uint64, int64, int, float64) {
+	return 3, 1, 100, 2, 0.75
+}
+
+func (f *fakeProxy) GetCacheTiers() (string, map[string]uint64) {
+	return "tiered", map[string]uint64{"memory": 2, "redis": 1}
+}
+
+func (f *fakeProxy) GetCacheRejections() uint64 { return 4 }
+
+func (f *fakeProxy) GetCACertificate() []byte { return []byte("-----BEGIN CERTIFICATE-----") }
+
+func (f *fakeProxy) PurgeCache(url string) (int, error) {
+	f.purged = append(f.purged, url)
+	return 1, nil
+}
+
+func (f *fakeProxy) InvalidateCache(pattern string) (int, int, int, error) {
+	if pattern == "/bad" {
+		return 0, 0, 0, errors.New("invalid pattern")
+	}
+	f.invalidated = append(f.invalidated, pattern)
+	return 2, 3, 5, nil
+}
+
+func (f *fakeProxy) ClearCache() error {
+	f.cleared++
+	return nil
+}
+
+func (f *fakeProxy) Draining() bool { return f.draining }
+
+func serve(s *Server, method, target, remoteAddr, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.RemoteAddr = remoteAddr
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTokenAuth(t *testing.T) {
+	s := New(&fakeProxy{}, func() error { return nil }, "1488")
+	s.SetToken("secret")
+
+	tests := []struct {
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{http.MethodGet, "/health", "", http.StatusOK},
+		{http.MethodGet, "/ca.crt", "", http.StatusOK},
+		{http.MethodGet, "/stats", "", http.StatusUnauthorized},
+		{http.MethodGet, "/stats", "wrong", http.StatusUnauthorized},
+		{http.MethodGet, "/stats", "secret", http.StatusOK},
+		{http.MethodPost, "/config/reload", "", http.StatusUnauthorized},
+		{http.MethodPost, "/config/reload", "secret", http.StatusOK},
+		{http.MethodGet, "/config/reload", "secret", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		rec := serve(s, tt.method, tt.path, "10.0.0.5:4000", tt.token)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s (token %q): expected %d, got %d", tt.method, tt.path, tt.token, tt.want, rec.Code)
+		}
+	}
+}
+
+func TestWithoutTokenOnlyLocalClientsChangeState(t *testing.T) {
+	proxy := &fakeProxy{}
+	s := New(proxy, func() error { return nil }, "1488")
+
+	if rec := serve(s, http.MethodGet, "/stats", "10.0.0.5:4000", ""); rec.Code != http.StatusOK {
+		t.Errorf("Remote GET /stats: expected 200, got %d", rec.Code)
+	}
+	if rec := serve(s, http.MethodPost, "/cache/purge?url=http://a/", "10.0.0.5:4000", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Remote purge: expected 403, got %d", rec.Code)
+	}
+	if rec := serve(s, http.MethodPost, "/cache/purge?url=http://a/", "127.0.0.1:4000", ""); rec.Code != http.StatusOK {
+		t.Errorf("Local purge: expected 200, got %d", rec.Code)
+	}
+	if len(proxy.purged) != 1 || proxy.purged[0] != "http://a/" {
+		t.Errorf("Unexpected purges: %v", proxy.purged)
+	}
+}
+
+func TestStatsReportsTierHits(t *testing.T) {
+	s := New(&fakeProxy{}, func() error { return nil }, "1488")
+
+	rec := serve(s, http.MethodGet, "/stats", "127.0.0.1:4000", "")
+	var stats struct {
+		Hits     uint64            `json:"cache_hits"`
+		Rejected uint64            `json:"cache_rejected"`
+		Backend  string            `json:"cache_backend"`
+		TierHits map[string]uint64 `json:"tier_hits"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Invalid /stats JSON %q: %v", rec.Body.String(), err)
+	}
+	if stats.Hits != 3 || stats.Rejected != 4 || stats.Backend != "tiered" || stats.TierHits["memory"] != 2 || stats.TierHits["redis"] != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestInvalidateReportsTierCounts(t *testing.T) {
+	proxy := &fakeProxy{}
+	s := New(proxy, func() error { return nil }, "1488")
+
+	rec := serve(s, http.MethodPost, "/cache/invalidate?pattern="+url.QueryEscape("*.example.com"), "127.0.0.1:4000", "")
+	var result struct {
+		Memory int `json:"memory"`
+		Disk   int `json:"disk"`
+		Redis  int `json:"redis"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d %q", rec.Code, rec.Body.String())
+	}
+	if result.Memory != 2 || result.Disk != 3 || result.Redis != 5 || len(proxy.invalidated) != 1 || proxy.invalidated[0] != "*.example.com" {
+		t.Errorf("Unexpected invalidation %+v %v", result, proxy.invalidated)
+	}
+
+	for _, target := range []string{"/cache/invalidate", "/cache/invalidate?pattern=/bad"} {
+		if rec := serve(s, http.MethodPost, target, "127.0.0.1:4000", ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", target, rec.Code)
+		}
+	}
+
+	if rec := serve(s, http.MethodPost, "/cache/clear", "10.0.0.5:4000", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Remote clear: expected 403, got %d", rec.Code)
+	}
+	if rec := serve(s, http.MethodPost, "/cache/clear", "127.0.0.1:4000", ""); rec.Code != http.StatusOK || proxy.cleared != 1 {
+		t.Errorf("Local clear: got %d, cleared %d times", rec.Code, proxy.cleared)
+	}
+}
+
+func TestReloadErrorAndDraining(t *testing.T) {
+	proxy := &fakeProxy{draining: true}
+	s := New(proxy, func() error { return errors.New("line 3: bad value") }, "1488")
+
+	rec := serve(s, http.MethodPost, "/config/reload", "127.0.0.1:4000", "")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "line 3") {
+		t.Errorf("Expected reload error, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := serve(s, http.MethodGet, "/health", "127.0.0.1:4000", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Draining health: expected 503, got %d", rec.Code)
+	}
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	l, err := Listen("unix:" + path)
+	if err != nil {
+		t.Skipf("Unix sockets unavailable: %v", err)
+	}
+	defer l.Close()
+
+	s := New(&fakeProxy{}, func() error { return nil }, "1488")
+	go http.Serve(l, s)
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+
+	// Unix socket peers count as local
+	resp, err := client.PostForm("http://admin/cache/purge", url.Values{"url": {"http://a/"}})
+	if err != nil {
+		t.Fatalf("Request over Unix socket failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 over Unix socket, got %d", resp.StatusCode)
+	}
+}
+
+func TestPrivateRoutesNeedTokenOrLocalClient(t *testing.T) {
+	s := New(&fakeProxy{}, func() error { return nil }, "1488")
+	s.HandlePrivate("/har/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+
+	if rec := serve(s, http.MethodGet, "/har/download?id=1", "10.0.0.5:4000", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Remote GET /har/download: expected 403, got %d", rec.Code)
+	}
+	if rec := serve(s, http.MethodGet, "/har/download?id=1", "127.0.0.1:4000", ""); rec.Code != http.StatusOK {
+		t.Errorf("Local GET /har/download: expected 200, got %d", rec.Code)
+	}
+
+	s.SetToken("secret")
+	if rec := serve(s, http.MethodGet, "/har/captures", "127.0.0.1:4000", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET /har/captures without token: expected 401, got %d", rec.Code)
+	}
+	if rec := serve(s, http.MethodGet, "/har/captures", "10.0.0.5:4000", "secret"); rec.Code != http.StatusOK {
+		t.Errorf("GET /har/captures with token: expected 200, got %d", rec.Code)
+	}
+}
+
+func TestTokenNeedsBearerScheme(t *testing.T) {
+	s := New(&fakeProxy{}, func() error { return nil }, "1488")
+	s.SetToken("secret")
+
+	for _, header := range []string{"secret", "Basic secret", "Token Bearer secret", "Bearer secret2", "Bearer"} {
+		req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+		req.Header.Set("Authorization", header)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: expected 401, got %d", header, rec.Code)
+		}
+	}
+}
+
+func TestIndexEscapesHost(t *testing.T) {
+	s := New(&fakeProxy{}, func() error { return nil }, "1488")
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = `x"><script>alert(1)</script>`
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if strings.Contains(body, "<script>") {
+		t.Errorf("Host header is not escaped:\n%s", body)
+	}
+	if !strings.Contains(body, "curl -x http://localhost:1488") {
+		t.Errorf("Expected the proxy port on the page:\n%s", body)
+	}
+}
@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"errors"
+	"sort"
+)
+
+// Once a shard is full, TinyLFU admission only lets a new entry in if it
+// has been requested at least as often as the entries it would evict.
+// Request counts come from a frequency sketch per shard, fed by every
+// lookup, so a burst of one-off downloads can no longer push out small
+// objects that are requested all the time.
+
+// ErrNotAdmitted is returned when the admission policy keeps an entry out
+// of the memory tier
+var ErrNotAdmitted = errors.New("cache entry not admitted")
+
+// evictionSample is how many of the least recently used entries are
+// compared when large entries are evicted first
+const evictionSample = 5
+
+// Sketch size in 16-counter words: one per 4KB of shard, i.e. per entry
+// of a typical size, within these bounds
+const (
+	minSketchWords = 1 << 6
+	maxSketchWords = 1 << 18
+)
+
+// frequencySketch is a count-min sketch of 4-bit counters estimating how
+// often a key hash was seen. After sampleSize increments every counter is
+// halved, so old popularity fades. It is guarded by the shard lock.
+type frequencySketch struct {
+	table      []uint64 // 16 counters per word
+	mask       uint32
+	additions  int
+	sampleSize int
+}
+
+func newFrequencySketch(shardSize int64) *frequencySketch {
+	words := minSketchWords
+	for words < maxSketchWords && int64(words)*4096 < shardSize {
+		words *= 2
+	}
+	return &frequencySketch{
+		table:      make([]uint64, words),
+		mask:       uint32(words*16 - 1),
+		sampleSize: 10 * words,
+	}
+}
+
+// index returns the position of hash's counter in row i; rows use
+// independent slots through double hashing on the two halves of hash
+func (f *frequencySketch) index(hash uint64, i uint32) (word int, shift uint) {
+	h := uint32(hash) + i*(uint32(hash>>32)|1)
+	h &= f.mask
+	return int(h >> 4), uint(h&15) * 4
+}
+
+func (f *frequencySketch) increment(hash uint64) {
+	for i := uint32(0); i < 4; i++ {
+		word, shift := f.index(hash, i)
+		if (f.table[word]>>shift)&15 < 15 {
+			f.table[word] += 1 << shift
+		}
+	}
+	if f.additions++; f.additions >= f.sampleSize {
+		f.age()
+	}
+}
+
+// estimate returns the smallest of hash's counters
+func (f *frequencySketch) estimate(hash uint64) int {
+	n := 15
+	for i := uint32(0); i < 4; i++ {
+		word, shift := f.index(hash, i)
+		n = min(n, int((f.table[word]>>shift)&15))
+	}
+	return n
+}
+
+// age halves every counter
+func (f *frequencySketch) age() {
+	for i, w := range f.table {
+		f.table[i] = (w >> 1) & 0x7777777777777777
+	}
+	f.additions /= 2
+}
+
+// victims picks the entries to evict so that needed more bytes fit into s,
+// least recently used first. With evictLargeFirst the largest entries of
+// each evictionSample oldest go first. The caller holds s.mu.
+func (c *HTTPCache) victims(s *cacheShard, needed int64) []*lruNode {
+	batch := 1
+	if c.evictLargeFirst {
+		batch = evictionSample
+	}
+
+	var picked []*lruNode
+	var freed int64
+	for n := s.root.prev; n != &s.root && freed < needed; {
+		start := len(picked)
+		for ; n != &s.root && len(picked)-start < batch; n = n.prev {
+			picked = append(picked, n)
+		}
+		sample := picked[start:]
+		sort.SliceStable(sample, func(i, j int) bool { return sample[i].entry.Size > sample[j].entry.Size })
+		for i, v := range sample {
+			if freed >= needed {
+				picked = picked[:start+i]
+				break
+			}
+			freed += v.entry.Size
+		}
+	}
+	return picked
+}
+
+// admit reports whether an entry with key hash may replace victims
+func (s *cacheShard) admit(hash uint64, victims []*lruNode) bool {
+	if s.sketch == nil {
+		return true
+	}
+	freq := s.sketch.estimate(hash)
+	for _, v := range victims {
+		if s.sketch.estimate(v.hash) > freq {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFrequencySketch(t *testing.T) {
+	f := newFrequencySketch(0)
+	hot, cold := uint64(0x1234567890abcdef), uint64(0xfedcba0987654321)
+
+	for i := 0; i < 20; i++ {
+		f.increment(hot)
+	}
+	f.increment(cold)
+	if n := f.estimate(hot); n != 15 {
+		t.Errorf("Counters should saturate at 15, got %d", n)
+	}
+	if n := f.estimate(cold); n != 1 {
+		t.Errorf("Expected 1 for a key seen once, got %d", n)
+	}
+
+	f.age()
+	if hotN, coldN := f.estimate(hot), f.estimate(cold); hotN != 7 || coldN != 0 {
+		t.Errorf("Aging should halve counters, got %d and %d", hotN, coldN)
+	}
+
+	// Aging happens on its own after sampleSize increments
+	for i := 0; i < f.sampleSize; i++ {
+		f.increment(uint64(i) * 0x9e3779b97f4a7c15)
+	}
+	if n := f.estimate(hot); n >= 7 {
+		t.Errorf("Expected the hot key to fade after a sample period, got %d", n)
+	}
+}
+
+func newAdmissionEntry(size int64) *CacheEntry {
+	return &CacheEntry{ExpireAt: time.Now().Add(time.Minute), Size: size}
+}
+
+func TestAdmissionKeepsHotEntries(t *testing.T) {
+	cache := NewHTTPCacheWithOptions(HTTPCacheOptions{MaxSize: 100, Admission: true})
+	defer cache.Close()
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprint("hot", i)
+		cache.Get(key)
+		cache.Set(key, newAdmissionEntry(10))
+		cache.Get(key)
+		cache.Get(key)
+	}
+
+	// A burst of one-off downloads, each looked up once before being stored
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprint("download", i)
+		cache.Get(key)
+		if err := cache.Set(key, newAdmissionEntry(50)); !errors.Is(err, ErrNotAdmitted) {
+			t.Errorf("%s: expected ErrNotAdmitted, got %v", key, err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if _, found := cache.Get(fmt.Sprint("hot", i)); !found {
+			t.Errorf("hot%d should have survived the burst", i)
+		}
+	}
+	if n := cache.Rejections(); n != 20 {
+		t.Errorf("Expected 20 rejections, got %d", n)
+	}
+	if n := cache.Evictions(); n != 0 {
+		t.Errorf("Expected no evictions, got %d", n)
+	}
+
+	// Once requested more often than its victim, an entry gets in
+	for i := 0; i < 6; i++ {
+		cache.Get("popular")
+	}
+	if err := cache.Set("popular", newAdmissionEntry(10)); err != nil {
+		t.Errorf("Popular entry should be admitted, got %v", err)
+	}
+	// and replacing it needs no admission
+	if err := cache.Set("popular", newAdmissionEntry(10)); err != nil {
+		t.Errorf("Replacing an entry should always succeed, got %v", err)
+	}
+}
+
+func TestAdmissionObjectSizeLimit(t *testing.T) {
+	cache := NewHTTPCacheWithOptions(HTTPCacheOptions{MaxSize: 1000, MaxObjectSize: 100})
+	defer cache.Close()
+
+	if err := cache.Set("large", newAdmissionEntry(101)); !errors.Is(err, ErrNotAdmitted) {
+		t.Errorf("Expected ErrNotAdmitted above the object size limit, got %v", err)
+	}
+	if err := cache.Set("small", newAdmissionEntry(100)); err != nil {
+		t.Errorf("Entry at the limit should be stored, got %v", err)
+	}
+	if n := cache.Rejections(); n != 1 {
+		t.Errorf("Expected 1 rejection, got %d", n)
+	}
+}
+
+func TestEvictLargeFirst(t *testing.T) {
+	tests := []struct {
+		largeFirst bool
+		evicted    string
+	}{
+		{false, "a"},
+		{true, "b"},
+	}
+
+	for _, tt := range tests {
+		cache := NewHTTPCacheWithOptions(HTTPCacheOptions{MaxSize: 100, EvictLargeFirst: tt.largeFirst})
+		for _, e := range []struct {
+			key  string
+			size int64
+		}{{"a", 10}, {"b", 50}, {"c", 20}, {"d", 20}} {
+			cache.Set(e.key, newAdmissionEntry(e.size))
+		}
+
+		cache.Set("e", newAdmissionEntry(10))
+		for _, key := range []string{"a", "b", "c", "d", "e"} {
+			if _, found := cache.Get(key); found == (key == tt.evicted) {
+				t.Errorf("largeFirst=%v: %s cached=%v", tt.largeFirst, key, found)
+			}
+		}
+		cache.Close()
+	}
+}
+
+func BenchmarkCacheSetAdmission(b *testing.B) {
+	const capacity = 50000
+	cache := NewHTTPCacheWithOptions(HTTPCacheOptions{MaxSize: capacity, Admission: true})
+	defer cache.Close()
+	keys := make([]string, 2*capacity)
+	for i := range keys {
+		keys[i] = fmt.Sprint(i)
+	}
+	entry := newAdmissionEntry(1)
+	for _, key := range keys[:capacity] {
+		cache.Set(key, entry)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := keys[i%len(keys)]
+		cache.Get(key)
+		cache.Set(key, entry)
+	}
+}
@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Redis entries are encoded as
+//
+//	version byte | flags byte | header block | body
+//
+// The header block holds uvarint-prefixed fields (status, times, size, URL,
+// headers, body length); the body follows raw, or as one zstd frame when
+// flagZstd is set. Entries written by older releases start with something
+// other than entryFormatVersion (JSON starts with '{') and are treated as
+// misses, so an upgrade repopulates them instead of failing.
+const (
+	entryFormatVersion byte = 1
+
+	flagZstd byte = 1 << 0
+)
+
+// maxBodyHint caps the buffer preallocated for a decompressed body
+const maxBodyHint = 16 << 20
+
+// errEntryFormat marks data that is not an entry in the current format
+var errEntryFormat = errors.New("unknown cache entry format")
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+// zstdCodecs returns the shared encoder and decoder; EncodeAll and
+// DecodeAll are safe for concurrent use
+func zstdCodecs() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+		zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	})
+	return zstdEncoder, zstdDecoder
+}
+
+// encodeEntry serializes entry. Bodies of at least compressMin bytes are
+// zstd-compressed unless they already have a Content-Encoding or don't
+// shrink; compressMin <= 0 disables compression.
+func encodeEntry(entry *CacheEntry, compressMin int) []byte {
+	var flags byte
+	body := entry.Body
+	if compressMin > 0 && len(body) >= compressMin && entry.Headers.Get("Content-Encoding") == "" {
+		enc, _ := zstdCodecs()
+		if packed := enc.EncodeAll(body, make([]byte, 0, len(body)/2)); len(packed) < len(body) {
+			body = packed
+			flags |= flagZstd
+		}
+	}
+
+	buf := make([]byte, 0, 64+len(entry.URL)+headersLen(entry.Headers)+len(body))
+	buf = append(buf, entryFormatVersion, flags)
+	buf = binary.AppendUvarint(buf, uint64(entry.StatusCode))
+	buf = binary.AppendVarint(buf, unixNano(entry.CachedAt))
+	buf = binary.AppendVarint(buf, unixNano(entry.ExpireAt))
+	buf = binary.AppendVarint(buf, entry.Size)
+	buf = appendString(buf, entry.URL)
+	buf = binary.AppendUvarint(buf, uint64(len(entry.Headers)))
+	for name, values := range entry.Headers {
+		buf = appendString(buf, name)
+		buf = binary.AppendUvarint(buf, uint64(len(values)))
+		for _, value := range values {
+			buf = appendString(buf, value)
+		}
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(entry.Body)))
+	return append(buf, body...)
+}
+
+// decodeEntry parses data written by encodeEntry; an uncompressed Body
+// shares data's memory. It returns errEntryFormat for entries of another
+// format version.
+func decodeEntry(data []byte) (*CacheEntry, error) {
+	if len(data) < 2 || data[0] != entryFormatVersion {
+		return nil, errEntryFormat
+	}
+	flags := data[1]
+	d := entryDecoder{data: data[2:]}
+
+	entry := &CacheEntry{
+		StatusCode: int(d.uvarint()),
+		CachedAt:   fromUnixNano(d.varint()),
+		ExpireAt:   fromUnixNano(d.varint()),
+		Size:       d.varint(),
+		URL:        d.string(),
+	}
+	if n := d.count(); n > 0 {
+		entry.Headers = make(http.Header, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			name := d.string()
+			values := make([]string, d.count())
+			for j := range values {
+				values[j] = d.string()
+			}
+			entry.Headers[name] = values
+		}
+	}
+	bodyLen := d.uvarint()
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	body := d.data
+	if flags&flagZstd != 0 {
+		_, dec := zstdCodecs()
+		unpacked, err := dec.DecodeAll(body, make([]byte, 0, min(bodyLen, maxBodyHint)))
+		if err != nil {
+			return nil, fmt.Errorf("corrupt cache entry body: %w", err)
+		}
+		body = unpacked
+	}
+	if uint64(len(body)) != bodyLen {
+		return nil, errors.New("corrupt cache entry: body length mismatch")
+	}
+	entry.Body = body
+	return entry, nil
+}
+
+// unixNano encodes the zero time as 0, which UnixNano doesn't
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func headersLen(h http.Header) int {
+	n := 0
+	for name, values := range h {
+		n += len(name) + 2
+		for _, value := range values {
+			n += len(value) + 2
+		}
+	}
+	return n
+}
+
+// entryDecoder reads header block fields; the first error sticks and
+// later reads return zero values
+type entryDecoder struct {
+	data []byte
+	err  error
+}
+
+var errEntryTruncated = errors.New("corrupt cache entry: truncated")
+
+func (d *entryDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errEntryTruncated
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *entryDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = errEntryTruncated
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// count reads a length or element count; each counted item takes at
+// least a byte, so anything beyond the remaining data is corrupt
+func (d *entryDecoder) count() int {
+	v := d.uvarint()
+	if v > uint64(len(d.data)) {
+		d.err = errEntryTruncated
+		return 0
+	}
+	return int(v)
+}
+
+func (d *entryDecoder) string() string {
+	n := d.count()
+	if d.err != nil {
+		return ""
+	}
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+	return s
+}
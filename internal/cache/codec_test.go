@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newCodecEntry(body []byte) *CacheEntry {
+	now := time.Now()
+	return &CacheEntry{
+		URL:        "https://example.com/app.js?v=2",
+		StatusCode: 200,
+		Headers: http.Header{
+			"Content-Type": {"application/javascript"},
+			"Set-Cookie":   {"a=1", "b=2"},
+			"X-Empty":      {""},
+		},
+		Body:     body,
+		CachedAt: now,
+		ExpireAt: now.Add(time.Minute),
+		Size:     int64(len(body)),
+	}
+}
+
+func TestEntryCodecRoundTrip(t *testing.T) {
+	text := []byte(strings.Repeat("function cached() { return 42; }\n", 200))
+
+	tests := []struct {
+		name        string
+		entry       *CacheEntry
+		compressMin int
+		compressed  bool
+	}{
+		{"raw", newCodecEntry(text), 0, false},
+		{"compressed", newCodecEntry(text), 1024, true},
+		{"below threshold", newCodecEntry([]byte("short")), 1024, false},
+		{"empty body", newCodecEntry(nil), 1, false},
+		{"zero entry", &CacheEntry{}, 1024, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encodeEntry(tt.entry, tt.compressMin)
+			if got := data[1]&flagZstd != 0; got != tt.compressed {
+				t.Errorf("compressed=%v, expected %v", got, tt.compressed)
+			}
+			if tt.compressed && len(data) >= len(tt.entry.Body) {
+				t.Errorf("Compressed entry is %d bytes for a %d byte body", len(data), len(tt.entry.Body))
+			}
+
+			decoded, err := decodeEntry(data)
+			if err != nil {
+				t.Fatalf("decodeEntry: %v", err)
+			}
+			if decoded.URL != tt.entry.URL || decoded.StatusCode != tt.entry.StatusCode || decoded.Size != tt.entry.Size ||
+				!bytes.Equal(decoded.Body, tt.entry.Body) || !reflect.DeepEqual(decoded.Headers, tt.entry.Headers) ||
+				!decoded.CachedAt.Equal(tt.entry.CachedAt) || !decoded.ExpireAt.Equal(tt.entry.ExpireAt) {
+				t.Errorf("Round trip changed the entry:\n got %+v\nwant %+v", decoded, tt.entry)
+			}
+		})
+	}
+
+	// Already encoded or incompressible bodies are stored as they are
+	gzipped := newCodecEntry(text)
+	gzipped.Headers.Set("Content-Encoding", "gzip")
+	if data := encodeEntry(gzipped, 1); data[1]&flagZstd != 0 {
+		t.Error("Content-Encoding bodies should not be compressed again")
+	}
+	random := make([]byte, 4096)
+	rand.Read(random)
+	if data := encodeEntry(newCodecEntry(random), 1); data[1]&flagZstd != 0 {
+		t.Error("Incompressible bodies should be stored raw")
+	}
+}
+
+func TestDecodeEntryRejectsOtherFormats(t *testing.T) {
+	legacy, _ := json.Marshal(newCodecEntry([]byte("old")))
+	if _, err := decodeEntry(legacy); !errors.Is(err, errEntryFormat) {
+		t.Errorf("JSON entry: expected errEntryFormat, got %v", err)
+	}
+	future := encodeEntry(newCodecEntry([]byte("new")), 0)
+	future[0] = entryFormatVersion + 1
+	if _, err := decodeEntry(future); !errors.Is(err, errEntryFormat) {
+		t.Errorf("Newer version: expected errEntryFormat, got %v", err)
+	}
+
+	data := encodeEntry(newCodecEntry([]byte(strings.Repeat("x", 2048))), 1024)
+	for _, n := range []int{1, 5, 40, len(data) - 3} {
+		if _, err := decodeEntry(data[:n]); err == nil {
+			t.Errorf("Truncated to %d bytes: expected an error", n)
+		}
+	}
+}
+
+func TestRedisSkipsLegacyEntries(t *testing.T) {
+	mr, backend := newTestRedis(t)
+	legacy, _ := json.Marshal(newCodecEntry([]byte("old")))
+	mr.HSet(backend.entryKey(context.Background(), "key"), "url", "https://example.com/", "entry", string(legacy))
+
+	if entry, err := backend.Get("key"); entry != nil || err != nil {
+		t.Errorf("Legacy entry should be a miss, got %v %v", entry, err)
+	}
+	if err := backend.Set("key", newCodecEntry([]byte("new")), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := backend.Get("key"); err != nil || string(entry.Body) != "new" {
+		t.Errorf("Rewritten entry: %v %v", entry, err)
+	}
+}
+
+func benchmarkEntry() *CacheEntry {
+	return newCodecEntry([]byte(strings.Repeat(`{"id":1,"name":"cached","tags":["a","b"]},`, 1500)))
+}
+
+func BenchmarkEntryEncodeJSON(b *testing.B) {
+	entry := benchmarkEntry()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		data, _ := json.Marshal(entry)
+		b.SetBytes(int64(len(data)))
+	}
+}
+
+func BenchmarkEntryEncodeBinary(b *testing.B) {
+	entry := benchmarkEntry()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.SetBytes(int64(len(encodeEntry(entry, 0))))
+	}
+}
+
+func BenchmarkEntryEncodeZstd(b *testing.B) {
+	entry := benchmarkEntry()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.SetBytes(int64(len(encodeEntry(entry, 1024))))
+	}
+}
+
+func BenchmarkEntryDecodeJSON(b *testing.B) {
+	data, _ := json.Marshal(benchmarkEntry())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var entry CacheEntry
+		_ = json.Unmarshal(data, &entry)
+	}
+}
+
+func BenchmarkEntryDecodeBinary(b *testing.B) {
+	data := encodeEntry(benchmarkEntry(), 0)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = decodeEntry(data)
+	}
+}
+
+func BenchmarkEntryDecodeZstd(b *testing.B) {
+	data := encodeEntry(benchmarkEntry(), 1024)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = decodeEntry(data)
+	}
+}
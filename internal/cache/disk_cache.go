@@ -0,0 +1,527 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A disk cache file holds one entry:
+//
+//	"4ebd" | uint32 metadata length | metadata | body
+//
+// The metadata is the key followed by the entry without its body in the
+// Redis entry format (see codec.go); the body follows raw so it can be
+// sent straight from the file. A file whose length doesn't match its
+// metadata is torn and gets removed.
+const (
+	diskMagic   = "4ebd"
+	maxDiskMeta = 1 << 20
+
+	diskTempPrefix = ".tmp-"
+)
+
+// diskAsyncWrites bounds the background writes of SetAsync; writes beyond
+// it are dropped rather than queued
+const diskAsyncWrites = 4
+
+// diskInlineBody is the largest body Get reads into memory; larger entries
+// are streamed from their file when served
+const diskInlineBody = 256 << 10
+
+var errDiskEntryCorrupt = errors.New("corrupt disk cache file")
+
+// ErrBodyGone is returned when a disk cache entry's file was evicted or
+// replaced between the lookup and serving it
+var ErrBodyGone = errors.New("cached body is no longer on disk")
+
+// DiskCache keeps entries as files under a directory, named after the
+// SHA-256 of their key. Files are written to a temporary name and renamed
+// into place, so a crash leaves the old entry or the new one but never a
+// torn one. An index in memory tracks sizes and recency to keep the
+// directory under its size limit; it is rebuilt from the files on startup.
+type DiskCache struct {
+	dir        string
+	mu         sync.Mutex
+	index      *cacheShard // entries hold metadata only
+	hitCount   atomic.Uint64
+	missCount  atomic.Uint64
+	evictCount atomic.Uint64
+	closed     bool          // guarded by mu; no more async writes
+	writes     chan struct{} // slots for async writes
+	writing    sync.WaitGroup
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+// NewDiskCache opens (creating if needed) a disk cache in dir holding up
+// to maxSize bytes of bodies, indexing the entries already there
+func NewDiskCache(dir string, maxSize int64) (*DiskCache, error) {
+	if maxSize <= 0 {
+		return nil, errors.New("disk cache: size must be positive")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("disk cache: %w", err)
+	}
+
+	c := &DiskCache{
+		dir:    dir,
+		index:  newCacheShard(maxSize, false),
+		writes: make(chan struct{}, diskAsyncWrites),
+		stop:   make(chan struct{}),
+	}
+	start := time.Now()
+	if err := c.load(); err != nil {
+		return nil, fmt.Errorf("disk cache: %w", err)
+	}
+	log.Printf("💾 Disk cache %s: %d entries, %.1f MB indexed in %v",
+		dir, len(c.index.nodes), float64(c.index.size)/(1024*1024), time.Since(start).Round(time.Millisecond))
+
+	go c.cleanupExpired()
+	return c, nil
+}
+
+// path returns the file of key
+func (c *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+// load rebuilds the index from the files in the cache directory, oldest
+// modification first, removing leftover temporary files and entries that
+// are torn, expired or misplaced
+func (c *DiskCache) load() error {
+	type found struct {
+		key     string
+		entry   *CacheEntry
+		modTime time.Time
+	}
+	var files []found
+	now := time.Now()
+
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), diskTempPrefix) {
+			os.Remove(path) // interrupted write
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // removed meanwhile
+		}
+		key, entry, err := readDiskFile(path, false)
+		if err != nil || path != c.path(key) || now.After(entry.keepUntil()) {
+			os.Remove(path)
+			return nil
+		}
+		files = append(files, found{key, metadataOnly(entry), info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		c.insert(f.key, f.entry)
+	}
+	// The size limit may have shrunk since the files were written
+	for c.index.size > c.index.maxSize {
+		c.remove(c.index.root.prev)
+		c.evictCount.Add(1)
+	}
+	return nil
+}
+
+// readDiskFile reads the cache file at path. With withBody, bodies up to
+// diskInlineBody are loaded and larger ones are read from the file when
+// served.
+func readDiskFile(path string, withBody bool) (string, *CacheEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	key, entry, err := readDiskHeader(f)
+	if err != nil {
+		return "", nil, err
+	}
+	switch {
+	case !withBody:
+	case entry.Size <= diskInlineBody:
+		entry.Body = make([]byte, entry.Size)
+		if _, err := io.ReadFull(f, entry.Body); err != nil {
+			return "", nil, errDiskEntryCorrupt
+		}
+	default:
+		entry.bodyFile = path
+		entry.bodyCachedAt = entry.CachedAt
+	}
+	return key, entry, nil
+}
+
+// readDiskHeader parses the metadata of the cache file f, leaving f at
+// the start of the body
+func readDiskHeader(f *os.File) (string, *CacheEntry, error) {
+	var prefix [8]byte
+	if _, err := io.ReadFull(f, prefix[:]); err != nil || string(prefix[:4]) != diskMagic {
+		return "", nil, errDiskEntryCorrupt
+	}
+	n := binary.BigEndian.Uint32(prefix[4:])
+	if n > maxDiskMeta {
+		return "", nil, errDiskEntryCorrupt
+	}
+	meta := make([]byte, n)
+	if _, err := io.ReadFull(f, meta); err != nil {
+		return "", nil, errDiskEntryCorrupt
+	}
+
+	d := entryDecoder{data: meta}
+	key := d.string()
+	if d.err != nil {
+		return "", nil, errDiskEntryCorrupt
+	}
+	entry, err := decodeEntry(d.data)
+	if err != nil {
+		return "", nil, errDiskEntryCorrupt
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+	if info.Size() != int64(len(prefix))+int64(n)+entry.Size {
+		return "", nil, errDiskEntryCorrupt
+	}
+	return key, entry, nil
+}
+
+// encodeDiskHeader returns everything of key's cache file but the body
+func encodeDiskHeader(key string, entry *CacheEntry) []byte {
+	meta := appendString(nil, key)
+	meta = append(meta, encodeEntry(metadataOnly(entry), 0)...)
+
+	header := make([]byte, 8, 8+len(meta))
+	copy(header, diskMagic)
+	binary.BigEndian.PutUint32(header[4:], uint32(len(meta)))
+	return append(header, meta...)
+}
+
+// metadataOnly returns a copy of entry without its body
+func metadataOnly(entry *CacheEntry) *CacheEntry {
+	meta := *entry
+	meta.Body = nil
+	meta.bodyFile = ""
+	meta.bodyCachedAt = time.Time{}
+	return &meta
+}
+
+// Get reads the entry of key from disk and marks it as recently used.
+// Like HTTPCache.Get it returns expired entries kept for revalidation.
+func (c *DiskCache) Get(key string) (*CacheEntry, bool) {
+	c.mu.Lock()
+	n, exists := c.index.nodes[key]
+	if exists && time.Now().After(n.entry.keepUntil()) {
+		c.remove(n)
+		exists = false
+	}
+	if exists {
+		c.index.moveToFront(n)
+	}
+	c.mu.Unlock()
+
+	if exists {
+		storedKey, entry, err := readDiskFile(c.path(key), true)
+		if err == nil && storedKey == key {
+			if entry.Fresh() {
+				c.hitCount.Add(1)
+			} else {
+				c.missCount.Add(1)
+			}
+			return entry, true
+		}
+		// Removed behind our back, or corrupt
+		c.mu.Lock()
+		if c.index.nodes[key] == n {
+			c.remove(n)
+		}
+		c.mu.Unlock()
+	}
+
+	c.missCount.Add(1)
+	return nil, false
+}
+
+// Set writes entry to disk, evicting the least recently used files to
+// make room. A body streamed from a disk cache file, as in an entry
+// refreshed by revalidation, is copied from that file.
+func (c *DiskCache) Set(key string, entry *CacheEntry) error {
+	meta := metadataOnly(entry)
+	var body io.Reader
+	if entry.bodyFile != "" {
+		f, err := entry.openBodyFile()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		body = f
+	} else {
+		meta.Size = int64(len(entry.Body))
+		body = bytes.NewReader(entry.Body)
+	}
+	if meta.Size > c.index.maxSize {
+		return ErrEntryTooLarge
+	}
+
+	path := c.path(key)
+	tmp, err := c.writeTemp(path, key, meta, body)
+	if err != nil {
+		return fmt.Errorf("disk cache write: %w", err)
+	}
+
+	c.mu.Lock()
+	if err := os.Rename(tmp, path); err != nil {
+		c.mu.Unlock()
+		os.Remove(tmp)
+		return fmt.Errorf("disk cache write: %w", err)
+	}
+	if old, exists := c.index.nodes[key]; exists {
+		c.unindex(old) // its file was just replaced
+	}
+	for c.index.size+meta.Size > c.index.maxSize {
+		c.remove(c.index.root.prev)
+		c.evictCount.Add(1)
+	}
+	c.insert(key, meta)
+	c.mu.Unlock()
+
+	// The rename is only durable once the directory is flushed
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("disk cache write: %w", err)
+	}
+	return nil
+}
+
+// SetAsync stores entry in the background, so the caller doesn't wait for
+// the disk. It reports false, storing nothing, when diskAsyncWrites writes
+// are already pending or the cache is closed.
+func (c *DiskCache) SetAsync(key string, entry *CacheEntry) bool {
+	select {
+	case c.writes <- struct{}{}:
+	default:
+		return false
+	}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		<-c.writes
+		return false
+	}
+	c.writing.Add(1)
+	c.mu.Unlock()
+
+	go func() {
+		defer func() {
+			<-c.writes
+			c.writing.Done()
+		}()
+		if err := c.Set(key, entry); err != nil && !errors.Is(err, ErrEntryTooLarge) {
+			log.Printf("⚠️  Disk cache: %v", err)
+		}
+	}()
+	return true
+}
+
+// syncDir flushes the directory entries of dir to the device
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeTemp writes the cache file of key next to path and flushes it to
+// the device, returning the temporary name
+func (c *DiskCache) writeTemp(path, key string, meta *CacheEntry, body io.Reader) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), diskTempPrefix+"*")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(encodeDiskHeader(key, meta))
+	if err == nil {
+		var n int64
+		if n, err = io.Copy(f, io.LimitReader(body, meta.Size)); err == nil && n != meta.Size {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// insert indexes key as the most recently used entry; the caller holds
+// c.mu
+func (c *DiskCache) insert(key string, meta *CacheEntry) {
+	n := &lruNode{key: key, entry: meta}
+	c.index.nodes[key] = n
+	c.index.pushFront(n)
+	c.index.size += meta.Size
+}
+
+// unindex drops n from the index, leaving its file; the caller holds c.mu
+func (c *DiskCache) unindex(n *lruNode) {
+	c.index.unlink(n)
+	delete(c.index.nodes, n.key)
+	c.index.size -= n.entry.Size
+}
+
+// remove drops n and deletes its file; the caller holds c.mu
+func (c *DiskCache) remove(n *lruNode) {
+	c.unindex(n)
+	if err := os.Remove(c.path(n.key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("⚠️  Disk cache: %v", err)
+	}
+}
+
+// Delete removes the entry of key
+func (c *DiskCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if n, exists := c.index.nodes[key]; exists {
+		c.remove(n)
+	}
+}
+
+// PurgeURL removes every entry cached for url and returns how many were removed
+func (c *DiskCache) PurgeURL(url string) int {
+	return c.PurgeMatch(func(entryURL string) bool { return entryURL == url })
+}
+
+// PurgeMatch removes every entry whose request URL matches and returns how
+// many were removed
+func (c *DiskCache) PurgeMatch(match func(url string) bool) int {
+	return c.removeWhere(func(n *lruNode) bool { return match(n.entry.URL) })
+}
+
+func (c *DiskCache) removeWhere(match func(*lruNode) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for _, n := range c.index.nodes {
+		if match(n) {
+			c.remove(n)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Clear removes all entries
+func (c *DiskCache) Clear() {
+	c.removeWhere(func(*lruNode) bool { return true })
+}
+
+// Stats returns cache statistics
+func (c *DiskCache) Stats() (hits, misses uint64, size int64, entries int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.hitCount.Load(), c.missCount.Load(), c.index.size, len(c.index.nodes)
+}
+
+// Evictions returns how many entries were evicted to make room
+func (c *DiskCache) Evictions() uint64 {
+	return c.evictCount.Load()
+}
+
+// cleanupExpired removes expired entries periodically
+func (c *DiskCache) cleanupExpired() {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			c.removeWhere(func(n *lruNode) bool { return now.After(n.entry.keepUntil()) })
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Close stops the cleanup goroutine and waits for background writes; the
+// files stay for the next start
+func (c *DiskCache) Close() {
+	c.stopOnce.Do(func() { close(c.stop) })
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.writing.Wait()
+}
+
+// OpenBody returns a reader of the entry's body, which for large disk
+// cache entries is the cache file. It fails with ErrBodyGone if that file
+// was evicted or replaced since the lookup.
+func (e *CacheEntry) OpenBody() (io.ReadCloser, error) {
+	if e.bodyFile == "" {
+		return io.NopCloser(bytes.NewReader(e.Body)), nil
+	}
+	f, err := e.openBodyFile()
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, e.Size), f}, nil
+}
+
+// openBodyFile opens the cache file of a large disk entry, positioned at
+// the body
+func (e *CacheEntry) openBodyFile() (*os.File, error) {
+	f, err := os.Open(e.bodyFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBodyGone, err)
+	}
+	_, current, err := readDiskHeader(f)
+	if err != nil || current.Size != e.Size || !current.CachedAt.Equal(e.bodyCachedAt) {
+		f.Close()
+		return nil, ErrBodyGone
+	}
+	return f, nil
+}
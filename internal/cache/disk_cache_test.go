@@ -0,0 +1,290 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestDiskCache(t *testing.T, dir string, maxSize int64) *DiskCache {
+	t.Helper()
+	c, err := NewDiskCache(dir, maxSize)
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func TestDiskCacheRoundTrip(t *testing.T) {
+	c := newTestDiskCache(t, t.TempDir(), 10<<20)
+
+	small := newTestEntryFor("https://example.com/small", "small body")
+	small.Headers = map[string][]string{"Content-Type": {"text/plain"}}
+	large := newTestEntryFor("https://example.com/large", string(bytes.Repeat([]byte("x"), diskInlineBody+1)))
+	for key, entry := range map[string]*CacheEntry{"small": small, "large": large} {
+		if err := c.Set(key, entry); err != nil {
+			t.Fatalf("Set %s: %v", key, err)
+		}
+	}
+
+	got, found := c.Get("small")
+	if !found || string(got.Body) != "small body" || got.bodyFile != "" {
+		t.Fatalf("Small entry: found=%v entry=%+v", found, got)
+	}
+	if got.URL != small.URL || got.Headers.Get("Content-Type") != "text/plain" || !got.CachedAt.Equal(small.CachedAt) {
+		t.Errorf("Metadata not preserved: %+v", got)
+	}
+
+	got, found = c.Get("large")
+	if !found || len(got.Body) != 0 || got.bodyFile == "" || got.Size != large.Size {
+		t.Fatalf("Large entry should stay on disk: found=%v size=%d", found, got.Size)
+	}
+	body, err := got.OpenBody()
+	if err != nil {
+		t.Fatalf("OpenBody: %v", err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if !bytes.Equal(data, large.Body) {
+		t.Errorf("Streamed body differs: got %d bytes", len(data))
+	}
+
+	if _, found := c.Get("missing"); found {
+		t.Error("Unknown key should miss")
+	}
+	hits, misses, size, entries := c.Stats()
+	if hits != 2 || misses != 1 || entries != 2 || size != small.Size+large.Size {
+		t.Errorf("Stats: hits=%d misses=%d size=%d entries=%d", hits, misses, size, entries)
+	}
+}
+
+func TestDiskCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newTestDiskCache(t, t.TempDir(), 30)
+
+	for _, key := range []string{"a", "b", "c"} {
+		c.Set(key, newTestEntry("0123456789"))
+	}
+	c.Get("a")
+	c.Set("d", newTestEntry("0123456789"))
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if _, found := c.Get(key); found == (key == "b") {
+			t.Errorf("%s cached=%v", key, found)
+		}
+	}
+	if _, err := os.Stat(c.path("b")); !os.IsNotExist(err) {
+		t.Errorf("Evicted entry's file should be removed, got %v", err)
+	}
+	if n := c.Evictions(); n != 1 {
+		t.Errorf("Expected 1 eviction, got %d", n)
+	}
+	if err := c.Set("huge", newTestEntry(string(make([]byte, 31)))); !errors.Is(err, ErrEntryTooLarge) {
+		t.Errorf("Expected ErrEntryTooLarge, got %v", err)
+	}
+}
+
+func TestDiskCacheRebuildsIndex(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestDiskCache(t, dir, 1<<20)
+	first.Set("kept", newTestEntryFor("https://example.com/kept", "kept"))
+	first.Set("torn", newTestEntry("torn body"))
+	expired := newTestEntry("expired")
+	expired.ExpireAt = time.Now().Add(-time.Second)
+	first.Set("expired", expired)
+	first.Close()
+
+	// A crash mid-write and a file truncated behind the cache's back
+	tmp := filepath.Join(dir, "ab", diskTempPrefix+"123")
+	os.MkdirAll(filepath.Dir(tmp), 0o755)
+	os.WriteFile(tmp, []byte("partial"), 0o644)
+	info, _ := os.Stat(first.path("torn"))
+	os.Truncate(first.path("torn"), info.Size()-1)
+
+	second := newTestDiskCache(t, dir, 1<<20)
+	if entry, found := second.Get("kept"); !found || string(entry.Body) != "kept" || entry.URL != "https://example.com/kept" {
+		t.Errorf("Entry should survive a restart: found=%v", found)
+	}
+	if _, _, _, entries := second.Stats(); entries != 1 {
+		t.Errorf("Expected 1 entry after reload, got %d", entries)
+	}
+	for _, path := range []string{tmp, first.path("torn"), first.path("expired")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed on startup, got %v", path, err)
+		}
+	}
+}
+
+func TestDiskCacheShrinksOnStartup(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestDiskCache(t, dir, 1<<20)
+	first.Set("old", newTestEntry("0123456789"))
+	first.Set("new", newTestEntry("0123456789"))
+	// Order by modification time regardless of timestamp resolution
+	hourAgo := time.Now().Add(-time.Hour)
+	os.Chtimes(first.path("old"), hourAgo, hourAgo)
+	first.Close()
+
+	second := newTestDiskCache(t, dir, 15)
+	if _, found := second.Get("old"); found {
+		t.Error("Oldest entry should be evicted to fit the smaller limit")
+	}
+	if _, found := second.Get("new"); !found {
+		t.Error("Newest entry should be kept")
+	}
+}
+
+func TestDiskCacheBodyGone(t *testing.T) {
+	c := newTestDiskCache(t, t.TempDir(), 10<<20)
+	c.Set("large", newTestEntry(string(make([]byte, diskInlineBody+1))))
+
+	entry, found := c.Get("large")
+	if !found {
+		t.Fatal("Large entry should be cached")
+	}
+	// Replaced by a newer response before it was served
+	c.Set("large", newTestEntry(string(make([]byte, diskInlineBody+2))))
+
+	if _, err := entry.OpenBody(); !errors.Is(err, ErrBodyGone) {
+		t.Errorf("OpenBody: expected ErrBodyGone, got %v", err)
+	}
+	rec := httptest.NewRecorder()
+	if err := entry.WriteToResponse(rec); !errors.Is(err, ErrBodyGone) {
+		t.Errorf("WriteToResponse: expected ErrBodyGone, got %v", err)
+	}
+	if rec.Code != 200 || rec.Body.Len() != 0 || len(rec.Header()) != 0 {
+		t.Error("Nothing should be written when the body is gone")
+	}
+
+	c.Delete("large")
+	if _, err := entry.OpenBody(); !errors.Is(err, ErrBodyGone) {
+		t.Errorf("OpenBody after Delete: expected ErrBodyGone, got %v", err)
+	}
+}
+
+func TestDiskCacheWriteToResponse(t *testing.T) {
+	c := newTestDiskCache(t, t.TempDir(), 10<<20)
+	body := bytes.Repeat([]byte("0123456789"), diskInlineBody/10+1)
+	stored := newTestEntry(string(body))
+	stored.Headers = map[string][]string{"Content-Length": {fmt.Sprint(len(body))}}
+	c.Set("large", stored)
+
+	entry, _ := c.Get("large")
+	rec := httptest.NewRecorder()
+	if err := entry.WriteToResponse(rec); err != nil {
+		t.Fatalf("WriteToResponse: %v", err)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), body) {
+		t.Errorf("Served body differs: got %d bytes", rec.Body.Len())
+	}
+	if v := rec.Header().Values("Content-Length"); len(v) != 1 || v[0] != fmt.Sprint(len(body)) {
+		t.Errorf("Content-Length = %v", v)
+	}
+	if rec.Header().Get("X-Cache") != "HIT" {
+		t.Error("Missing X-Cache header")
+	}
+}
+
+func TestDiskCachePurge(t *testing.T) {
+	c := newTestDiskCache(t, t.TempDir(), 1<<20)
+	c.Set("js", newTestEntryFor("https://example.com/app.js", "js"))
+	c.Set("css", newTestEntryFor("https://example.com/app.css", "css"))
+
+	if n := c.PurgeURL("https://example.com/app.js"); n != 1 {
+		t.Errorf("PurgeURL removed %d entries", n)
+	}
+	if _, found := c.Get("css"); !found {
+		t.Error("Other entries should survive a purge")
+	}
+	c.Clear()
+	if _, _, size, entries := c.Stats(); entries != 0 || size != 0 {
+		t.Errorf("Clear left %d entries, %d bytes", entries, size)
+	}
+}
+
+func TestTieredCacheWithDisk(t *testing.T) {
+	_, redis := newTestRedis(t)
+	dir := t.TempDir()
+
+	// Another instance stored the entry: only Redis has it
+	writer := NewTieredCache(nil, redis, time.Minute)
+	writer.Set("key", newTestEntryFor("https://example.com/", "shared"))
+
+	l1 := NewHTTPCache(1<<20, time.Minute)
+	defer l1.Close()
+	tc := NewTieredCache(l1, redis, time.Minute).WithDisk(newTestDiskCache(t, dir, 1<<20))
+	if _, found := tc.Get("key"); !found {
+		t.Fatal("Redis hit expected")
+	}
+	tc.Disk().writing.Wait() // promotion is written in the background
+	if _, found := tc.Disk().Get("key"); !found {
+		t.Error("Redis hits should be promoted to disk")
+	}
+
+	// After a restart the entry comes from disk even without Redis
+	tc.Disk().Close()
+	restarted := NewTieredCache(nil, nil, time.Minute).WithDisk(newTestDiskCache(t, dir, 1<<20))
+	if entry, found := restarted.Get("key"); !found || string(entry.Body) != "shared" {
+		t.Fatalf("Disk hit expected after restart: found=%v", found)
+	}
+	if n := restarted.DiskHits(); n != 1 {
+		t.Errorf("Expected 1 disk hit, got %d", n)
+	}
+
+	memory, disk, _, err := restarted.InvalidatePattern(context.Background(), "https://example.com/*")
+	if err != nil || memory != 0 || disk != 1 {
+		t.Errorf("InvalidatePattern: memory=%d disk=%d err=%v", memory, disk, err)
+	}
+	if _, found := restarted.Get("key"); found {
+		t.Error("Invalidated entry should be gone from disk")
+	}
+}
+
+func BenchmarkDiskCacheGet(b *testing.B) {
+	c, err := NewDiskCache(b.TempDir(), 1<<30)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.Close()
+	c.Set("key", newTestEntry(string(make([]byte, 16<<10))))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Get("key")
+	}
+}
+
+func TestDiskCacheSetAsync(t *testing.T) {
+	c := newTestDiskCache(t, t.TempDir(), 1<<20)
+	if !c.SetAsync("key", newTestEntry("body")) {
+		t.Fatal("SetAsync should accept a write")
+	}
+	c.writing.Wait()
+	if entry, found := c.Get("key"); !found || string(entry.Body) != "body" {
+		t.Errorf("Background write not stored: found=%v", found)
+	}
+
+	// Writes beyond the bound are dropped, not queued
+	for i := 0; i < diskAsyncWrites; i++ {
+		c.writes <- struct{}{}
+	}
+	if c.SetAsync("dropped", newTestEntry("body")) {
+		t.Error("SetAsync should drop writes when every slot is busy")
+	}
+	for i := 0; i < diskAsyncWrites; i++ {
+		<-c.writes
+	}
+
+	c.Close()
+	if c.SetAsync("closed", newTestEntry("body")) {
+		t.Error("SetAsync should refuse writes after Close")
+	}
+}
@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// FillBody streams a response body to its reader while copying it into a
+// cache entry. Once the body passes the per-object size limit the copy is
+// dropped and the response keeps streaming uncached. The entry is handed to
+// store only when the body was read completely. store runs inside the Read
+// that hit EOF, so it should hand slow writes off to another goroutine.
+type FillBody struct {
+	body          io.ReadCloser
+	buf           bytes.Buffer
+	maxObjectSize int64
+	abandoned     bool
+	done          bool
+	store         func(*CacheEntry)
+
+	statusCode   int
+	headers      http.Header
+	responseTime time.Time
+	maxAge       time.Duration
+}
+
+// NewFillBody wraps resp.Body so that reading it fills the cache. A
+// maxObjectSize of 0 disables the per-object limit.
+func NewFillBody(resp *http.Response, maxAge time.Duration, maxObjectSize int64, store func(*CacheEntry)) *FillBody {
+	f := &FillBody{
+		body:          resp.Body,
+		maxObjectSize: maxObjectSize,
+		store:         store,
+		statusCode:    resp.StatusCode,
+		headers:       resp.Header.Clone(),
+		responseTime:  time.Now(),
+		maxAge:        maxAge,
+	}
+
+	// Known to be too large: don't even start buffering
+	if maxObjectSize > 0 && resp.ContentLength > maxObjectSize {
+		f.abandoned = true
+	}
+
+	return f
+}
+
+// Read reads from the upstream body, copying into the pending entry
+func (f *FillBody) Read(p []byte) (int, error) {
+	n, err := f.body.Read(p)
+
+	if n > 0 && !f.abandoned {
+		if f.maxObjectSize > 0 && int64(f.buf.Len()+n) > f.maxObjectSize {
+			f.Abandon()
+		} else {
+			f.buf.Write(p[:n])
+		}
+	}
+
+	if errors.Is(err, io.EOF) {
+		f.complete()
+	}
+
+	return n, err
+}
+
+// Close closes the upstream body; a partially read body is never cached
+func (f *FillBody) Close() error {
+	f.Abandon()
+	return f.body.Close()
+}
+
+// Abandon stops caching and releases the buffered copy
+func (f *FillBody) Abandon() {
+	f.abandoned = true
+	f.buf = bytes.Buffer{}
+}
+
+// Abandoned reports whether the body will not be cached
+func (f *FillBody) Abandoned() bool {
+	return f.abandoned
+}
+
+func (f *FillBody) complete() {
+	if f.done || f.abandoned {
+		return
+	}
+	f.done = true
+
+	body := f.buf.Bytes()
+	now := time.Now()
+	f.store(&CacheEntry{
+		StatusCode: f.statusCode,
+		Headers:    f.headers,
+		Body:       body,
+		CachedAt:   now,
+		ExpireAt:   expiresAt(f.headers, f.responseTime, f.maxAge),
+		Size:       int64(len(body)),
+	})
+
+	// The entry owns the bytes now
+	f.abandoned = true
+	f.buf = bytes.Buffer{}
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestResponse(body string, contentLength int64) *http.Response {
+	return &http.Response{
+		StatusCode:    200,
+		Header:        http.Header{"Content-Type": []string{"text/plain"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: contentLength,
+	}
+}
+
+func TestFillBodyCachesCompleteBody(t *testing.T) {
+	var stored *CacheEntry
+	resp := newTestResponse("hello world", -1)
+	body := NewFillBody(resp, time.Minute, 1024, func(e *CacheEntry) { stored = e })
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	body.Close()
+
+	if string(data) != "hello world" {
+		t.Errorf("Client got %q", data)
+	}
+	if stored == nil {
+		t.Fatal("Entry was not stored")
+	}
+	if string(stored.Body) != "hello world" || stored.Size != 11 {
+		t.Errorf("Unexpected entry body %q size %d", stored.Body, stored.Size)
+	}
+	if stored.Headers.Get("Content-Type") != "text/plain" {
+		t.Error("Headers were not copied")
+	}
+}
+
+func TestFillBodyAbandonsOversizedBody(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentLength int64
+	}{
+		{"unknown length", -1},
+		{"declared length", 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := false
+			resp := newTestResponse(strings.Repeat("x", 20), tt.contentLength)
+			body := NewFillBody(resp, time.Minute, 10, func(*CacheEntry) { stored = true })
+
+			data, _ := io.ReadAll(body)
+			body.Close()
+
+			if len(data) != 20 {
+				t.Errorf("Client should still get the full body, got %d bytes", len(data))
+			}
+			if stored {
+				t.Error("Oversized body should not be cached")
+			}
+			if !body.Abandoned() {
+				t.Error("Body should be marked abandoned")
+			}
+		})
+	}
+}
+
+func TestFillBodyPartialReadNotCached(t *testing.T) {
+	stored := false
+	resp := newTestResponse("partial body", -1)
+	body := NewFillBody(resp, time.Minute, 0, func(*CacheEntry) { stored = true })
+
+	buf := make([]byte, 4)
+	body.Read(buf)
+	body.Close()
+
+	if stored {
+		t.Error("Partially read body should not be cached")
+	}
+}
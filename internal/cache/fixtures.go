@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FixtureStore keeps recorded responses on disk for record/replay mode.
+// Unlike HTTPCache it stores every response, whatever its status and
+// cache headers, and entries never expire.
+type FixtureStore struct {
+	dir          string
+	matchBody    bool
+	ignoreParams map[string]bool
+}
+
+// fixtureFile is the on-disk form of one fixture; the request part is for
+// people reading the store, lookups only use the file name
+type fixtureFile struct {
+	Request struct {
+		Method string `json:"method"`
+		URL    string `json:"url"`
+	} `json:"request"`
+	RecordedAt time.Time   `json:"recorded_at"`
+	Response   *CacheEntry `json:"response"`
+}
+
+// NewFixtureStore opens (creating if needed) a fixture directory. With
+// matchBody the request body is part of the key; ignoreParams lists query
+// parameters, such as timestamps or nonces, left out of the key.
+func NewFixtureStore(dir string, matchBody bool, ignoreParams []string) (*FixtureStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("fixture store: %w", err)
+	}
+	s := &FixtureStore{dir: dir, matchBody: matchBody, ignoreParams: make(map[string]bool)}
+	for _, name := range ignoreParams {
+		s.ignoreParams[name] = true
+	}
+	return s, nil
+}
+
+// Key returns the fixture key of r: GenerateKey of the normalised request
+// plus, with body matching, a hash of the body. The body is read and
+// replaced so the request can still be forwarded.
+func (s *FixtureStore) Key(r *http.Request) (string, error) {
+	key := GenerateKey(s.normalize(r))
+	if !s.matchBody {
+		return key, nil
+	}
+
+	bodyHash, err := s.bodyHash(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(key + ":" + bodyHash))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// normalize returns a shallow copy of r whose URL has a lower-case host
+// without default port, no fragment, sorted query parameters and none of
+// the ignored ones
+func (s *FixtureStore) normalize(r *http.Request) *http.Request {
+	u := *r.URL
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && strings.HasSuffix(u.Host, ":80")) || (u.Scheme == "https" && strings.HasSuffix(u.Host, ":443")) {
+		u.Host = u.Host[:strings.LastIndexByte(u.Host, ':')]
+	}
+	u.Fragment, u.RawFragment = "", ""
+
+	query := u.Query()
+	for name := range s.ignoreParams {
+		query.Del(name)
+	}
+	u.RawQuery = query.Encode() // sorted by key
+
+	return &http.Request{Method: r.Method, URL: &u, Header: r.Header}
+}
+
+// bodyHash reads r.Body and hashes it; JSON bodies are compacted first so
+// formatting differences do not change the key
+func (s *FixtureStore) bodyHash(r *http.Request) (string, error) {
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return "", fmt.Errorf("read request body: %w", err)
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	if len(body) == 0 {
+		return "", nil
+	}
+
+	normalized := body
+	var compact bytes.Buffer
+	if json.Valid(body) && json.Compact(&compact, body) == nil {
+		normalized = compact.Bytes()
+	}
+	sum := sha256.Sum256(normalized)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// path returns the file of key, grouped by the host of r so the store can
+// be browsed and pruned by hand
+func (s *FixtureStore) path(r *http.Request, key string) string {
+	host := strings.NewReplacer(":", "_", "/", "_", `\`, "_").Replace(s.normalize(r).URL.Host)
+	if host == "" || host == "." || host == ".." {
+		host = "_"
+	}
+	return filepath.Join(s.dir, host, key+".json")
+}
+
+// Get returns the fixture recorded for r under key, or nil when there is none
+func (s *FixtureStore) Get(r *http.Request, key string) (*CacheEntry, error) {
+	path := s.path(r, key)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read fixture: %w", err)
+	}
+
+	var f fixtureFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("fixture %s: %w", path, err)
+	}
+	if f.Response == nil {
+		return nil, fmt.Errorf("fixture %s has no response", path)
+	}
+	return f.Response, nil
+}
+
+// Set records entry as the response to r under key, replacing any earlier
+// recording; the file is written atomically. Expiry is kept as recorded
+// but ignored on replay.
+func (s *FixtureStore) Set(r *http.Request, key string, entry *CacheEntry) error {
+	var f fixtureFile
+	f.Request.Method = r.Method
+	f.Request.URL = redactURL(r.URL)
+	f.RecordedAt = time.Now()
+	f.Response = redactEntry(entry)
+
+	data, err := json.MarshalIndent(&f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := s.path(r, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("write fixture: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".fixture-*")
+	if err != nil {
+		return fmt.Errorf("write fixture: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write fixture: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write fixture: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// redactURL drops userinfo from u for the human-readable part of a fixture
+func redactURL(u *url.URL) string {
+	clean := *u
+	clean.User = nil
+	return clean.String()
+}
+
+// urlHeaders are response headers holding URLs, which may carry userinfo
+var urlHeaders = []string{"Location", "Content-Location"}
+
+// redactEntry returns a copy of entry with userinfo dropped from its URL
+// and URL headers, so recordings don't keep credentials
+func redactEntry(entry *CacheEntry) *CacheEntry {
+	clean := *entry
+	clean.URL = redactRawURL(entry.URL)
+	clean.Headers = entry.Headers.Clone()
+	for _, name := range urlHeaders {
+		for i, value := range clean.Headers.Values(name) {
+			clean.Headers[http.CanonicalHeaderKey(name)][i] = redactRawURL(value)
+		}
+	}
+	return &clean
+}
+
+// redactRawURL is redactURL for a URL string; other strings are returned
+// unchanged
+func redactRawURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	return redactURL(u)
+}
@@ -0,0 +1,237 @@
+package cache
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Freshness follows RFC 9111 for a shared cache: a response's lifetime
+// comes from s-maxage, max-age or Expires, else from a heuristic, and its
+// age from the Age and Date headers. Requests can tighten (max-age,
+// min-fresh, no-cache) or relax (max-stale) what they accept.
+
+// maxDeltaSeconds caps delta-seconds values, as RFC 9111 section 1.2.2
+// allows
+const maxDeltaSeconds = 1 << 31
+
+// heuristicFraction of the time since Last-Modified is the lifetime of a
+// response without explicit freshness (RFC 9111 section 4.2.2)
+const heuristicFraction = 10
+
+// CacheControl holds the directives of Cache-Control header fields: lower
+// case names mapped to their unquoted arguments, "" for none
+type CacheControl map[string]string
+
+// ParseCacheControl parses every Cache-Control field of h. When a
+// directive repeats, the first occurrence wins.
+func ParseCacheControl(h http.Header) CacheControl {
+	cc := CacheControl{}
+	for _, field := range h.Values("Cache-Control") {
+		for {
+			field = strings.TrimLeft(field, " \t,")
+			if field == "" {
+				break
+			}
+			end := strings.IndexAny(field, "=,")
+			if end < 0 {
+				end = len(field)
+			}
+			name := strings.ToLower(strings.TrimSpace(field[:end]))
+			field = field[end:]
+
+			var value string
+			if strings.HasPrefix(field, "=") {
+				field = strings.TrimLeft(field[1:], " \t")
+				if strings.HasPrefix(field, `"`) {
+					value, field = unquote(field)
+				} else {
+					end := strings.IndexByte(field, ',')
+					if end < 0 {
+						end = len(field)
+					}
+					value, field = strings.TrimSpace(field[:end]), field[end:]
+				}
+			}
+			if _, seen := cc[name]; !seen && name != "" {
+				cc[name] = value
+			}
+		}
+	}
+	return cc
+}
+
+// unquote splits the quoted-string at the start of s from the rest
+func unquote(s string) (value, rest string) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), ""
+}
+
+// Has reports whether the directive name is present
+func (cc CacheControl) Has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// Seconds returns the delta-seconds argument of the directive name and
+// whether it is present. An invalid argument counts as 0, so a response
+// with a malformed max-age is stale.
+func (cc CacheControl) Seconds(name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	return deltaSeconds(v), true
+}
+
+// deltaSeconds parses a non-negative number of seconds; invalid values
+// count as 0
+func deltaSeconds(v string) time.Duration {
+	n, err := strconv.ParseUint(v, 10, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		n = maxDeltaSeconds
+	} else if err != nil {
+		return 0
+	}
+	return time.Duration(min(n, maxDeltaSeconds)) * time.Second
+}
+
+// requestCacheControl parses the directives of r, reading a lone
+// "Pragma: no-cache" as Cache-Control: no-cache
+func requestCacheControl(r *http.Request) CacheControl {
+	cc := ParseCacheControl(r.Header)
+	if len(r.Header.Values("Cache-Control")) == 0 && strings.Contains(strings.ToLower(r.Header.Get("Pragma")), "no-cache") {
+		cc["no-cache"] = ""
+	}
+	return cc
+}
+
+// freshnessLifetime returns how long a response with header h, received
+// at responseTime, stays fresh. Without explicit freshness it is a tenth
+// of the time since Last-Modified, up to defaultAge, or else defaultAge.
+func freshnessLifetime(h http.Header, responseTime time.Time, defaultAge time.Duration) time.Duration {
+	cc := ParseCacheControl(h)
+	if cc.Has("no-cache") {
+		return 0 // stored, but revalidated before every use
+	}
+	if lifetime, ok := cc.Seconds("s-maxage"); ok {
+		return lifetime
+	}
+	if lifetime, ok := cc.Seconds("max-age"); ok {
+		return lifetime
+	}
+
+	date, err := http.ParseTime(h.Get("Date"))
+	if err != nil {
+		date = responseTime
+	}
+	if _, ok := h["Expires"]; ok {
+		expires, err := http.ParseTime(h.Get("Expires"))
+		if err != nil {
+			return 0 // e.g. "Expires: 0" means already expired
+		}
+		return max(expires.Sub(date), 0)
+	}
+
+	if lastModified, err := http.ParseTime(h.Get("Last-Modified")); err == nil && !lastModified.After(date) {
+		return min(date.Sub(lastModified)/heuristicFraction, defaultAge)
+	}
+	return defaultAge
+}
+
+// initialAge returns the age a response with header h already had when
+// received at responseTime, from its Age and Date headers
+func initialAge(h http.Header, responseTime time.Time) time.Duration {
+	var age time.Duration
+	if date, err := http.ParseTime(h.Get("Date")); err == nil {
+		age = max(responseTime.Sub(date), 0)
+	}
+	return max(age, deltaSeconds(h.Get("Age")))
+}
+
+// expiresAt returns when a response with header h, received at
+// responseTime, turns stale
+func expiresAt(h http.Header, responseTime time.Time, defaultAge time.Duration) time.Time {
+	return responseTime.Add(freshnessLifetime(h, responseTime, defaultAge) - initialAge(h, responseTime))
+}
+
+// Age returns the current age of the entry: the age it was received with
+// plus the time since
+func (e *CacheEntry) Age() time.Duration {
+	return initialAge(e.Headers, e.CachedAt) + time.Since(e.CachedAt)
+}
+
+// ResponseHeader returns the entry's headers with its current Age, as
+// sent when it answers a request
+func (e *CacheEntry) ResponseHeader() http.Header {
+	h := e.Headers.Clone()
+	if h == nil {
+		h = make(http.Header)
+	}
+	h.Set("Age", ageSeconds(e.Age()))
+	return h
+}
+
+// ageSeconds formats age for the Age header
+func ageSeconds(age time.Duration) string {
+	return strconv.FormatInt(int64(age/time.Second), 10)
+}
+
+// Satisfies reports whether the entry may answer r without revalidation,
+// given r's Cache-Control directives: no-cache and max-age tighten
+// freshness, min-fresh asks for some left, and max-stale accepts stale
+// entries unless the response demands revalidation.
+func (e *CacheEntry) Satisfies(r *http.Request) bool {
+	req := requestCacheControl(r)
+	if req.Has("no-cache") {
+		return false
+	}
+	if maxAge, ok := req.Seconds("max-age"); ok && e.Age() > maxAge {
+		return false
+	}
+
+	left := time.Until(e.ExpireAt)
+	if minFresh, ok := req.Seconds("min-fresh"); ok {
+		left -= minFresh
+	}
+	if left >= 0 {
+		return true
+	}
+
+	if !req.Has("max-stale") || mustRevalidate(e.Headers) {
+		return false
+	}
+	if req["max-stale"] == "" {
+		return true // any staleness
+	}
+	maxStale, _ := req.Seconds("max-stale")
+	return -left <= maxStale
+}
+
+// mustRevalidate reports whether a response with header h must not be
+// served stale by a shared cache
+func mustRevalidate(h http.Header) bool {
+	cc := ParseCacheControl(h)
+	return cc.Has("must-revalidate") || cc.Has("proxy-revalidate") || cc.Has("s-maxage") || cc.Has("no-cache")
+}
+
+// OnlyIfCached reports whether r asks for a cached response only; when
+// there is none the proxy answers 504 instead of contacting the origin
+func OnlyIfCached(r *http.Request) bool {
+	return ParseCacheControl(r.Header).Has("only-if-cached")
+}
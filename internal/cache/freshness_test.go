@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+		want   CacheControl
+	}{
+		{"empty", nil, CacheControl{}},
+		{"flags", []string{"no-cache, no-store"}, CacheControl{"no-cache": "", "no-store": ""}},
+		{"arguments", []string{"max-age=60,s-maxage = 120"}, CacheControl{"max-age": "60", "s-maxage": "120"}},
+		{"case", []string{"Max-Age=60, PUBLIC"}, CacheControl{"max-age": "60", "public": ""}},
+		{"quoted", []string{`private="Set-Cookie, X-Id", max-age=5`}, CacheControl{"private": "Set-Cookie, X-Id", "max-age": "5"}},
+		{"escaped quote", []string{`ext="a\"b", no-cache`}, CacheControl{"ext": `a"b`, "no-cache": ""}},
+		{"quoted max-age", []string{`max-age="60"`}, CacheControl{"max-age": "60"}},
+		{"first wins", []string{"max-age=60, max-age=0"}, CacheControl{"max-age": "60"}},
+		{"several fields", []string{"public", "max-age=60"}, CacheControl{"public": "", "max-age": "60"}},
+		{"stray commas", []string{" ,, no-store ,"}, CacheControl{"no-store": ""}},
+	}
+
+	for _, tt := range tests {
+		h := http.Header{"Cache-Control": tt.fields}
+		if got := ParseCacheControl(h); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ParseCacheControl = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFreshness(t *testing.T) {
+	now := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) string { return now.Add(d).Format(http.TimeFormat) }
+	const defaultAge = time.Hour
+
+	// Expected lifetime left on receipt, per RFC 9111 section 4.2
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"default", http.Header{}, defaultAge},
+		{"max-age", http.Header{"Cache-Control": {"max-age=60"}}, time.Minute},
+		{"max-age=0", http.Header{"Cache-Control": {"max-age=0"}}, 0},
+		{"invalid max-age is stale", http.Header{"Cache-Control": {"max-age=soon"}}, 0},
+		{"negative max-age is stale", http.Header{"Cache-Control": {"max-age=-1"}}, 0},
+		{"max-age overflow is capped", http.Header{"Cache-Control": {"max-age=99999999999999999999"}}, maxDeltaSeconds * time.Second},
+		{"s-maxage over max-age", http.Header{"Cache-Control": {"max-age=60, s-maxage=600"}}, 10 * time.Minute},
+		{"max-age over Expires", http.Header{
+			"Cache-Control": {"max-age=60"},
+			"Expires":       {at(time.Hour)},
+		}, time.Minute},
+		{"Expires relative to Date", http.Header{
+			"Date":    {at(-time.Hour)},
+			"Expires": {at(time.Hour)},
+			"Age":     {"3600"},
+		}, time.Hour},
+		{"Expires without Date", http.Header{"Expires": {at(30 * time.Minute)}}, 30 * time.Minute},
+		{"Expires in the past", http.Header{"Expires": {at(-time.Minute)}}, 0},
+		{"invalid Expires is stale", http.Header{"Expires": {"0"}}, 0},
+		{"Age reduces freshness", http.Header{
+			"Cache-Control": {"max-age=600"},
+			"Age":           {"60"},
+		}, 9 * time.Minute},
+		{"Date in the past ages", http.Header{
+			"Cache-Control": {"max-age=600"},
+			"Date":          {at(-2 * time.Minute)},
+		}, 8 * time.Minute},
+		{"Date in the future does not", http.Header{
+			"Cache-Control": {"max-age=600"},
+			"Date":          {at(time.Minute)},
+		}, 10 * time.Minute},
+		{"heuristic from Last-Modified", http.Header{"Last-Modified": {at(-100 * time.Minute)}}, 10 * time.Minute},
+		{"heuristic capped by default", http.Header{"Last-Modified": {at(-100 * time.Hour)}}, defaultAge},
+		{"Last-Modified in the future", http.Header{"Last-Modified": {at(time.Hour)}}, defaultAge},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache, max-age=600"}, "Etag": {`"v1"`}}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := expiresAt(tt.header, now, defaultAge).Sub(now); got != tt.want {
+			t.Errorf("%s: fresh for %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSatisfies(t *testing.T) {
+	entry := func(age, left time.Duration, cacheControl string) *CacheEntry {
+		e := newTestEntry("body")
+		e.CachedAt = time.Now().Add(-age)
+		e.ExpireAt = time.Now().Add(left)
+		e.Headers = http.Header{"Cache-Control": {cacheControl}}
+		return e
+	}
+	fresh := entry(time.Minute, time.Minute, "max-age=120")
+	stale := entry(3*time.Minute, -time.Minute, "max-age=120")
+	mustRevalidate := entry(3*time.Minute, -time.Minute, "max-age=120, must-revalidate")
+
+	tests := []struct {
+		name   string
+		entry  *CacheEntry
+		header http.Header
+		want   bool
+	}{
+		{"fresh", fresh, nil, true},
+		{"stale", stale, nil, false},
+		{"no-cache", fresh, http.Header{"Cache-Control": {"no-cache"}}, false},
+		{"Pragma no-cache", fresh, http.Header{"Pragma": {"no-cache"}}, false},
+		{"Cache-Control overrides Pragma", fresh, http.Header{"Pragma": {"no-cache"}, "Cache-Control": {"max-age=600"}}, true},
+		{"max-age above age", fresh, http.Header{"Cache-Control": {"max-age=90"}}, true},
+		{"max-age below age", fresh, http.Header{"Cache-Control": {"max-age=30"}}, false},
+		{"min-fresh met", fresh, http.Header{"Cache-Control": {"min-fresh=30"}}, true},
+		{"min-fresh not met", fresh, http.Header{"Cache-Control": {"min-fresh=90"}}, false},
+		{"max-stale", stale, http.Header{"Cache-Control": {"max-stale"}}, true},
+		{"max-stale within", stale, http.Header{"Cache-Control": {"max-stale=90"}}, true},
+		{"max-stale exceeded", stale, http.Header{"Cache-Control": {"max-stale=30"}}, false},
+		{"max-stale with max-age", stale, http.Header{"Cache-Control": {"max-stale, max-age=60"}}, false},
+		{"must-revalidate blocks max-stale", mustRevalidate, http.Header{"Cache-Control": {"max-stale"}}, false},
+	}
+
+	for _, tt := range tests {
+		r, _ := http.NewRequest("GET", "https://example.com/", nil)
+		for name, values := range tt.header {
+			r.Header[name] = values
+		}
+		if got := tt.entry.Satisfies(r); got != tt.want {
+			t.Errorf("%s: Satisfies = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEntryAge(t *testing.T) {
+	entry := newTestEntry("body")
+	entry.CachedAt = time.Now().Add(-time.Minute)
+	entry.Headers = http.Header{"Age": {"60"}}
+
+	if age := entry.ResponseHeader().Get("Age"); age != "120" {
+		t.Errorf("Age = %s, want 120", age)
+	}
+	if entry.Headers.Get("Age") != "60" {
+		t.Error("The stored headers must not be modified")
+	}
+}
@@ -4,38 +4,78 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash/maphash"
 	"io"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // CacheEntry represents a cached HTTP response
 type CacheEntry struct {
+	URL        string // Request URL, used to purge all variants
 	StatusCode int
 	Headers    http.Header
 	Body       []byte
 	CachedAt   time.Time
 	ExpireAt   time.Time
 	Size       int64
+
+	// bodyFile is the disk cache file of a large body, which is left out
+	// of Body and streamed when served; bodyCachedAt is the CachedAt the
+	// file was read with, so a replaced file is noticed
+	bodyFile     string
+	bodyCachedAt time.Time
 }
 
-// HTTPCache manages HTTP response caching
+// HTTPCache manages HTTP response caching. Entries are spread over
+// shards by key hash and evicted least recently used first.
 type HTTPCache struct {
-	mu         sync.RWMutex
-	entries    map[string]*CacheEntry
-	maxSize    int64 // Maximum cache size in bytes
-	currentSize int64 // Current cache size
-	maxAge     time.Duration
-	hitCount   uint64
-	missCount  uint64
+	shards          []*cacheShard
+	shardBits       uint
+	seed            maphash.Seed
+	maxSize         int64 // Maximum cache size in bytes
+	maxAge          time.Duration
+	maxObjectSize   int64
+	evictLargeFirst bool
+	size            atomic.Int64 // totals of all shards, read without locking
+	count           atomic.Int64
+	hitCount        atomic.Uint64
+	missCount       atomic.Uint64
+	evictCount      atomic.Uint64
+	rejectCount     atomic.Uint64
+	stop            chan struct{}
+	stopOnce        sync.Once
+}
+
+// HTTPCacheOptions configures an HTTPCache
+type HTTPCacheOptions struct {
+	MaxSize int64 // bytes; 0 means 100MB
+	MaxAge  time.Duration
+	// Admission enables TinyLFU: a full cache only takes entries requested
+	// at least as often as those they would evict
+	Admission bool
+	// MaxObjectSize keeps larger entries out; 0 means no limit
+	MaxObjectSize int64
+	// EvictLargeFirst evicts the largest of the least recently used
+	// entries first
+	EvictLargeFirst bool
 }
 
-// NewHTTPCache creates a new HTTP cache
+// NewHTTPCache creates a new HTTP cache that admits every entry
 func NewHTTPCache(maxSize int64, maxAge time.Duration) *HTTPCache {
+	return NewHTTPCacheWithOptions(HTTPCacheOptions{MaxSize: maxSize, MaxAge: maxAge})
+}
+
+// NewHTTPCacheWithOptions creates a new HTTP cache
+func NewHTTPCacheWithOptions(opts HTTPCacheOptions) *HTTPCache {
+	maxSize, maxAge := opts.MaxSize, opts.MaxAge
 	if maxSize == 0 {
 		maxSize = 100 * 1024 * 1024 // 100MB default
 	}
@@ -44,9 +84,17 @@ func NewHTTPCache(maxSize int64, maxAge time.Duration) *HTTPCache {
 	}
 
 	c := &HTTPCache{
-		entries:    make(map[string]*CacheEntry),
-		maxSize:    maxSize,
-		maxAge:     maxAge,
+		shardBits:       shardBits(maxSize),
+		seed:            maphash.MakeSeed(),
+		maxSize:         maxSize,
+		maxAge:          maxAge,
+		maxObjectSize:   opts.MaxObjectSize,
+		evictLargeFirst: opts.EvictLargeFirst,
+		stop:            make(chan struct{}),
+	}
+	c.shards = make([]*cacheShard, 1<<c.shardBits)
+	for i := range c.shards {
+		c.shards[i] = newCacheShard(maxSize>>c.shardBits, opts.Admission)
 	}
 
 	// Start cleanup goroutine
@@ -55,110 +103,133 @@ func NewHTTPCache(maxSize int64, maxAge time.Duration) *HTTPCache {
 	return c
 }
 
-// Get retrieves a cached response
+// Get retrieves a cached response and marks it as recently used.
+// Expired entries kept for revalidation are returned too but count as
+// misses; check Fresh before serving.
 func (c *HTTPCache) Get(key string) (*CacheEntry, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	entry, exists := c.entries[key]
-	if !exists {
-		c.missCount++
-		return nil, false
+	s, hash := c.shard(key)
+	s.mu.Lock()
+	if s.sketch != nil {
+		s.sketch.increment(hash)
 	}
-
-	// Check if expired
-	if time.Now().After(entry.ExpireAt) {
-		c.missCount++
-		return nil, false
+	var entry *CacheEntry
+	if n, exists := s.nodes[key]; exists {
+		if time.Now().After(n.entry.keepUntil()) {
+			c.remove(s, n)
+		} else {
+			s.moveToFront(n)
+			entry = n.entry
+		}
 	}
+	s.mu.Unlock()
 
-	c.hitCount++
+	if entry == nil || !entry.Fresh() {
+		c.missCount.Add(1)
+		return entry, entry != nil
+	}
+	c.hitCount.Add(1)
 	return entry, true
 }
 
-// Set stores a response in cache
-func (c *HTTPCache) Set(key string, entry *CacheEntry) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// ErrEntryTooLarge is returned when an entry can never fit into its
+// cache shard
+var ErrEntryTooLarge = errors.New("cache entry exceeds cache size")
 
-	// Check if we need to evict entries
-	if c.currentSize+entry.Size > c.maxSize {
-		c.evictLRU(entry.Size)
+// Set stores a response in cache, evicting the shard's least recently
+// used entries to make room. It returns ErrNotAdmitted when the admission
+// policy or the object size limit keeps the entry out.
+func (c *HTTPCache) Set(key string, entry *CacheEntry) error {
+	s, hash := c.shard(key)
+	// An entry larger than the shard would just evict everything
+	if entry.Size > s.maxSize {
+		return ErrEntryTooLarge
 	}
-
-	// Remove old entry if exists
-	if old, exists := c.entries[key]; exists {
-		c.currentSize -= old.Size
+	if c.maxObjectSize > 0 && entry.Size > c.maxObjectSize {
+		c.rejectCount.Add(1)
+		return ErrNotAdmitted
 	}
 
-	// Add new entry
-	c.entries[key] = entry
-	c.currentSize += entry.Size
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Replacing a cached entry needs no admission
+	old, replacing := s.nodes[key]
+	if replacing {
+		c.remove(s, old)
+	}
+	if needed := s.size + entry.Size - s.maxSize; needed > 0 {
+		victims := c.victims(s, needed)
+		if !replacing && !s.admit(hash, victims) {
+			c.rejectCount.Add(1)
+			return ErrNotAdmitted
+		}
+		for _, v := range victims {
+			c.remove(s, v)
+		}
+		c.evictCount.Add(uint64(len(victims)))
+	}
+	c.insert(s, key, hash, entry)
 
 	return nil
 }
 
 // Delete removes an entry from cache
 func (c *HTTPCache) Delete(key string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	s, _ := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if entry, exists := c.entries[key]; exists {
-		c.currentSize -= entry.Size
-		delete(c.entries, key)
+	if n, exists := s.nodes[key]; exists {
+		c.remove(s, n)
 	}
 }
 
+// PurgeURL removes every entry cached for url and returns how many were removed
+func (c *HTTPCache) PurgeURL(url string) int {
+	return c.PurgeMatch(func(entryURL string) bool { return entryURL == url })
+}
+
+// PurgeMatch removes every entry whose request URL matches and returns how
+// many were removed
+func (c *HTTPCache) PurgeMatch(match func(url string) bool) int {
+	return c.removeWhere(func(n *lruNode) bool { return match(n.entry.URL) })
+}
+
 // Clear removes all entries
 func (c *HTTPCache) Clear() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	c.entries = make(map[string]*CacheEntry)
-	c.currentSize = 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		c.size.Add(-s.size)
+		c.count.Add(-int64(len(s.nodes)))
+		s.reset()
+		s.mu.Unlock()
+	}
 }
 
 // Stats returns cache statistics
 func (c *HTTPCache) Stats() (hits, misses uint64, size int64, entries int) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	return c.hitCount.Load(), c.missCount.Load(), c.size.Load(), int(c.count.Load())
+}
 
-	return c.hitCount, c.missCount, c.currentSize, len(c.entries)
+// Evictions returns how many entries were evicted to make room
+func (c *HTTPCache) Evictions() uint64 {
+	return c.evictCount.Load()
+}
+
+// Rejections returns how many entries the admission policy or the object
+// size limit kept out
+func (c *HTTPCache) Rejections() uint64 {
+	return c.rejectCount.Load()
 }
 
 // HitRate returns cache hit rate
 func (c *HTTPCache) HitRate() float64 {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	total := c.hitCount + c.missCount
+	hits := c.hitCount.Load()
+	total := hits + c.missCount.Load()
 	if total == 0 {
 		return 0.0
 	}
-	return float64(c.hitCount) / float64(total)
-}
-
-// evictLRU evicts least recently used entries
-func (c *HTTPCache) evictLRU(needed int64) {
-	var oldest *CacheEntry
-	var oldestKey string
-
-	for needed > 0 && len(c.entries) > 0 {
-		// Find oldest entry
-		for key, entry := range c.entries {
-			if oldest == nil || entry.CachedAt.Before(oldest.CachedAt) {
-				oldest = entry
-				oldestKey = key
-			}
-		}
-
-		if oldest != nil {
-			c.currentSize -= oldest.Size
-			needed -= oldest.Size
-			delete(c.entries, oldestKey)
-			oldest = nil
-		}
-	}
+	return float64(hits) / float64(total)
 }
 
 // cleanupExpired removes expired entries periodically
@@ -166,19 +237,21 @@ func (c *HTTPCache) cleanupExpired() {
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		c.mu.Lock()
-		now := time.Now()
-		for key, entry := range c.entries {
-			if now.After(entry.ExpireAt) {
-				c.currentSize -= entry.Size
-				delete(c.entries, key)
-			}
+	for {
+		select {
+		case now := <-ticker.C:
+			c.removeExpired(now)
+		case <-c.stop:
+			return
 		}
-		c.mu.Unlock()
 	}
 }
 
+// Close stops the cleanup goroutine
+func (c *HTTPCache) Close() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
 // GenerateKey creates a cache key from request
 func GenerateKey(r *http.Request) string {
 	// Include method, URL, and relevant headers
@@ -202,56 +275,38 @@ func GenerateKey(r *http.Request) string {
 	return hex.EncodeToString(hash[:])
 }
 
-// IsCacheable checks if response can be cached
+// IsCacheable checks if a shared cache may store resp (RFC 9111 section 3)
 func IsCacheable(r *http.Request, resp *http.Response) bool {
 	// Only cache GET requests
 	if r.Method != http.MethodGet {
 		return false
 	}
 
-	// Check status code (only cache successful responses)
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+	// Only cache successful responses; partial ones would need the range
+	// in the key
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || resp.StatusCode == http.StatusPartialContent {
 		return false
 	}
 
-	// Check Cache-Control headers
-	cacheControl := resp.Header.Get("Cache-Control")
-	if strings.Contains(cacheControl, "no-store") || strings.Contains(cacheControl, "no-cache") {
+	req, cc := ParseCacheControl(r.Header), ParseCacheControl(resp.Header)
+	if req.Has("no-store") || cc.Has("no-store") || cc.Has("private") {
 		return false
 	}
-	if strings.Contains(cacheControl, "private") {
+
+	// no-cache responses are stored only to be revalidated, which needs a
+	// validator
+	if cc.Has("no-cache") && resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "" {
 		return false
 	}
 
-	// Check for Authorization header (don't cache authenticated requests by default)
-	if r.Header.Get("Authorization") != "" {
+	// Authenticated responses only when explicitly shareable
+	if r.Header.Get("Authorization") != "" && !cc.Has("public") && !cc.Has("s-maxage") && !cc.Has("must-revalidate") {
 		return false
 	}
 
 	return true
 }
 
-// GetMaxAge extracts max-age from Cache-Control header
-func GetMaxAge(resp *http.Response, defaultAge time.Duration) time.Duration {
-	cacheControl := resp.Header.Get("Cache-Control")
-	if cacheControl == "" {
-		return defaultAge
-	}
-
-	// Parse max-age directive
-	for _, directive := range strings.Split(cacheControl, ",") {
-		directive = strings.TrimSpace(directive)
-		if strings.HasPrefix(directive, "max-age=") {
-			maxAgeStr := strings.TrimPrefix(directive, "max-age=")
-			if seconds, err := strconv.ParseInt(maxAgeStr, 10, 64); err == nil {
-				return time.Duration(seconds) * time.Second
-			}
-		}
-	}
-
-	return defaultAge
-}
-
 // CreateCacheEntry creates a cache entry from response
 func CreateCacheEntry(resp *http.Response, maxAge time.Duration) (*CacheEntry, error) {
 	// Read body
@@ -264,24 +319,34 @@ func CreateCacheEntry(resp *http.Response, maxAge time.Duration) (*CacheEntry, e
 	// Replace body for further use
 	resp.Body = io.NopCloser(bytes.NewReader(body))
 
-	// Get actual max-age from headers
-	actualMaxAge := GetMaxAge(resp, maxAge)
-
 	now := time.Now()
 	entry := &CacheEntry{
 		StatusCode: resp.StatusCode,
 		Headers:    resp.Header.Clone(),
 		Body:       body,
 		CachedAt:   now,
-		ExpireAt:   now.Add(actualMaxAge),
+		ExpireAt:   expiresAt(resp.Header, now, maxAge),
 		Size:       int64(len(body)),
 	}
 
 	return entry, nil
 }
 
-// WriteToResponse writes cache entry to response writer
+// WriteToResponse writes cache entry to response writer. Bodies kept in
+// a disk cache file are sent from it, with sendfile where the connection
+// allows; if the file is gone, ErrBodyGone is returned before anything is
+// written.
 func (e *CacheEntry) WriteToResponse(w http.ResponseWriter) error {
+	var file *os.File
+	if e.bodyFile != "" {
+		f, err := e.openBodyFile()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		file = f
+	}
+
 	// Copy headers
 	for key, values := range e.Headers {
 		for _, value := range values {
@@ -289,7 +354,12 @@ func (e *CacheEntry) WriteToResponse(w http.ResponseWriter) error {
 		}
 	}
 
+	if file != nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(e.Size, 10))
+	}
+
 	// Add cache hit header
+	w.Header().Set("Age", ageSeconds(e.Age()))
 	w.Header().Set("X-Cache", "HIT")
 	w.Header().Set("X-Cache-Age", fmt.Sprintf("%.0f", time.Since(e.CachedAt).Seconds()))
 
@@ -297,6 +367,10 @@ func (e *CacheEntry) WriteToResponse(w http.ResponseWriter) error {
 	w.WriteHeader(e.StatusCode)
 
 	// Write body
+	if file != nil {
+		_, err := io.Copy(w, &io.LimitedReader{R: file, N: e.Size})
+		return err
+	}
 	_, err := w.Write(e.Body)
 	return err
 }
@@ -2,8 +2,10 @@ package cache
 
 import (
 	"bytes"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 )
@@ -126,6 +128,142 @@ func TestCacheEviction(t *testing.T) {
 	}
 }
 
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewHTTPCache(30, 5*time.Minute)
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Set(key, &CacheEntry{Body: []byte("0123456789"), ExpireAt: time.Now().Add(time.Minute), Size: 10})
+	}
+
+	// Reading a makes b the least recently used entry
+	cache.Get("a")
+	cache.Set("d", &CacheEntry{ExpireAt: time.Now().Add(time.Minute), Size: 10})
+
+	if _, found := cache.Get("b"); found {
+		t.Error("b should have been evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, found := cache.Get(key); !found {
+			t.Errorf("%s should still be cached", key)
+		}
+	}
+
+	// Replacing an entry is not an eviction
+	cache.Set("a", &CacheEntry{ExpireAt: time.Now().Add(time.Minute), Size: 10})
+	if n := cache.Evictions(); n != 1 {
+		t.Errorf("Expected 1 eviction, got %d", n)
+	}
+	if _, _, size, entries := cache.Stats(); size != 30 || entries != 3 {
+		t.Errorf("Expected 3 entries of 30 bytes, got %d entries, %d bytes", entries, size)
+	}
+}
+
+func TestCacheShards(t *testing.T) {
+	tests := []struct {
+		maxSize int64
+		bits    uint
+	}{
+		{20, 0},
+		{minShardSize*2 - 1, 0},
+		{minShardSize * 2, 1},
+		{100 << 20, 2},
+		{1 << 40, 4},
+	}
+	for _, tt := range tests {
+		if got := shardBits(tt.maxSize); got != tt.bits {
+			t.Errorf("shardBits(%d) = %d, expected %d", tt.maxSize, got, tt.bits)
+		}
+	}
+
+	cache := NewHTTPCache(1<<30, 5*time.Minute)
+	defer cache.Close()
+	if err := cache.Set("huge", &CacheEntry{Size: cache.maxSize/maxShards + 1}); err != ErrEntryTooLarge {
+		t.Errorf("Entry larger than a shard: expected ErrEntryTooLarge, got %v", err)
+	}
+
+	// Every shard gets a share of the keys, and totals add up across them
+	for i := 0; i < 1000; i++ {
+		cache.Set(fmt.Sprint(i), &CacheEntry{ExpireAt: time.Now().Add(time.Minute), Size: 1})
+	}
+	for i, s := range cache.shards {
+		if len(s.nodes) == 0 {
+			t.Errorf("Shard %d is empty", i)
+		}
+	}
+	if n := cache.PurgeMatch(func(string) bool { return true }); n != 1000 {
+		t.Errorf("Expected 1000 purged entries, got %d", n)
+	}
+	if _, _, size, entries := cache.Stats(); size != 0 || entries != 0 {
+		t.Errorf("Expected an empty cache, got %d entries, %d bytes", entries, size)
+	}
+}
+
+func TestCacheConcurrentAccess(t *testing.T) {
+	cache := NewHTTPCache(64*minShardSize, 5*time.Minute)
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := fmt.Sprint(i % 100)
+				switch i % 4 {
+				case 0:
+					cache.Set(key, &CacheEntry{ExpireAt: time.Now().Add(time.Minute), Size: int64(g + 1)})
+				case 1:
+					cache.Delete(key)
+				default:
+					cache.Get(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	var size int64
+	count := 0
+	for _, s := range cache.shards {
+		for _, n := range s.nodes {
+			size += n.entry.Size
+			count++
+		}
+	}
+	if _, _, gotSize, gotCount := cache.Stats(); gotSize != size || gotCount != count {
+		t.Errorf("Stats report %d entries, %d bytes; shards hold %d entries, %d bytes", gotCount, gotSize, count, size)
+	}
+}
+
+func TestCachePurgeURL(t *testing.T) {
+	cache := NewHTTPCache(1024*1024, 5*time.Minute)
+
+	for i, key := range []string{"gzip-variant", "plain-variant", "other"} {
+		url := "https://example.com/a"
+		if key == "other" {
+			url = "https://example.com/b"
+		}
+		cache.Set(key, &CacheEntry{
+			URL:      url,
+			Body:     []byte{byte(i)},
+			ExpireAt: time.Now().Add(time.Minute),
+			Size:     1,
+		})
+	}
+
+	if n := cache.PurgeURL("https://example.com/a"); n != 2 {
+		t.Errorf("Expected 2 purged entries, got %d", n)
+	}
+	if _, found := cache.Get("gzip-variant"); found {
+		t.Error("Purged entry should not be found")
+	}
+	if _, found := cache.Get("other"); !found {
+		t.Error("Unrelated entry should survive the purge")
+	}
+	if _, _, size, entries := cache.Stats(); size != 1 || entries != 1 {
+		t.Errorf("Expected 1 entry of 1 byte after purge, got %d entries, %d bytes", entries, size)
+	}
+}
+
 func TestGenerateKey(t *testing.T) {
 	req1 := httptest.NewRequest("GET", "http://example.com/path", nil)
 	req2 := httptest.NewRequest("GET", "http://example.com/path", nil)
@@ -152,6 +290,7 @@ func TestIsCacheable(t *testing.T) {
 		method     string
 		statusCode int
 		headers    map[string]string
+		reqHeaders map[string]string
 		cacheable  bool
 	}{
 		{
@@ -187,11 +326,79 @@ func TestIsCacheable(t *testing.T) {
 			headers:    map[string]string{"Cache-Control": "private"},
 			cacheable:  false,
 		},
+		{
+			name:       "Cache-Control: no-cache with ETag",
+			method:     "GET",
+			statusCode: 200,
+			headers:    map[string]string{"Cache-Control": "no-cache", "ETag": `"v1"`},
+			cacheable:  true,
+		},
+		{
+			name:       "Cache-Control: no-cache without validator",
+			method:     "GET",
+			statusCode: 200,
+			headers:    map[string]string{"Cache-Control": "no-cache"},
+			cacheable:  false,
+		},
+		{
+			name:       "no-store among other directives",
+			method:     "GET",
+			statusCode: 200,
+			headers:    map[string]string{"Cache-Control": "public, max-age=60, NO-STORE"},
+			cacheable:  false,
+		},
+		{
+			name:       "no-store in a quoted argument",
+			method:     "GET",
+			statusCode: 200,
+			headers:    map[string]string{"Cache-Control": `max-age=60, ext="no-store"`},
+			cacheable:  true,
+		},
+		{
+			name:       "206 partial content",
+			method:     "GET",
+			statusCode: 206,
+			cacheable:  false,
+		},
+		{
+			name:       "request no-store",
+			method:     "GET",
+			statusCode: 200,
+			reqHeaders: map[string]string{"Cache-Control": "no-store"},
+			cacheable:  false,
+		},
+		{
+			name:       "Authorization",
+			method:     "GET",
+			statusCode: 200,
+			headers:    map[string]string{"Cache-Control": "max-age=60"},
+			reqHeaders: map[string]string{"Authorization": "Bearer token"},
+			cacheable:  false,
+		},
+		{
+			name:       "Authorization with public",
+			method:     "GET",
+			statusCode: 200,
+			headers:    map[string]string{"Cache-Control": "public, max-age=60"},
+			reqHeaders: map[string]string{"Authorization": "Bearer token"},
+			cacheable:  true,
+		},
+		{
+			name:       "Authorization with s-maxage",
+			method:     "GET",
+			statusCode: 200,
+			headers:    map[string]string{"Cache-Control": "s-maxage=60"},
+			reqHeaders: map[string]string{"Authorization": "Bearer token"},
+			cacheable:  true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			req := httptest.NewRequest(tt.method, "http://example.com", nil)
+			for k, v := range tt.reqHeaders {
+				req.Header.Set(k, v)
+			}
 			resp := &http.Response{
 				StatusCode: tt.statusCode,
 				Header:     make(http.Header),
@@ -275,3 +482,62 @@ func BenchmarkCacheGet(b *testing.B) {
 		cache.Get(key)
 	}
 }
+
+// BenchmarkCacheSetEvict measures writes to a full cache, where every Set
+// evicts an entry
+func BenchmarkCacheSetEvict(b *testing.B) {
+	const capacity = 50000
+	cache := NewHTTPCache(capacity, 5*time.Minute)
+	defer cache.Close()
+	keys := make([]string, 2*capacity)
+	for i := range keys {
+		keys[i] = fmt.Sprint(i)
+	}
+	entry := &CacheEntry{ExpireAt: time.Now().Add(5 * time.Minute), Size: 1}
+	for _, key := range keys[:capacity] {
+		cache.Set(key, entry)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Set(keys[i%len(keys)], entry)
+	}
+}
+
+func BenchmarkCacheGetParallel(b *testing.B) {
+	cache := NewHTTPCache(1<<30, 5*time.Minute)
+	defer cache.Close()
+	keys := make([]string, 10000)
+	entry := &CacheEntry{ExpireAt: time.Now().Add(5 * time.Minute), Size: 1024}
+	for i := range keys {
+		keys[i] = fmt.Sprint(i)
+		cache.Set(keys[i], entry)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			cache.Get(keys[i%len(keys)])
+		}
+	})
+}
+
+func BenchmarkCacheSetParallel(b *testing.B) {
+	cache := NewHTTPCache(1<<30, 5*time.Minute)
+	defer cache.Close()
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = fmt.Sprint(i)
+	}
+	entry := &CacheEntry{ExpireAt: time.Now().Add(5 * time.Minute), Size: 1024}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			cache.Set(keys[i%len(keys)], entry)
+		}
+	})
+}
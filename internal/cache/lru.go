@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// Shards split an HTTPCache so concurrent requests rarely share a lock.
+// Each shard gets an equal slice of the size budget and evicts on its own,
+// so a shard must stay large enough for big responses: small caches use
+// fewer shards.
+const (
+	maxShards    = 16
+	minShardSize = 16 << 20
+)
+
+// shardBits returns log2 of the number of shards for a cache of maxSize
+// bytes
+func shardBits(maxSize int64) uint {
+	bits := uint(0)
+	for 1<<(bits+1) <= maxShards && maxSize>>(bits+1) >= minShardSize {
+		bits++
+	}
+	return bits
+}
+
+// lruNode links an entry into its shard's recency list
+type lruNode struct {
+	key        string
+	hash       uint64
+	entry      *CacheEntry
+	prev, next *lruNode
+}
+
+// cacheShard holds the entries of one key hash range: a map for lookups
+// and a list from most to least recently used, so lookups, inserts and
+// evictions are all O(1)
+type cacheShard struct {
+	mu      sync.Mutex
+	nodes   map[string]*lruNode
+	root    lruNode // sentinel: root.next is the newest, root.prev the oldest
+	size    int64
+	maxSize int64
+	sketch  *frequencySketch // nil without admission
+}
+
+func newCacheShard(maxSize int64, admission bool) *cacheShard {
+	s := &cacheShard{maxSize: maxSize}
+	if admission {
+		s.sketch = newFrequencySketch(maxSize)
+	}
+	s.reset()
+	return s
+}
+
+func (s *cacheShard) reset() {
+	s.nodes = make(map[string]*lruNode)
+	s.root.prev, s.root.next = &s.root, &s.root
+	s.size = 0
+}
+
+func (s *cacheShard) pushFront(n *lruNode) {
+	n.prev, n.next = &s.root, s.root.next
+	n.prev.next, n.next.prev = n, n
+}
+
+func (s *cacheShard) unlink(n *lruNode) {
+	n.prev.next, n.next.prev = n.next, n.prev
+	n.prev, n.next = nil, nil
+}
+
+func (s *cacheShard) moveToFront(n *lruNode) {
+	if s.root.next != n {
+		s.unlink(n)
+		s.pushFront(n)
+	}
+}
+
+// shard returns the shard holding key and the key's hash. The top bits of
+// the hash pick the shard, leaving the others to the frequency sketch.
+func (c *HTTPCache) shard(key string) (*cacheShard, uint64) {
+	hash := maphash.String(c.seed, key)
+	return c.shards[hash>>(64-c.shardBits)], hash
+}
+
+// insert adds a node for key at the front of s; the caller holds s.mu and
+// has made room
+func (c *HTTPCache) insert(s *cacheShard, key string, hash uint64, entry *CacheEntry) {
+	n := &lruNode{key: key, hash: hash, entry: entry}
+	s.nodes[key] = n
+	s.pushFront(n)
+	s.size += entry.Size
+	c.size.Add(entry.Size)
+	c.count.Add(1)
+}
+
+// remove drops n from s; the caller holds s.mu
+func (c *HTTPCache) remove(s *cacheShard, n *lruNode) {
+	s.unlink(n)
+	delete(s.nodes, n.key)
+	s.size -= n.entry.Size
+	c.size.Add(-n.entry.Size)
+	c.count.Add(-1)
+}
+
+// removeWhere drops every node of every shard that matches and returns
+// how many were removed
+func (c *HTTPCache) removeWhere(match func(*lruNode) bool) int {
+	removed := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for _, n := range s.nodes {
+			if match(n) {
+				c.remove(s, n)
+				removed++
+			}
+		}
+		s.mu.Unlock()
+	}
+	return removed
+}
+
+// removeExpired drops entries that expired before now and are not kept
+// for revalidation
+func (c *HTTPCache) removeExpired(now time.Time) int {
+	return c.removeWhere(func(n *lruNode) bool { return now.After(n.entry.keepUntil()) })
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Pattern selects cached entries by the request URL they were stored for:
+//
+//	=https://example.com/a.js    exact URL
+//	https://example.com/api/     URL prefix
+//	https://example.com/*.js     URL glob; * matches any run of characters
+//	example.com                  every URL on a host, any scheme or port
+//	*.example.com                host glob
+type Pattern struct {
+	text   string
+	exact  string         // exact URL
+	prefix string         // URL prefix
+	glob   *regexp.Regexp // URL glob
+	host   string         // host or host glob
+}
+
+// ParsePattern parses an invalidation pattern
+func ParsePattern(text string) (*Pattern, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, errors.New("empty pattern")
+	}
+	p := &Pattern{text: text}
+
+	if exact, ok := strings.CutPrefix(text, "="); ok {
+		if !strings.Contains(exact, "://") {
+			return nil, errors.New("exact pattern must be a URL (with scheme)")
+		}
+		p.exact = exact
+		return p, nil
+	}
+
+	if !strings.Contains(text, "://") {
+		if strings.ContainsAny(text, "/?#") {
+			return nil, errors.New("pattern must be a URL (with scheme) or a host")
+		}
+		p.host = strings.ToLower(text)
+		if _, err := path.Match(p.host, ""); err != nil {
+			return nil, errors.New("invalid host pattern")
+		}
+		return p, nil
+	}
+
+	if !strings.ContainsAny(text, "*?") {
+		p.prefix = text
+		return p, nil
+	}
+
+	expr := regexp.QuoteMeta(text)
+	expr = strings.ReplaceAll(expr, `\*`, `.*`)
+	expr = strings.ReplaceAll(expr, `\?`, `.`)
+	p.glob = regexp.MustCompile("^" + expr + "$")
+	return p, nil
+}
+
+// Match reports whether an entry stored for rawURL is selected
+func (p *Pattern) Match(rawURL string) bool {
+	switch {
+	case p.exact != "":
+		return rawURL == p.exact
+	case p.glob != nil:
+		return p.glob.MatchString(rawURL)
+	case p.prefix != "":
+		return strings.HasPrefix(rawURL, p.prefix)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	matched, _ := path.Match(p.host, strings.ToLower(u.Hostname()))
+	return matched
+}
+
+// ExactPattern selects the entries stored for rawURL only
+func ExactPattern(rawURL string) *Pattern {
+	return &Pattern{text: "=" + rawURL, exact: rawURL}
+}
+
+// String returns the pattern as given
+func (p *Pattern) String() string {
+	return p.text
+}
@@ -0,0 +1,45 @@
+package cache
+
+import "testing"
+
+func TestPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		url     string
+		want    bool
+	}{
+		{"https://example.com/api/", "https://example.com/api/users?id=1", true},
+		{"https://example.com/api/", "https://example.com/static/app.js", false},
+		{"https://example.com/api/", "http://example.com/api/users", false},
+		{"https://example.com/*.js", "https://example.com/static/app.js", true},
+		{"https://example.com/*.js", "https://example.com/app.css", false},
+		{"https://example.com/v?/", "https://example.com/v2/", true},
+		{"https://example.com/a.b", "https://example.com/aXb", false},
+		{"example.com", "https://example.com:8443/anything", true},
+		{"Example.com", "http://EXAMPLE.com/", true},
+		{"example.com", "https://cdn.example.com/", false},
+		{"*.example.com", "https://cdn.example.com/x", true},
+		{"*.example.com", "https://example.com/x", false},
+		{"=https://example.com/a?x=1", "https://example.com/a?x=1", true},
+		{"=https://example.com/a", "https://example.com/ab", false},
+		{"=https://example.com/*", "https://example.com/a", false},
+	}
+
+	for _, tt := range tests {
+		p, err := ParsePattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("ParsePattern(%q): %v", tt.pattern, err)
+		}
+		if got := p.Match(tt.url); got != tt.want {
+			t.Errorf("%q matching %q: expected %v, got %v", tt.pattern, tt.url, tt.want, got)
+		}
+	}
+}
+
+func TestParsePatternRejects(t *testing.T) {
+	for _, text := range []string{"", "  ", "example.com/path", "[bad", "/api/", "=example.com"} {
+		if _, err := ParsePattern(text); err == nil {
+			t.Errorf("ParsePattern(%q) should fail", text)
+		}
+	}
+}
@@ -2,50 +2,187 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+const (
+	// scanBatch is the SCAN COUNT hint and the size of lookup pipelines
+	scanBatch = 500
+	// maxResubscribeDelay caps the back-off between subscription attempts
+	maxResubscribeDelay = 30 * time.Second
+)
+
 // RedisBackend implements distributed cache using Redis
 type RedisBackend struct {
-	client *redis.Client
-	ctx    context.Context
+	client      redis.UniversalClient
+	ctx         context.Context
+	compressMin int
+	keys        redisKeys
+
+	gen        atomic.Int64 // current generation, see redis_namespace.go
+	genChecked atomic.Int64 // when gen was read, in Unix nanoseconds
+
+	// Background sweeps of cleared generations stop on Close
+	sweepCtx  context.Context
+	stopSweep context.CancelFunc
+	sweeping  sync.WaitGroup
+}
+
+// RedisOptions selects the Redis deployment: a single server (one entry in
+// Addrs), a Sentinel-managed master (MasterName set, Addrs are the
+// sentinels) or a Cluster (Cluster set, Addrs are seed nodes)
+type RedisOptions struct {
+	Addrs      []string
+	MasterName string
+	Cluster    bool
+	// Username selects an ACL user; empty uses the default user
+	Username         string
+	Password         string
+	SentinelPassword string
+	DB               int // must be 0 for Cluster
+	TLS              *tls.Config
+	// Namespace prefixes every key, so several environments or tenants
+	// can share a database; empty uses DefaultRedisNamespace
+	Namespace string
+	// CompressMinSize zstd-compresses bodies of at least this many bytes;
+	// 0 stores them uncompressed
+	CompressMinSize int
+}
+
+// String describes the deployment for log messages
+func (o RedisOptions) String() string {
+	addrs := strings.Join(o.Addrs, ",")
+	switch {
+	case o.MasterName != "":
+		return fmt.Sprintf("sentinel master %s via %s", o.MasterName, addrs)
+	case o.Cluster:
+		return "cluster " + addrs
+	default:
+		return fmt.Sprintf("%s db %d", addrs, o.DB)
+	}
 }
 
 // NewRedisBackend creates a new Redis cache backend
 func NewRedisBackend(addr, password string, db int) (*RedisBackend, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:         addr,
-		Password:     password,
-		DB:           db,
-		PoolSize:     100, // Connection pool size
-		MinIdleConns: 10,  // Minimum idle connections
-		MaxRetries:   3,   // Retry failed operations
-		DialTimeout:  5 * time.Second,
-		ReadTimeout:  3 * time.Second,
-		WriteTimeout: 3 * time.Second,
-	})
+	return NewRedisBackendWithOptions(RedisOptions{Addrs: []string{addr}, Password: password, DB: db})
+}
+
+// NewRedisBackendWithOptions creates a Redis cache backend for a single
+// server, Sentinel or Cluster deployment
+func NewRedisBackendWithOptions(opts RedisOptions) (*RedisBackend, error) {
+	if len(opts.Addrs) == 0 {
+		return nil, errors.New("no Redis address")
+	}
+	client := newRedisClient(opts)
+	client.AddHook(redisTracingHook{})
 
 	ctx := context.Background()
 
 	// Test connection
 	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
+	sweepCtx, stopSweep := context.WithCancel(ctx)
 	return &RedisBackend{
-		client: client,
-		ctx:    ctx,
+		client:      client,
+		ctx:         ctx,
+		compressMin: opts.CompressMinSize,
+		keys:        newRedisKeys(opts.Namespace),
+		sweepCtx:    sweepCtx,
+		stopSweep:   stopSweep,
 	}, nil
 }
 
+// newRedisClient builds the client for the deployment. Failover and
+// cluster clients follow master changes themselves; commands interrupted
+// by a failover are retried on the new master.
+func newRedisClient(opts RedisOptions) redis.UniversalClient {
+	const (
+		poolSize     = 100 // Connection pool size
+		minIdleConns = 10  // Minimum idle connections
+		maxRetries   = 3   // Retry failed operations
+		dialTimeout  = 5 * time.Second
+		ioTimeout    = 3 * time.Second
+	)
+
+	switch {
+	case opts.MasterName != "":
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       opts.MasterName,
+			SentinelAddrs:    opts.Addrs,
+			SentinelPassword: opts.SentinelPassword,
+			Username:         opts.Username,
+			Password:         opts.Password,
+			DB:               opts.DB,
+			TLSConfig:        opts.TLS,
+			PoolSize:         poolSize,
+			MinIdleConns:     minIdleConns,
+			MaxRetries:       maxRetries,
+			DialTimeout:      dialTimeout,
+			ReadTimeout:      ioTimeout,
+			WriteTimeout:     ioTimeout,
+		})
+	case opts.Cluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        opts.Addrs,
+			Username:     opts.Username,
+			Password:     opts.Password,
+			TLSConfig:    opts.TLS,
+			PoolSize:     poolSize,
+			MinIdleConns: minIdleConns,
+			MaxRetries:   maxRetries,
+			DialTimeout:  dialTimeout,
+			ReadTimeout:  ioTimeout,
+			WriteTimeout: ioTimeout,
+		})
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:         opts.Addrs[0],
+			Username:     opts.Username,
+			Password:     opts.Password,
+			DB:           opts.DB,
+			TLSConfig:    opts.TLS,
+			PoolSize:     poolSize,
+			MinIdleConns: minIdleConns,
+			MaxRetries:   maxRetries,
+			DialTimeout:  dialTimeout,
+			ReadTimeout:  ioTimeout,
+			WriteTimeout: ioTimeout,
+		})
+	}
+}
+
+// forEachMaster calls fn with a client for every master holding data: each
+// shard of a cluster, otherwise the one server. Cluster shards run
+// concurrently.
+func (r *RedisBackend) forEachMaster(ctx context.Context, fn func(ctx context.Context, client redis.UniversalClient) error) error {
+	if cluster, ok := r.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return fn(ctx, node)
+		})
+	}
+	return fn(ctx, r.client)
+}
+
 // Get retrieves a cache entry from Redis
 func (r *RedisBackend) Get(key string) (*CacheEntry, error) {
-	data, err := r.client.Get(r.ctx, key).Bytes()
+	return r.GetContext(r.ctx, key)
+}
+
+// GetContext is Get with the Redis call traced as part of ctx
+func (r *RedisBackend) GetContext(ctx context.Context, key string) (*CacheEntry, error) {
+	data, err := r.client.HGet(ctx, r.entryKey(ctx, key), "entry").Bytes()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
 			return nil, nil // Cache miss
@@ -53,22 +190,35 @@ func (r *RedisBackend) Get(key string) (*CacheEntry, error) {
 		return nil, fmt.Errorf("redis get failed: %w", err)
 	}
 
-	var entry CacheEntry
-	if err := json.Unmarshal(data, &entry); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal cache entry: %w", err)
+	entry, err := decodeEntry(data)
+	if errors.Is(err, errEntryFormat) {
+		return nil, nil // Written by another release; overwritten on the next store
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return &entry, nil
+	return entry, nil
 }
 
 // Set stores a cache entry in Redis with TTL
 func (r *RedisBackend) Set(key string, entry *CacheEntry, ttl time.Duration) error {
-	data, err := json.Marshal(entry)
-	if err != nil {
-		return fmt.Errorf("failed to marshal cache entry: %w", err)
-	}
+	return r.SetContext(r.ctx, key, entry, ttl)
+}
+
+// SetContext is Set with the Redis call traced as part of ctx. Entries are
+// hashes holding the request URL next to the serialized entry, so
+// invalidation can match URLs without loading bodies.
+func (r *RedisBackend) SetContext(ctx context.Context, key string, entry *CacheEntry, ttl time.Duration) error {
+	data := encodeEntry(entry, r.compressMin)
 
-	if err := r.client.Set(r.ctx, key, data, ttl).Err(); err != nil {
+	key = r.entryKey(ctx, key)
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "url", entry.URL, "entry", data)
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+	if err != nil {
 		return fmt.Errorf("redis set failed: %w", err)
 	}
 
@@ -77,61 +227,229 @@ func (r *RedisBackend) Set(key string, entry *CacheEntry, ttl time.Duration) err
 
 // Delete removes a cache entry from Redis
 func (r *RedisBackend) Delete(key string) error {
-	if err := r.client.Del(r.ctx, key).Err(); err != nil {
+	if err := r.client.Del(r.ctx, r.entryKey(r.ctx, key)).Err(); err != nil {
 		return fmt.Errorf("redis delete failed: %w", err)
 	}
 	return nil
 }
 
-// Clear removes all cache entries (use with caution!)
-func (r *RedisBackend) Clear() error {
-	if err := r.client.FlushDB(r.ctx).Err(); err != nil {
-		return fmt.Errorf("redis flush failed: %w", err)
-	}
-	return nil
-}
-
-// Stats returns Redis cache statistics
+// Stats returns Redis cache statistics: "entries" counts this namespace's
+// current entries, "db_size" the whole database
 func (r *RedisBackend) Stats() (map[string]interface{}, error) {
 	info, err := r.client.Info(r.ctx, "stats").Result()
 	if err != nil {
 		return nil, fmt.Errorf("redis info failed: %w", err)
 	}
 
+	// Database-wide: includes other namespaces and unrelated keys
 	dbSize, err := r.client.DBSize(r.ctx).Result()
 	if err != nil {
 		return nil, fmt.Errorf("redis dbsize failed: %w", err)
 	}
 
+	entries, err := r.countEntries(r.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("redis scan failed: %w", err)
+	}
+
 	return map[string]interface{}{
 		"db_size": dbSize,
+		"entries": entries,
 		"info":    info,
 	}, nil
 }
 
+// DeleteMatching removes every entry whose request URL matches and
+// returns how many were removed. It walks the current generation with
+// SCAN on every master, so Redis is never blocked the way KEYS would block
+// it; matches are deleted once a master's scan is complete.
+func (r *RedisBackend) DeleteMatching(ctx context.Context, match func(url string) bool) (int, error) {
+	pattern := r.generationPrefix(r.generation(ctx)) + "*"
+	var (
+		mu      sync.Mutex
+		deleted int
+	)
+	err := r.forEachMaster(ctx, func(ctx context.Context, client redis.UniversalClient) error {
+		n, err := deleteMatching(ctx, client, pattern, match)
+		mu.Lock()
+		deleted += n
+		mu.Unlock()
+		return err
+	})
+	return deleted, err
+}
+
+// deleteMatching is DeleteMatching for a single master
+func deleteMatching(ctx context.Context, client redis.UniversalClient, pattern string, match func(url string) bool) (int, error) {
+	var matched []string
+	err := scanKeys(ctx, client, pattern, func(keys []string) error {
+		pipe := client.Pipeline()
+		urls := make([]*redis.StringCmd, len(keys))
+		for i, key := range keys {
+			urls[i] = pipe.HGet(ctx, key, "url")
+		}
+		if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		for i, cmd := range urls {
+			if url, err := cmd.Result(); err == nil && match(url) {
+				matched = append(matched, keys[i])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("redis invalidate failed: %w", err)
+	}
+
+	deleted := 0
+	for len(matched) > 0 {
+		n := min(len(matched), scanBatch)
+		removed, err := delKeys(ctx, client, matched[:n])
+		deleted += removed
+		if err != nil {
+			return deleted, fmt.Errorf("redis invalidate failed: %w", err)
+		}
+		matched = matched[n:]
+	}
+	return deleted, nil
+}
+
+// deleteScanned deletes the keys matching pattern that pick selects, batch
+// by batch as SCAN returns them, on every master. Deleting during a scan
+// can make some servers skip keys, so passes repeat until one deletes
+// nothing.
+func (r *RedisBackend) deleteScanned(ctx context.Context, pattern string, pick func(keys []string) []string) (int, error) {
+	var (
+		mu      sync.Mutex
+		deleted int
+	)
+	err := r.forEachMaster(ctx, func(ctx context.Context, client redis.UniversalClient) error {
+		for {
+			pass := 0
+			err := scanKeys(ctx, client, pattern, func(keys []string) error {
+				n, err := delKeys(ctx, client, pick(keys))
+				pass += n
+				return err
+			})
+			mu.Lock()
+			deleted += pass
+			mu.Unlock()
+			if err != nil || pass == 0 {
+				return err
+			}
+		}
+	})
+	return deleted, err
+}
+
+// scanKeys calls fn with batches of keys matching pattern on one master
+func scanKeys(ctx context.Context, client redis.UniversalClient, pattern string, fn func(keys []string) error) error {
+	batch := make([]string, 0, scanBatch)
+	iter := client.Scan(ctx, 0, pattern, scanBatch).Iterator()
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == scanBatch {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	return fn(batch)
+}
+
+// delKeys deletes keys with one DEL each, since keys on one cluster shard
+// may still live in different slots
+func delKeys(ctx context.Context, client redis.UniversalClient, keys []string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	pipe := client.Pipeline()
+	dels := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		dels[i] = pipe.Del(ctx, key)
+	}
+	_, err := pipe.Exec(ctx)
+	deleted := 0
+	for _, cmd := range dels {
+		deleted += int(cmd.Val())
+	}
+	return deleted, err
+}
+
 // PublishInvalidation publishes cache invalidation message to other instances
 func (r *RedisBackend) PublishInvalidation(pattern string) error {
-	if err := r.client.Publish(r.ctx, "cache:invalidate", pattern).Err(); err != nil {
+	if err := r.client.Publish(r.ctx, r.keys.channel, pattern).Err(); err != nil {
 		return fmt.Errorf("redis publish failed: %w", err)
 	}
 	return nil
 }
 
-// SubscribeInvalidations subscribes to cache invalidation messages
-// The handler function is called for each invalidation message
-func (r *RedisBackend) SubscribeInvalidations(handler func(string)) error {
-	pubsub := r.client.Subscribe(r.ctx, "cache:invalidate")
+// SubscribeInvalidations calls handler with every published invalidation
+// pattern until ctx is cancelled. A dropped subscription is re-established
+// with back-off; resync is called after each reconnect because messages
+// sent in the meantime were lost.
+func (r *RedisBackend) SubscribeInvalidations(ctx context.Context, handler func(pattern string), resync func()) {
+	delay := time.Second
+	subscribed := false
+	for {
+		err := r.receiveInvalidations(ctx, handler, func() {
+			if subscribed {
+				log.Printf("🔌 Cache invalidation subscription restored")
+				resync()
+			}
+			subscribed = true
+			delay = time.Second
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("⚠️  Cache invalidation subscription lost, retrying in %v: %v", delay, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxResubscribeDelay {
+			delay = maxResubscribeDelay
+		}
+	}
+}
+
+// receiveInvalidations runs one subscription until it fails; connected is
+// called once Redis has confirmed it
+func (r *RedisBackend) receiveInvalidations(ctx context.Context, handler func(string), connected func()) error {
+	pubsub := r.client.Subscribe(ctx, r.keys.channel)
 	defer pubsub.Close()
+	// Reads don't watch ctx; closing the subscription unblocks them
+	stop := context.AfterFunc(ctx, func() { pubsub.Close() })
+	defer stop()
 
-	ch := pubsub.Channel()
-	for msg := range ch {
-		handler(msg.Payload)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
 	}
+	connected()
 
-	return nil
+	for {
+		msg, err := pubsub.ReceiveMessage(ctx)
+		if err != nil {
+			return err
+		}
+		handler(msg.Payload)
+	}
 }
 
-// Close closes the Redis connection
+// Close stops background sweeps and closes the Redis connection
 func (r *RedisBackend) Close() error {
+	r.stopSweep()
+	r.sweeping.Wait()
 	return r.client.Close()
 }
@@ -1,8 +1,20 @@
 package cache
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+
+	"github.com/onixus/4ebur-net/internal/cert"
 )
 
 // Note: These tests require a running Redis instance
@@ -20,9 +32,10 @@ func TestRedisBackendBasic(t *testing.T) {
 
 	// Test Set/Get
 	entry := &CacheEntry{
-		Key:      "test-key",
-		Value:    []byte("test-value"),
-		ExpireAt: time.Now().Add(1 * time.Hour),
+		StatusCode: 200,
+		Body:       []byte("test-value"),
+		ExpireAt:   time.Now().Add(1 * time.Hour),
+		Size:       10,
 	}
 
 	err = backend.Set("test-key", entry, 1*time.Hour)
@@ -39,8 +52,8 @@ func TestRedisBackendBasic(t *testing.T) {
 		t.Fatal("Retrieved entry is nil")
 	}
 
-	if string(retrieved.Value) != "test-value" {
-		t.Errorf("Expected value 'test-value', got '%s'", string(retrieved.Value))
+	if string(retrieved.Body) != "test-value" {
+		t.Errorf("Expected value 'test-value', got '%s'", string(retrieved.Body))
 	}
 
 	// Test Delete
@@ -81,3 +94,177 @@ func TestRedisBackendStats(t *testing.T) {
 		t.Error("Stats should contain db_size")
 	}
 }
+
+// fakeSentinel answers the Sentinel commands go-redis uses to find the
+// current master and can announce a failover
+type fakeSentinel struct {
+	srv *server.Server
+
+	mu          sync.Mutex
+	master      *miniredis.Miniredis
+	subscribers []*server.Peer
+}
+
+func newFakeSentinel(t *testing.T, master *miniredis.Miniredis) *fakeSentinel {
+	t.Helper()
+	srv, err := server.NewServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("fake sentinel: %v", err)
+	}
+	t.Cleanup(srv.Close)
+	s := &fakeSentinel{srv: srv, master: master}
+
+	srv.Register("PING", func(c *server.Peer, cmd string, args []string) {
+		c.WriteInline("PONG")
+	})
+	srv.Register("SENTINEL", func(c *server.Peer, cmd string, args []string) {
+		switch strings.ToLower(args[0]) {
+		case "get-master-addr-by-name":
+			s.mu.Lock()
+			host, port, _ := net.SplitHostPort(s.master.Addr())
+			s.mu.Unlock()
+			c.WriteStrings([]string{host, port})
+		case "sentinels", "replicas":
+			c.WriteLen(0)
+		default:
+			c.WriteError("ERR unknown sentinel command")
+		}
+	})
+	srv.Register("SUBSCRIBE", func(c *server.Peer, cmd string, args []string) {
+		for i, channel := range args {
+			c.WriteLen(3)
+			c.WriteBulk("subscribe")
+			c.WriteBulk(channel)
+			c.WriteInt(i + 1)
+		}
+		s.mu.Lock()
+		s.subscribers = append(s.subscribers, c)
+		s.mu.Unlock()
+	})
+	return s
+}
+
+func (s *fakeSentinel) Addr() string {
+	return s.srv.Addr().String()
+}
+
+// failover promotes master and announces it the way Sentinel does
+func (s *fakeSentinel) failover(master *miniredis.Miniredis) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	oldHost, oldPort, _ := net.SplitHostPort(s.master.Addr())
+	newHost, newPort, _ := net.SplitHostPort(master.Addr())
+	s.master = master
+
+	msg := strings.Join([]string{"mymaster", oldHost, oldPort, newHost, newPort}, " ")
+	for _, c := range s.subscribers {
+		c.Block(func(w *server.Writer) {
+			w.WriteLen(3)
+			w.WriteBulk("message")
+			w.WriteBulk("+switch-master")
+			w.WriteBulk(msg)
+		})
+	}
+}
+
+func TestRedisBackendSentinelFailover(t *testing.T) {
+	primary := miniredis.RunT(t)
+	replica := miniredis.RunT(t)
+	sentinel := newFakeSentinel(t, primary)
+
+	backend, err := NewRedisBackendWithOptions(RedisOptions{
+		Addrs:      []string{sentinel.Addr()},
+		MasterName: "mymaster",
+	})
+	if err != nil {
+		t.Fatalf("NewRedisBackendWithOptions: %v", err)
+	}
+	defer backend.Close()
+
+	if err := backend.Set("before", newTestEntry("before"), time.Minute); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if !primary.Exists("4ebur:cache:0:before") {
+		t.Fatal("Entry should be written to the sentinel's master")
+	}
+
+	sentinel.failover(replica)
+	primary.Close()
+
+	// Every command after the failover must succeed against the new master
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprint("after", i)
+		if err := backend.Set(key, newTestEntry(key), time.Minute); err != nil {
+			t.Fatalf("Set %d after failover: %v", i, err)
+		}
+		if entry, err := backend.Get(key); err != nil || entry == nil {
+			t.Fatalf("Get %d after failover: entry=%v err=%v", i, entry, err)
+		}
+	}
+	if !replica.Exists("4ebur:cache:0:after0") {
+		t.Error("Entries should be written to the promoted master")
+	}
+}
+
+func TestRedisBackendCluster(t *testing.T) {
+	mr := miniredis.RunT(t)
+	backend, err := NewRedisBackendWithOptions(RedisOptions{Addrs: []string{mr.Addr()}, Cluster: true})
+	if err != nil {
+		t.Fatalf("NewRedisBackendWithOptions: %v", err)
+	}
+	defer backend.Close()
+
+	for _, url := range []string{"https://a.example/1", "https://b.example/1"} {
+		if err := backend.Set(url, newTestEntryFor(url, "x"), time.Minute); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	p, _ := ParsePattern("a.example")
+	if n, err := backend.DeleteMatching(context.Background(), p.Match); err != nil || n != 1 {
+		t.Errorf("DeleteMatching: n=%d err=%v", n, err)
+	}
+	if entry, _ := backend.Get("https://b.example/1"); entry == nil {
+		t.Error("Non-matching entry should survive")
+	}
+}
+
+func TestRedisBackendTLSAndACL(t *testing.T) {
+	ca, err := cert.NewCertManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := ca.GetCertificate("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr, err := miniredis.RunTLS(&tls.Config{Certificates: []tls.Certificate{*leaf}})
+	if err != nil {
+		t.Fatalf("RunTLS: %v", err)
+	}
+	defer mr.Close()
+	mr.RequireUserAuth("cache", "s3cret")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.CACertificate())
+	opts := RedisOptions{
+		Addrs:    []string{mr.Addr()},
+		Username: "cache",
+		Password: "s3cret",
+		TLS:      &tls.Config{RootCAs: roots, ServerName: "localhost"},
+	}
+
+	backend, err := NewRedisBackendWithOptions(opts)
+	if err != nil {
+		t.Fatalf("NewRedisBackendWithOptions: %v", err)
+	}
+	defer backend.Close()
+	if err := backend.Set("key", newTestEntry("tls"), time.Minute); err != nil {
+		t.Errorf("Set over TLS: %v", err)
+	}
+
+	opts.Password = "wrong"
+	if backend, err := NewRedisBackendWithOptions(opts); err == nil {
+		backend.Close()
+		t.Error("Wrong ACL password should fail")
+	}
+}
@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Keys of a namespace:
+//
+//	<namespace>:generation                 purge counter
+//	<namespace>:cache:<generation>:<key>   entries
+//	<namespace>:cache:invalidate           invalidation channel
+//
+// Clear bumps the generation, which hides every entry at once; the old
+// generations are then deleted incrementally in the background and
+// expire by TTL if that is interrupted.
+
+// DefaultRedisNamespace is used when RedisOptions.Namespace is empty
+const DefaultRedisNamespace = "4ebur"
+
+// generationRefresh is how long an instance trusts its copy of the
+// generation, i.e. how long another instance's Clear may take to be seen
+const generationRefresh = time.Second
+
+// redisKeys derives the key layout of a namespace
+type redisKeys struct {
+	prefix     string // entries, followed by generation and key
+	generation string
+	channel    string
+}
+
+func newRedisKeys(namespace string) redisKeys {
+	if namespace == "" {
+		namespace = DefaultRedisNamespace
+	}
+	return redisKeys{
+		prefix:     namespace + ":cache:",
+		generation: namespace + ":generation",
+		channel:    namespace + ":cache:invalidate",
+	}
+}
+
+// generation returns the current generation, re-reading it from Redis at
+// most once per generationRefresh. On errors the last known value is kept;
+// the command that follows reports the outage.
+func (r *RedisBackend) generation(ctx context.Context) int64 {
+	checked := r.genChecked.Load()
+	if time.Since(time.Unix(0, checked)) < generationRefresh ||
+		!r.genChecked.CompareAndSwap(checked, time.Now().UnixNano()) {
+		return r.gen.Load()
+	}
+
+	gen, err := r.client.Get(ctx, r.keys.generation).Int64()
+	switch {
+	case err == nil:
+		r.gen.Store(gen)
+	case errors.Is(err, redis.Nil):
+		r.gen.Store(0)
+	default:
+		r.genChecked.Store(checked) // retry on the next call
+	}
+	return r.gen.Load()
+}
+
+// entryKey returns the Redis key of key in the current generation
+func (r *RedisBackend) entryKey(ctx context.Context, key string) string {
+	return r.generationPrefix(r.generation(ctx)) + key
+}
+
+func (r *RedisBackend) generationPrefix(gen int64) string {
+	return r.keys.prefix + strconv.FormatInt(gen, 10) + ":"
+}
+
+// Clear removes every entry of the namespace, leaving other data in the
+// database alone. Entries disappear at once; their keys are deleted in
+// the background.
+func (r *RedisBackend) Clear() error {
+	gen, err := r.client.Incr(r.ctx, r.keys.generation).Result()
+	if err != nil {
+		return fmt.Errorf("redis clear failed: %w", err)
+	}
+	r.gen.Store(gen)
+	r.genChecked.Store(time.Now().UnixNano())
+
+	r.sweeping.Add(1)
+	go func() {
+		defer r.sweeping.Done()
+		removed, err := r.sweep(r.sweepCtx, gen)
+		if r.sweepCtx.Err() != nil {
+			return // closed; the remaining keys expire by TTL
+		}
+		if err != nil {
+			log.Printf("⚠️  Redis namespace sweep stopped after %d keys: %v", removed, err)
+			return
+		}
+		log.Printf("🧹 Redis cache cleared: generation %d, %d old keys removed", gen, removed)
+	}()
+	return nil
+}
+
+// sweep deletes the namespace's entries from generations before gen in
+// SCAN-sized batches. Newer generations are left alone: another instance
+// may have cleared again and be filling one already.
+func (r *RedisBackend) sweep(ctx context.Context, gen int64) (int, error) {
+	return r.deleteScanned(ctx, r.keys.prefix+"*", func(keys []string) []string {
+		var old []string
+		for _, key := range keys {
+			g, _, _ := strings.Cut(strings.TrimPrefix(key, r.keys.prefix), ":")
+			if n, err := strconv.ParseInt(g, 10, 64); err == nil && n < gen {
+				old = append(old, key)
+			}
+		}
+		return old
+	})
+}
+
+// countEntries counts the keys of the current generation on every master.
+// SCAN may return a key twice while Redis rehashes, so it is approximate.
+func (r *RedisBackend) countEntries(ctx context.Context) (int64, error) {
+	pattern := r.generationPrefix(r.generation(ctx)) + "*"
+	var count atomic.Int64
+	err := r.forEachMaster(ctx, func(ctx context.Context, client redis.UniversalClient) error {
+		return scanKeys(ctx, client, pattern, func(keys []string) error {
+			count.Add(int64(len(keys)))
+			return nil
+		})
+	})
+	return count.Load(), err
+}
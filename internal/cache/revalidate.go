@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// An expired entry with a validator (ETag or Last-Modified) is kept for
+// staleRetention so the proxy can revalidate it with a conditional
+// request: a 304 Not Modified refreshes it without sending the body again.
+// Entries without a validator are dropped as soon as they expire.
+const staleRetention = 24 * time.Hour
+
+// Fresh reports whether the entry may be served without revalidation
+func (e *CacheEntry) Fresh() bool {
+	return !time.Now().After(e.ExpireAt)
+}
+
+// Revalidatable reports whether the entry has a validator the origin can
+// check it against
+func (e *CacheEntry) Revalidatable() bool {
+	return e.Headers.Get("ETag") != "" || e.Headers.Get("Last-Modified") != ""
+}
+
+// keepUntil returns when the entry is dropped from the cache
+func (e *CacheEntry) keepUntil() time.Time {
+	if e.Revalidatable() {
+		return e.ExpireAt.Add(staleRetention)
+	}
+	return e.ExpireAt
+}
+
+// conditionalHeaders are the request headers that make a request
+// conditional
+var conditionalHeaders = []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range"}
+
+// CanRevalidate reports whether the proxy may revalidate a stale entry
+// for r with conditions of its own: r must be a GET without conditions,
+// or the origin's 304 might answer the client's instead
+func CanRevalidate(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	for _, name := range conditionalHeaders {
+		if r.Header.Get(name) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// AddValidators makes h conditional on the entry's validators
+func (e *CacheEntry) AddValidators(h http.Header) {
+	if etag := e.Headers.Get("ETag"); etag != "" {
+		h.Set("If-None-Match", etag)
+	}
+	if lastModified := e.Headers.Get("Last-Modified"); lastModified != "" {
+		h.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// keptOnUpdate are the stored headers a 304 must not replace: the body
+// is unchanged, and hop-by-hop headers describe the 304's own connection
+var keptOnUpdate = map[string]bool{
+	"Content-Length":    true,
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Proxy-Connection":  true,
+	"Transfer-Encoding": true,
+	"Trailer":           true,
+	"Upgrade":           true,
+}
+
+// Revalidated returns a copy of the entry refreshed by the 304 response
+// resp: the headers resp carries replace the stored ones and freshness is
+// computed anew, defaulting to maxAge. The body is shared.
+func (e *CacheEntry) Revalidated(resp *http.Response, maxAge time.Duration) *CacheEntry {
+	refreshed := *e
+	refreshed.Headers = e.Headers.Clone()
+	if refreshed.Headers == nil {
+		refreshed.Headers = make(http.Header)
+	}
+	for name, values := range resp.Header {
+		if !keptOnUpdate[name] {
+			refreshed.Headers[name] = append([]string(nil), values...)
+		}
+	}
+
+	now := time.Now()
+	refreshed.CachedAt = now
+	refreshed.ExpireAt = expiresAt(refreshed.Headers, now, maxAge)
+	return &refreshed
+}
+
+// NotModified reports whether the conditions of r hold for the entry, so
+// a 304 can answer it. If-None-Match takes precedence over
+// If-Modified-Since, as in RFC 9110.
+func (e *CacheEntry) NotModified(r *http.Request) bool {
+	if (r.Method != http.MethodGet && r.Method != http.MethodHead) || e.StatusCode != http.StatusOK {
+		return false
+	}
+
+	if inm := r.Header.Values("If-None-Match"); len(inm) > 0 {
+		etag := e.Headers.Get("ETag")
+		return etag != "" && etagListMatches(strings.Join(inm, ","), etag)
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(e.Headers.Get("Last-Modified"))
+	return err == nil && !lastModified.After(ims)
+}
+
+// etagListMatches reports whether the If-None-Match list matches etag by
+// weak comparison, i.e. ignoring W/ prefixes
+func etagListMatches(list, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for {
+		list = strings.TrimLeft(list, " \t,")
+		switch {
+		case list == "":
+			return false
+		case list[0] == '*':
+			return true
+		}
+		list = strings.TrimPrefix(list, "W/")
+		if len(list) < 2 || list[0] != '"' {
+			return false
+		}
+		end := strings.IndexByte(list[1:], '"')
+		if end < 0 {
+			return false
+		}
+		if list[:end+2] == etag {
+			return true
+		}
+		list = list[end+2:]
+	}
+}
+
+// notModifiedHeaders are the headers of a 200 response that a 304 repeats
+var notModifiedHeaders = []string{"Cache-Control", "Content-Location", "Date", "ETag", "Expires", "Vary"}
+
+// NotModifiedHeader returns the headers of a 304 answering a conditional
+// request for the entry
+func (e *CacheEntry) NotModifiedHeader() http.Header {
+	h := make(http.Header)
+	for _, name := range notModifiedHeaders {
+		if values := e.Headers.Values(name); len(values) > 0 {
+			h[name] = append([]string(nil), values...)
+		}
+	}
+	return h
+}
+
+// WriteNotModified answers a conditional request for the entry with 304
+func (e *CacheEntry) WriteNotModified(w http.ResponseWriter) {
+	for name, values := range e.NotModifiedHeader() {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Age", ageSeconds(e.Age()))
+	w.Header().Set("X-Cache", "HIT")
+	w.Header().Set("X-Cache-Age", fmt.Sprintf("%.0f", time.Since(e.CachedAt).Seconds()))
+	w.WriteHeader(http.StatusNotModified)
+}
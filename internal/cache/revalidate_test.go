@@ -0,0 +1,222 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newStaleEntry(body string, headers http.Header) *CacheEntry {
+	entry := newTestEntryFor("https://example.com/", body)
+	entry.Headers = headers
+	entry.ExpireAt = time.Now().Add(-time.Second)
+	return entry
+}
+
+func TestNotModified(t *testing.T) {
+	entry := newTestEntry("body")
+	entry.Headers = http.Header{
+		"Etag":          {`W/"v2"`},
+		"Last-Modified": {"Wed, 01 Oct 2025 10:00:00 GMT"},
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+		want   bool
+	}{
+		{"unconditional", "GET", nil, false},
+		{"etag match", "GET", http.Header{"If-None-Match": {`"v2"`}}, true},
+		{"weak etag match", "GET", http.Header{"If-None-Match": {`W/"v2"`}}, true},
+		{"etag list", "GET", http.Header{"If-None-Match": {`"v1", "v2"`}}, true},
+		{"etag in second header", "GET", http.Header{"If-None-Match": {`"v1"`, `"v2"`}}, true},
+		{"wildcard", "GET", http.Header{"If-None-Match": {"*"}}, true},
+		{"etag mismatch", "GET", http.Header{"If-None-Match": {`"v1"`}}, false},
+		{"malformed etag", "GET", http.Header{"If-None-Match": {`v2`}}, false},
+		{"not modified since", "GET", http.Header{"If-Modified-Since": {"Wed, 01 Oct 2025 10:00:00 GMT"}}, true},
+		{"modified since", "GET", http.Header{"If-Modified-Since": {"Tue, 30 Sep 2025 10:00:00 GMT"}}, false},
+		{"invalid date", "GET", http.Header{"If-Modified-Since": {"yesterday"}}, false},
+		{"etag takes precedence", "GET", http.Header{
+			"If-None-Match":     {`"v1"`},
+			"If-Modified-Since": {"Thu, 02 Oct 2025 10:00:00 GMT"},
+		}, false},
+		{"head", "HEAD", http.Header{"If-None-Match": {`"v2"`}}, true},
+		{"post", "POST", http.Header{"If-None-Match": {`"v2"`}}, false},
+	}
+
+	for _, tt := range tests {
+		r, _ := http.NewRequest(tt.method, "https://example.com/", nil)
+		for name, values := range tt.header {
+			r.Header[name] = values
+		}
+		if got := entry.NotModified(r); got != tt.want {
+			t.Errorf("%s: NotModified = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	notFound := newTestEntry("")
+	notFound.StatusCode = http.StatusNotFound
+	notFound.Headers = entry.Headers
+	r, _ := http.NewRequest("GET", "https://example.com/", nil)
+	r.Header.Set("If-None-Match", "*")
+	if notFound.NotModified(r) {
+		t.Error("Only 200 responses should be answered with 304")
+	}
+}
+
+func TestCanRevalidate(t *testing.T) {
+	r, _ := http.NewRequest("GET", "https://example.com/", nil)
+	if !CanRevalidate(r) {
+		t.Error("Plain GET should be revalidated")
+	}
+	r.Header.Set("If-Modified-Since", "Wed, 01 Oct 2025 10:00:00 GMT")
+	if CanRevalidate(r) {
+		t.Error("The client's own conditions should be passed through")
+	}
+	r, _ = http.NewRequest("HEAD", "https://example.com/", nil)
+	if CanRevalidate(r) {
+		t.Error("Only GET should be revalidated")
+	}
+
+	entry := newStaleEntry("body", http.Header{"Etag": {`"v1"`}, "Last-Modified": {"Wed, 01 Oct 2025 10:00:00 GMT"}})
+	h := make(http.Header)
+	entry.AddValidators(h)
+	if h.Get("If-None-Match") != `"v1"` || h.Get("If-Modified-Since") != "Wed, 01 Oct 2025 10:00:00 GMT" {
+		t.Errorf("Unexpected conditions %v", h)
+	}
+}
+
+func TestRevalidated(t *testing.T) {
+	stale := newStaleEntry("body", http.Header{
+		"Etag":           {`"v1"`},
+		"Cache-Control":  {"max-age=0"},
+		"Content-Length": {"4"},
+		"Content-Type":   {"text/plain"},
+	})
+	resp := &http.Response{StatusCode: http.StatusNotModified, Header: http.Header{
+		"Cache-Control":  {"max-age=60"},
+		"Content-Length": {"0"},
+		"Connection":     {"close"},
+		"X-Version":      {"2"},
+	}}
+
+	refreshed := stale.Revalidated(resp, time.Minute)
+	if !refreshed.Fresh() || time.Until(refreshed.ExpireAt) < 59*time.Second {
+		t.Errorf("Expected 60s of freshness, expires at %v", refreshed.ExpireAt)
+	}
+	want := http.Header{
+		"Etag":           {`"v1"`},
+		"Cache-Control":  {"max-age=60"},
+		"Content-Length": {"4"},
+		"Content-Type":   {"text/plain"},
+		"X-Version":      {"2"},
+	}
+	for name := range want {
+		if got := refreshed.Headers.Get(name); got != want.Get(name) {
+			t.Errorf("%s = %q, want %q", name, got, want.Get(name))
+		}
+	}
+	if refreshed.Headers.Get("Connection") != "" {
+		t.Error("Hop-by-hop headers of the 304 should not be stored")
+	}
+	if string(refreshed.Body) != "body" || refreshed.URL != stale.URL {
+		t.Error("Body and URL should be kept")
+	}
+	if stale.Headers.Get("Cache-Control") != "max-age=0" || stale.Fresh() {
+		t.Error("The stale entry must not be modified")
+	}
+}
+
+func TestStaleEntriesKeptForRevalidation(t *testing.T) {
+	cache := NewHTTPCache(1<<20, time.Minute)
+	defer cache.Close()
+
+	cache.Set("validated", newStaleEntry("body", http.Header{"Etag": {`"v1"`}}))
+	cache.Set("plain", newStaleEntry("body", nil))
+
+	entry, found := cache.Get("validated")
+	if !found || entry.Fresh() {
+		t.Errorf("Stale entry with a validator should be returned: found=%v", found)
+	}
+	if _, found := cache.Get("plain"); found {
+		t.Error("Stale entry without a validator should be dropped")
+	}
+	if hits, misses, _, entries := cache.Stats(); hits != 0 || misses != 2 || entries != 1 {
+		t.Errorf("Stats: hits=%d misses=%d entries=%d", hits, misses, entries)
+	}
+
+	if n := cache.removeExpired(time.Now()); n != 0 {
+		t.Errorf("Cleanup should keep revalidatable entries, removed %d", n)
+	}
+	if n := cache.removeExpired(time.Now().Add(staleRetention)); n != 1 {
+		t.Errorf("Cleanup should drop entries past retention, removed %d", n)
+	}
+}
+
+func TestTieredCachePrefersFreshEntries(t *testing.T) {
+	_, redis := newTestRedis(t)
+	l1 := NewHTTPCache(1<<20, time.Minute)
+	defer l1.Close()
+	tc := NewTieredCache(l1, redis, time.Minute)
+
+	// Another instance already refreshed the entry in Redis
+	l1.Set("key", newStaleEntry("old", http.Header{"Etag": {`"v1"`}}))
+	fresh := newTestEntryFor("https://example.com/", "new")
+	fresh.Headers = http.Header{"Etag": {`"v1"`}}
+	redis.Set("key", fresh, time.Minute)
+
+	entry, found := tc.Get("key")
+	if !found || !entry.Fresh() || string(entry.Body) != "new" {
+		t.Fatalf("Expected the fresh Redis entry, got found=%v", found)
+	}
+	if entry, _ := l1.Get("key"); !entry.Fresh() {
+		t.Error("Fresh entry should be promoted over the stale one")
+	}
+
+	stale := newStaleEntry("old", http.Header{"Etag": {`"v2"`}})
+	tc.Set("other", stale)
+	if entry, found := tc.Get("other"); !found || entry.Fresh() {
+		t.Errorf("Stale entry should be returned for revalidation: found=%v", found)
+	}
+	if hits, misses, _, _, _ := tc.Stats(); hits != 1 || misses != 1 {
+		t.Errorf("Stale lookups should count as misses: hits=%d misses=%d", hits, misses)
+	}
+}
+
+func TestDiskCacheRefreshesStreamedBody(t *testing.T) {
+	c := newTestDiskCache(t, t.TempDir(), 10<<20)
+	body := bytes.Repeat([]byte("x"), diskInlineBody+1)
+	c.Set("large", newStaleEntry(string(body), http.Header{"Etag": {`"v1"`}}))
+
+	stale, found := c.Get("large")
+	if !found || stale.Fresh() || stale.bodyFile == "" {
+		t.Fatalf("Expected a stale entry streamed from disk: found=%v", found)
+	}
+	refreshed := stale.Revalidated(&http.Response{Header: http.Header{"Cache-Control": {"max-age=60"}}}, time.Minute)
+
+	// Served before it is stored, from the file it replaces
+	served, err := refreshed.OpenBody()
+	if err != nil {
+		t.Fatalf("OpenBody: %v", err)
+	}
+	served.Close()
+	if err := c.Set("large", refreshed); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	entry, found := c.Get("large")
+	if !found || !entry.Fresh() || entry.Size != int64(len(body)) {
+		t.Fatalf("Expected the refreshed entry: found=%v", found)
+	}
+	r, err := entry.OpenBody()
+	if err != nil {
+		t.Fatalf("OpenBody: %v", err)
+	}
+	defer r.Close()
+	if data, _ := io.ReadAll(r); !bytes.Equal(data, body) {
+		t.Errorf("Body differs after refresh: %d bytes", len(data))
+	}
+}
@@ -1,23 +1,39 @@
 package cache
 
 import (
+	"context"
+	"fmt"
+	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// TieredCache implements multi-tier caching (L1: Memory, L2: Redis)
+// l2RetryDelay is how long the Redis tier is skipped after an error, so
+// an outage costs one timeout rather than one per request
+const l2RetryDelay = 10 * time.Second
+
+// TieredCache implements multi-tier caching (L1: Memory, L2: Redis), with
+// an optional disk tier between them. Any tier may be nil: without L2 it
+// is a local cache, without L1 every lookup goes to disk or Redis.
 type TieredCache struct {
 	l1         *HTTPCache
+	disk       *DiskCache
 	l2         *RedisBackend
 	maxAge     time.Duration
-	mu         sync.RWMutex
-	hitCount   uint64
-	missCount  uint64
-	l1Hits     uint64
-	l2Hits     uint64
+	hitCount   atomic.Uint64
+	missCount  atomic.Uint64
+	l1Hits     atomic.Uint64
+	diskHits   atomic.Uint64
+	l2Hits     atomic.Uint64
+	mu         sync.RWMutex // guards the L2 error state
+	l2Errors   uint64
+	l2Down     time.Time // L2 is skipped until then
+	stopListen context.CancelFunc
+	listening  sync.WaitGroup
 }
 
-// NewTieredCache creates a new tiered cache
+// NewTieredCache creates a new tiered cache; l1 or l2 may be nil
 func NewTieredCache(l1 *HTTPCache, l2 *RedisBackend, maxAge time.Duration) *TieredCache {
 	return &TieredCache{
 		l1:     l1,
@@ -26,84 +42,185 @@ func NewTieredCache(l1 *HTTPCache, l2 *RedisBackend, maxAge time.Duration) *Tier
 	}
 }
 
-// Get retrieves entry from L1 (fast) then L2 (slower)
+// WithDisk adds a disk tier below L1; call it before the cache is used
+func (c *TieredCache) WithDisk(disk *DiskCache) *TieredCache {
+	c.disk = disk
+	return c
+}
+
+// Get retrieves entry from L1 (fast), then disk, then L2 (slower)
 func (c *TieredCache) Get(key string) (*CacheEntry, bool) {
+	return c.GetContext(context.Background(), key)
+}
+
+// GetContext is Get with the Redis lookup traced as part of ctx. When
+// the only entry found is stale it is returned for revalidation, counted
+// as a miss; lower tiers are tried first in case another instance already
+// refreshed it.
+func (c *TieredCache) GetContext(ctx context.Context, key string) (*CacheEntry, bool) {
+	var stale *CacheEntry
+
 	// Try L1 cache (in-memory, fast)
-	if entry, found := c.l1.Get(key); found {
-		c.mu.Lock()
-		c.hitCount++
-		c.l1Hits++
-		c.mu.Unlock()
-		return entry, true
+	if c.l1 != nil {
+		if entry, found := c.l1.Get(key); found {
+			if entry.Fresh() {
+				c.hitCount.Add(1)
+				c.l1Hits.Add(1)
+				return entry, true
+			}
+			stale = entry
+		}
 	}
 
-	// Try L2 cache (Redis, slower but distributed)
-	if c.l2 != nil {
-		entry, err := c.l2.Get(key)
-		if err == nil && entry != nil {
-			// Promote to L1 for faster future access
-			c.l1.Set(key, entry)
+	if c.disk != nil {
+		if entry, found := c.disk.Get(key); found {
+			if entry.Fresh() {
+				// Large bodies stay on disk and are streamed from there
+				if c.l1 != nil && entry.bodyFile == "" {
+					c.l1.Set(key, entry)
+				}
+				c.hitCount.Add(1)
+				c.diskHits.Add(1)
+				return entry, true
+			}
+			if stale == nil {
+				stale = entry
+			}
+		}
+	}
 
-			c.mu.Lock()
-			c.hitCount++
-			c.l2Hits++
-			c.mu.Unlock()
+	// Try L2 cache (Redis, slower but distributed)
+	if c.l2Available() {
+		entry, err := c.l2.GetContext(ctx, key)
+		if err != nil {
+			c.l2Failed(err)
+		} else if entry != nil && entry.Fresh() {
+			// Promote to L1 and disk for faster future access; the disk
+			// write is fsynced, so it happens off the request path
+			if c.l1 != nil {
+				c.l1.Set(key, entry)
+			}
+			if c.disk != nil {
+				c.disk.SetAsync(key, entry)
+			}
 
+			c.hitCount.Add(1)
+			c.l2Hits.Add(1)
 			return entry, true
+		} else if entry != nil && stale == nil {
+			stale = entry
 		}
 	}
 
 	// Cache miss
-	c.mu.Lock()
-	c.missCount++
-	c.mu.Unlock()
-
-	return nil, false
+	c.missCount.Add(1)
+	return stale, stale != nil
 }
 
-// Set stores entry in both L1 and L2
+// Set stores entry in every tier
 func (c *TieredCache) Set(key string, entry *CacheEntry) error {
-	// Always store in L1
-	c.l1.Set(key, entry)
+	return c.SetContext(context.Background(), key, entry)
+}
+
+// SetContext is Set with the Redis write traced as part of ctx. It fails
+// only if no tier stored the entry.
+func (c *TieredCache) SetContext(ctx context.Context, key string, entry *CacheEntry) error {
+	var err error
+	stored := false
+	// A body streamed from disk is not in memory: only the disk takes it
+	inMemory := entry.bodyFile == ""
+	if c.l1 != nil && inMemory {
+		if err = c.l1.Set(key, entry); err == nil {
+			stored = true
+		}
+	}
+
+	if c.disk != nil {
+		if diskErr := c.disk.Set(key, entry); diskErr != nil {
+			err = diskErr
+		} else {
+			stored = true
+		}
+	}
 
 	// Store in L2 if available
-	if c.l2 != nil {
-		ttl := time.Until(entry.ExpireAt)
+	if c.l2Available() && inMemory {
+		ttl := time.Until(entry.keepUntil())
 		if ttl > 0 {
-			return c.l2.Set(key, entry, ttl)
+			if l2Err := c.l2.SetContext(ctx, key, entry, ttl); l2Err != nil {
+				c.l2Failed(l2Err)
+				err = l2Err
+			} else {
+				stored = true
+			}
 		}
 	}
 
-	return nil
+	if stored {
+		return nil
+	}
+	return err
 }
 
-// Delete removes entry from both tiers
+// l2Available reports whether L2 exists and is not backing off
+func (c *TieredCache) l2Available() bool {
+	if c.l2 == nil {
+		return false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return !time.Now().Before(c.l2Down)
+}
+
+// l2Failed counts an L2 error and skips L2 for l2RetryDelay, serving
+// from L1 alone in the meantime
+func (c *TieredCache) l2Failed(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.l2Errors++
+	if time.Now().Before(c.l2Down) {
+		return
+	}
+	c.l2Down = time.Now().Add(l2RetryDelay)
+	log.Printf("⚠️  Redis cache error, using memory only for %v: %v", l2RetryDelay, err)
+}
+
+// Delete removes entry from every tier
 func (c *TieredCache) Delete(key string) {
-	c.l1.Delete(key)
+	if c.l1 != nil {
+		c.l1.Delete(key)
+	}
+
+	if c.disk != nil {
+		c.disk.Delete(key)
+	}
 
 	if c.l2 != nil {
 		c.l2.Delete(key)
 	}
 }
 
-// Clear removes all entries from both tiers
-func (c *TieredCache) Clear() {
-	c.l1.Clear()
+// Clear removes all entries from every tier and tells other instances to
+// drop their local copies
+func (c *TieredCache) Clear() error {
+	c.clearLocal()
 
-	if c.l2 != nil {
-		c.l2.Clear()
+	if c.l2 == nil {
+		return nil
 	}
+	if err := c.l2.Clear(); err != nil {
+		return err
+	}
+	return c.l2.PublishInvalidation("*") // host glob matching every URL
 }
 
 // Stats returns tiered cache statistics
 func (c *TieredCache) Stats() (hits, misses, l1Hits, l2Hits uint64, hitRate float64) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	hits = c.hitCount
-	misses = c.missCount
-	l1Hits = c.l1Hits
-	l2Hits = c.l2Hits
+	hits = c.hitCount.Load()
+	misses = c.missCount.Load()
+	l1Hits = c.l1Hits.Load()
+	l2Hits = c.l2Hits.Load()
 
 	total := hits + misses
 	if total > 0 {
@@ -113,16 +230,136 @@ func (c *TieredCache) Stats() (hits, misses, l1Hits, l2Hits uint64, hitRate floa
 	return
 }
 
-// InvalidatePattern invalidates cache entries matching pattern
-// Publishes invalidation to other instances via Redis Pub/Sub
-func (c *TieredCache) InvalidatePattern(pattern string) error {
-	// Clear local caches
-	// Note: This is simplified - production would use pattern matching
-	c.Clear()
+// DiskHits returns how many lookups the disk tier answered
+func (c *TieredCache) DiskHits() uint64 {
+	return c.diskHits.Load()
+}
+
+// L2Errors returns how many Redis operations failed
+func (c *TieredCache) L2Errors() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.l2Errors
+}
+
+// Memory returns the L1 cache, or nil in Redis-only mode
+func (c *TieredCache) Memory() *HTTPCache {
+	return c.l1
+}
+
+// Disk returns the disk tier, or nil without one
+func (c *TieredCache) Disk() *DiskCache {
+	return c.disk
+}
+
+// Redis returns the L2 backend, or nil in memory-only mode
+func (c *TieredCache) Redis() *RedisBackend {
+	return c.l2
+}
+
+// PurgeURL removes every variant of url from every tier and publishes the
+// purge so other instances drop their local copies. It returns how many
+// entries were removed.
+func (c *TieredCache) PurgeURL(ctx context.Context, url string) (int, error) {
+	p := ExactPattern(url)
+	memory, disk := c.purgeLocal(p.Match)
+	if c.l2 == nil {
+		return memory + disk, nil
+	}
+
+	redis, err := c.l2.DeleteMatching(ctx, p.Match)
+	if err != nil {
+		return memory + disk + redis, err
+	}
+	return memory + disk + redis, c.l2.PublishInvalidation(p.String())
+}
+
+// InvalidatePattern removes entries whose request URL matches pattern (see
+// ParsePattern) from every tier and publishes it so other instances drop
+// their local copies. It returns how many entries each tier removed.
+func (c *TieredCache) InvalidatePattern(ctx context.Context, pattern string) (memory, disk, redis int, err error) {
+	p, err := ParsePattern(pattern)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	memory, disk = c.purgeLocal(p.Match)
+
+	if c.l2 != nil {
+		if redis, err = c.l2.DeleteMatching(ctx, p.Match); err != nil {
+			return memory, disk, redis, err
+		}
+		if err = c.l2.PublishInvalidation(p.String()); err != nil {
+			return memory, disk, redis, err
+		}
+	}
+
+	return memory, disk, redis, nil
+}
+
+// purgeLocal removes matching entries from L1 and disk
+func (c *TieredCache) purgeLocal(match func(url string) bool) (memory, disk int) {
+	if c.l1 != nil {
+		memory = c.l1.PurgeMatch(match)
+	}
+	if c.disk != nil {
+		disk = c.disk.PurgeMatch(match)
+	}
+	return memory, disk
+}
+
+// clearLocal empties L1 and disk
+func (c *TieredCache) clearLocal() {
+	if c.l1 != nil {
+		c.l1.Clear()
+	}
+	if c.disk != nil {
+		c.disk.Clear()
+	}
+}
+
+// ListenInvalidations subscribes to patterns published by other instances
+// and drops matching entries from the memory and disk tiers until Close.
+// It does nothing without Redis or a local tier.
+func (c *TieredCache) ListenInvalidations() {
+	if (c.l1 == nil && c.disk == nil) || c.l2 == nil || c.stopListen != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopListen = cancel
+	c.listening.Add(1)
+	go func() {
+		defer c.listening.Done()
+		c.l2.SubscribeInvalidations(ctx, func(pattern string) {
+			p, err := ParsePattern(pattern)
+			if err != nil {
+				log.Printf("⚠️  Ignoring cache invalidation %q: %v", pattern, err)
+				return
+			}
+			c.purgeLocal(p.Match)
+		}, c.clearLocal)
+	}()
+}
+
+// Close stops the L1 and disk cleanup goroutines and closes the Redis
+// connection
+func (c *TieredCache) Close() error {
+	if c.stopListen != nil {
+		c.stopListen()
+		c.listening.Wait()
+	}
+
+	if c.l1 != nil {
+		c.l1.Close()
+	}
+
+	if c.disk != nil {
+		c.disk.Close()
+	}
 
-	// Publish to other instances
 	if c.l2 != nil {
-		return c.l2.PublishInvalidation(pattern)
+		return c.l2.Close()
 	}
 
 	return nil
@@ -0,0 +1,297 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *RedisBackend) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	backend, err := NewRedisBackend(mr.Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("NewRedisBackend: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	return mr, backend
+}
+
+func newTestEntryFor(url, body string) *CacheEntry {
+	entry := newTestEntry(body)
+	entry.URL = url
+	return entry
+}
+
+func newTestEntry(body string) *CacheEntry {
+	return &CacheEntry{
+		StatusCode: 200,
+		Body:       []byte(body),
+		CachedAt:   time.Now(),
+		ExpireAt:   time.Now().Add(time.Minute),
+		Size:       int64(len(body)),
+	}
+}
+
+func TestTieredCachePromotesRedisHits(t *testing.T) {
+	_, redis := newTestRedis(t)
+
+	// Another instance stored the entry: only Redis has it
+	writer := NewTieredCache(nil, redis, time.Minute)
+	if err := writer.Set("key", newTestEntry("shared")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	l1 := NewHTTPCache(1024*1024, time.Minute)
+	defer l1.Close()
+	tc := NewTieredCache(l1, redis, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		entry, found := tc.Get("key")
+		if !found || string(entry.Body) != "shared" {
+			t.Fatalf("Get %d: found=%v entry=%v", i, found, entry)
+		}
+	}
+	if _, found := tc.Get("other"); found {
+		t.Error("Unknown key should miss")
+	}
+
+	hits, misses, l1Hits, l2Hits, _ := tc.Stats()
+	if hits != 2 || misses != 1 || l1Hits != 1 || l2Hits != 1 {
+		t.Errorf("Expected 1 Redis hit then 1 promoted memory hit, got hits=%d misses=%d l1=%d l2=%d",
+			hits, misses, l1Hits, l2Hits)
+	}
+}
+
+func TestTieredCacheDegradesToMemory(t *testing.T) {
+	mr, redis := newTestRedis(t)
+	l1 := NewHTTPCache(1024*1024, time.Minute)
+	defer l1.Close()
+	tc := NewTieredCache(l1, redis, time.Minute)
+
+	mr.Close()
+
+	if err := tc.Set("key", newTestEntry("local")); err != nil {
+		t.Errorf("Set should succeed on the memory tier while Redis is down: %v", err)
+	}
+	if entry, found := tc.Get("key"); !found || string(entry.Body) != "local" {
+		t.Errorf("Expected memory hit, got found=%v", found)
+	}
+	if _, found := tc.Get("missing"); found {
+		t.Error("Unknown key should miss")
+	}
+
+	// The failed write backs Redis off, so later lookups don't retry it
+	if errs := tc.L2Errors(); errs != 1 {
+		t.Errorf("Expected 1 Redis error during the back-off, got %d", errs)
+	}
+}
+
+func TestTieredCacheMemoryOnly(t *testing.T) {
+	l1 := NewHTTPCache(1024*1024, time.Minute)
+	tc := NewTieredCache(l1, nil, time.Minute)
+	defer tc.Close()
+
+	entry := newTestEntry("memory")
+	entry.URL = "http://example.com/"
+	tc.Set("key", entry)
+	if _, found := tc.Get("key"); !found {
+		t.Error("Expected memory hit")
+	}
+	if n, err := tc.PurgeURL(context.Background(), "http://example.com/"); err != nil || n != 1 {
+		t.Errorf("Expected 1 purged entry, got %d", n)
+	}
+	if tc.Redis() != nil || tc.Memory() != l1 {
+		t.Error("Unexpected tiers")
+	}
+}
+
+func TestRedisDeleteMatchingScansNamespace(t *testing.T) {
+	mr, redis := newTestRedis(t)
+	mr.Set("unrelated", "kept")
+
+	// More entries than one SCAN batch
+	for i := 0; i < scanBatch+10; i++ {
+		url := fmt.Sprintf("https://a.example/%d", i)
+		if i%2 == 1 {
+			url = fmt.Sprintf("https://b.example/%d", i)
+		}
+		if err := redis.Set(fmt.Sprint(i), newTestEntryFor(url, "x"), time.Minute); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+
+	p, _ := ParsePattern("b.example")
+	n, err := redis.DeleteMatching(context.Background(), p.Match)
+	if err != nil || n != (scanBatch+10)/2 {
+		t.Fatalf("DeleteMatching: n=%d err=%v", n, err)
+	}
+	if entry, _ := redis.Get("1"); entry != nil {
+		t.Error("Matching entry should be gone")
+	}
+	if entry, err := redis.Get("0"); entry == nil {
+		t.Errorf("Other host should be kept: %v", err)
+	}
+	if !mr.Exists("unrelated") {
+		t.Error("Keys outside the namespace must not be touched")
+	}
+}
+
+func TestInvalidationPropagatesBetweenInstances(t *testing.T) {
+	mr, _ := newTestRedis(t)
+
+	newInstance := func() *TieredCache {
+		redis, err := NewRedisBackend(mr.Addr(), "", 0)
+		if err != nil {
+			t.Fatalf("NewRedisBackend: %v", err)
+		}
+		tc := NewTieredCache(NewHTTPCache(1024*1024, time.Minute), redis, time.Minute)
+		tc.ListenInvalidations()
+		t.Cleanup(func() { tc.Close() })
+		return tc
+	}
+	first, second := newInstance(), newInstance()
+	channel := first.Redis().keys.channel
+
+	// Wait until both listeners are subscribed
+	deadline := time.Now().Add(5 * time.Second)
+	for mr.PubSubNumSub(channel)[channel] < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Listeners never subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	first.Set("js", newTestEntryFor("https://example.com/app.js", "js"))
+	first.Set("css", newTestEntryFor("https://example.com/app.css", "css"))
+	// Promote both into the second instance's memory tier
+	second.Get("js")
+	second.Get("css")
+
+	memory, _, redis, err := first.InvalidatePattern(context.Background(), "https://example.com/*.js")
+	if err != nil || memory != 1 || redis != 1 {
+		t.Fatalf("InvalidatePattern: memory=%d redis=%d err=%v", memory, redis, err)
+	}
+
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		if _, _, _, entries := second.Memory().Stats(); entries == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Second instance never dropped the invalidated entry")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, found := second.Get("css"); !found {
+		t.Error("Non-matching entry should survive")
+	}
+	if _, _, _, err := first.InvalidatePattern(context.Background(), "example.com/x"); err == nil {
+		t.Error("Invalid pattern should fail")
+	}
+
+	if err := first.Clear(); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		if _, _, _, entries := second.Memory().Stats(); entries == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Clear should empty the other instance's memory tier")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestInvalidationListenerResubscribes(t *testing.T) {
+	mr, redis := newTestRedis(t)
+	tc := NewTieredCache(NewHTTPCache(1024*1024, time.Minute), redis, time.Minute)
+	tc.ListenInvalidations()
+	defer tc.Close()
+	channel := redis.keys.channel
+
+	waitSubscribed := func() {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for mr.PubSubNumSub(channel)[channel] < 1 {
+			if time.Now().After(deadline) {
+				t.Fatal("Listener never subscribed")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitSubscribed()
+
+	mr.Close()
+	// Written while disconnected; invalidations sent now would be lost
+	tc.Memory().Set("stale", newTestEntryFor("https://example.com/", "stale"))
+	if err := mr.Restart(); err != nil {
+		t.Fatalf("Restart: %v", err)
+	}
+	waitSubscribed()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, found := tc.Memory().Get("stale"); !found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Memory tier should be cleared after resubscribing")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTieredPurgeURLReachesRedis(t *testing.T) {
+	mr, redis := newTestRedis(t)
+	tc := NewTieredCache(NewHTTPCache(1024*1024, time.Minute), redis, time.Minute)
+	defer tc.Close()
+
+	other, err := NewRedisBackend(mr.Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("NewRedisBackend: %v", err)
+	}
+	defer other.Close()
+	purged := make(chan string, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go other.SubscribeInvalidations(ctx, func(pattern string) { purged <- pattern }, func() {})
+	channel := redis.keys.channel
+	deadline := time.Now().Add(5 * time.Second)
+	for mr.PubSubNumSub(channel)[channel] < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Listener never subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Stored by another instance: only in Redis
+	redis.Set("a", newTestEntryFor("https://example.com/a", "a"), time.Minute)
+	redis.Set("ab", newTestEntryFor("https://example.com/ab", "ab"), time.Minute)
+	tc.Set("a-gzip", newTestEntryFor("https://example.com/a", "gzip"))
+
+	n, err := tc.PurgeURL(context.Background(), "https://example.com/a")
+	if err != nil || n != 3 {
+		t.Fatalf("PurgeURL: n=%d err=%v", n, err)
+	}
+	if _, found := tc.Get("a"); found {
+		t.Error("Purged entry came back from Redis")
+	}
+	if _, found := tc.Get("ab"); !found {
+		t.Error("Only the exact URL should be purged")
+	}
+
+	select {
+	case pattern := <-purged:
+		if pattern != "=https://example.com/a" {
+			t.Errorf("Published %q", pattern)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Purge was not published")
+	}
+}
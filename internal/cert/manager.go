@@ -1,6 +1,7 @@
 package cert
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -9,8 +10,13 @@ import (
 	"encoding/pem"
 	"fmt"
 	"math/big"
+	"os"
 	"sync"
 	"time"
+
+	"github.com/onixus/4ebur-net/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // CertManager manages TLS certificates for MITM proxy
@@ -18,25 +24,52 @@ type CertManager struct {
 	ca      *x509.Certificate
 	caKey   *rsa.PrivateKey
 	certMap sync.Map // hostname -> *tls.Certificate
+
+	// onGenerate observes every certificate generation
+	onGenerate func(hostname string, took time.Duration, err error)
+}
+
+// SetGenerateHook registers fn to observe certificate generation, e.g. for
+// metrics. It must be set before the manager is used.
+func (m *CertManager) SetGenerateHook(fn func(hostname string, took time.Duration, err error)) {
+	m.onGenerate = fn
 }
 
 // NewCertManager creates a new certificate manager with a CA certificate
 func NewCertManager() (*CertManager, error) {
+	caCert, caKey, err := GenerateCA("4ebur-net CA", 10*365*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CertManager{
+		ca:    caCert,
+		caKey: caKey,
+	}, nil
+}
+
+// GenerateCA creates a self-signed CA certificate and its private key
+func GenerateCA(commonName string, validity time.Duration) (*x509.Certificate, *rsa.PrivateKey, error) {
 	// Generate CA private key
 	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate CA key: %w", err)
+		return nil, nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate serial number: %w", err)
 	}
 
 	// Create CA certificate template
 	caTemplate := &x509.Certificate{
-		SerialNumber: big.NewInt(1),
+		SerialNumber: serial,
 		Subject: pkix.Name{
 			Organization: []string{"4ebur-net MITM Proxy"},
-			CommonName:   "4ebur-net CA",
+			CommonName:   commonName,
 		},
 		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(10, 0, 0), // 10 years
+		NotAfter:              time.Now().Add(validity),
 		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
@@ -46,13 +79,52 @@ func NewCertManager() (*CertManager, error) {
 	// Create self-signed CA certificate
 	caCertDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create CA certificate: %w", err)
+		return nil, nil, fmt.Errorf("failed to create CA certificate: %w", err)
 	}
 
 	caCert, err := x509.ParseCertificate(caCertDER)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+
+	return caCert, caKey, nil
+}
+
+// NewCertManagerFromFiles creates a certificate manager using an existing
+// CA certificate and RSA private key stored as PEM files
+func NewCertManagerFromFiles(certFile, keyFile string) (*CertManager, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA key: %w", err)
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil || certBlock.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s: no PEM certificate found", certFile)
+	}
+	caCert, err := x509.ParseCertificate(certBlock.Bytes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
 	}
+	if !caCert.IsCA {
+		return nil, fmt.Errorf("%s: certificate is not a CA", certFile)
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("%s: no PEM key found", keyFile)
+	}
+	caKey, err := parseRSAKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA key: %w", err)
+	}
+	if !caKey.PublicKey.Equal(caCert.PublicKey) {
+		return nil, fmt.Errorf("CA key does not match certificate")
+	}
 
 	return &CertManager{
 		ca:    caCert,
@@ -60,16 +132,50 @@ func NewCertManager() (*CertManager, error) {
 	}, nil
 }
 
+// parseRSAKey parses a PKCS#1 or PKCS#8 RSA private key
+func parseRSAKey(der []byte) (*rsa.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T, expected RSA", key)
+	}
+	return rsaKey, nil
+}
+
 // GetCertificate returns a certificate for the given hostname
 func (m *CertManager) GetCertificate(hostname string) (*tls.Certificate, error) {
+	return m.GetCertificateContext(context.Background(), hostname)
+}
+
+// GetCertificateContext is GetCertificate recorded as a span of the trace
+// in ctx
+func (m *CertManager) GetCertificateContext(ctx context.Context, hostname string) (*tls.Certificate, error) {
+	_, span := tracing.Start(ctx, "CertManager.GetCertificate", trace.SpanKindInternal,
+		attribute.String("tls.server_name", hostname))
+	defer span.End()
+
 	// Check cache
 	if cert, ok := m.certMap.Load(hostname); ok {
+		span.SetAttributes(attribute.Bool("cert.cached", true))
 		return cert.(*tls.Certificate), nil
 	}
+	span.SetAttributes(attribute.Bool("cert.cached", false))
 
 	// Generate new certificate
+	start := time.Now()
 	cert, err := m.generateCertificate(hostname)
+	if m.onGenerate != nil {
+		m.onGenerate(hostname, time.Since(start), err)
+	}
 	if err != nil {
+		tracing.SetError(span, err)
 		return nil, err
 	}
 
@@ -115,6 +221,24 @@ func (m *CertManager) generateCertificate(hostname string) (*tls.Certificate, er
 	return tlsCert, nil
 }
 
+// CACertificate returns the CA certificate
+func (m *CertManager) CACertificate() *x509.Certificate {
+	return m.ca
+}
+
+// CAKey returns the CA private key
+func (m *CertManager) CAKey() *rsa.PrivateKey {
+	return m.caKey
+}
+
+// EncodeKeyPEM returns key as a PKCS#1 PEM block
+func EncodeKeyPEM(key *rsa.PrivateKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+}
+
 // GetCACertPEM returns the CA certificate in PEM format
 func (m *CertManager) GetCACertPEM() []byte {
 	return pem.EncodeToMemory(&pem.Block{
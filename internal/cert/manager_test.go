@@ -2,6 +2,10 @@ package cert
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -183,3 +187,42 @@ func BenchmarkConcurrentGetCertificate(b *testing.B) {
 		}
 	})
 }
+
+func TestNewCertManagerFromFiles(t *testing.T) {
+	generated, err := NewCertManager()
+	if err != nil {
+		t.Fatalf("Failed to create cert manager: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "ca.crt")
+	keyFile := filepath.Join(dir, "ca.key")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(generated.caKey)})
+	if err := os.WriteFile(certFile, generated.GetCACertPEM(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	manager, err := NewCertManagerFromFiles(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertManagerFromFiles() failed: %v", err)
+	}
+	if !manager.ca.Equal(generated.ca) {
+		t.Error("Loaded CA differs from the saved one")
+	}
+	if _, err := manager.GetCertificate("example.com"); err != nil {
+		t.Errorf("GetCertificate() with loaded CA failed: %v", err)
+	}
+
+	// A key from another CA must be rejected
+	other, _ := NewCertManager()
+	otherKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(other.caKey)})
+	if err := os.WriteFile(keyFile, otherKey, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCertManagerFromFiles(certFile, keyFile); err == nil {
+		t.Error("Expected mismatched key to be rejected")
+	}
+}
@@ -0,0 +1,707 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete proxy configuration. Values are layered:
+// built-in defaults, then environment variables, then the config file.
+type Config struct {
+	Listen    ListenConfig    `yaml:"listen"`
+	Admin     AdminConfig     `yaml:"admin"`
+	Cert      CertConfig      `yaml:"cert"`
+	Cache     CacheConfig     `yaml:"cache"`
+	Upstream  UpstreamConfig  `yaml:"upstream"`
+	ACL       ACLConfig       `yaml:"acl"`
+	Shutdown  ShutdownConfig  `yaml:"shutdown"`
+	WebSocket WebSocketConfig `yaml:"websocket"`
+	Metrics   MetricsConfig   `yaml:"metrics"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+	AccessLog AccessLogConfig `yaml:"access_log"`
+	Redact    RedactConfig    `yaml:"redact"`
+	Fixtures  FixturesConfig  `yaml:"fixtures"`
+}
+
+// ListenConfig describes the proxy listeners
+type ListenConfig struct {
+	Proxy           string `yaml:"proxy"`
+	Transparent     string `yaml:"transparent"`
+	TransparentMode string `yaml:"transparent_mode"`
+}
+
+// AdminConfig describes the management listener
+type AdminConfig struct {
+	// Listen is a TCP host:port or "unix:/path/to.sock"; when empty the
+	// management routes stay on the proxy port for origin-form requests
+	Listen string `yaml:"listen"`
+	// Token is required as "Authorization: Bearer <token>" on every route
+	// except /health and /ca.crt
+	Token string `yaml:"token"`
+}
+
+// CertConfig describes the MITM certificate authority
+type CertConfig struct {
+	// CAFile and KeyFile load an existing CA; when empty a fresh CA is
+	// generated on every start
+	CAFile  string `yaml:"ca_file"`
+	KeyFile string `yaml:"key_file"`
+}
+
+// CacheConfig describes the HTTP cache
+type CacheConfig struct {
+	// Backend is memory, disk, redis or tiered (memory in front of Redis)
+	Backend         string   `yaml:"backend"`
+	SizeMB          int64    `yaml:"size_mb"` // memory tier
+	MaxAge          Duration `yaml:"max_age"`
+	MaxObjectSizeMB int64    `yaml:"max_object_size_mb"`
+	// Admission decides what the memory tier keeps once it is full
+	Admission AdmissionConfig `yaml:"admission"`
+	Disk      DiskCacheConfig `yaml:"disk"`
+	Redis     RedisConfig     `yaml:"redis"`
+}
+
+// DiskCacheConfig describes the disk tier, which sits below the memory
+// tier and above Redis
+type DiskCacheConfig struct {
+	// Dir holds the cache files; empty disables the disk tier
+	Dir    string `yaml:"dir"`
+	SizeMB int64  `yaml:"size_mb"`
+}
+
+// AdmissionConfig describes the memory tier's admission policy
+type AdmissionConfig struct {
+	// Policy is tinylfu (a full cache only takes entries requested at
+	// least as often as those they would evict) or none
+	Policy string `yaml:"policy"`
+	// MaxObjectSizeKB keeps larger responses out of the memory tier; they
+	// are still stored in Redis. 0 means no limit beyond max_object_size_mb.
+	MaxObjectSizeKB int64 `yaml:"max_object_size_kb"`
+	// EvictLargeFirst evicts the largest of the least recently used
+	// entries first
+	EvictLargeFirst bool `yaml:"evict_large_first"`
+}
+
+// RedisConfig describes the Redis cache tier. Addr is a single server;
+// with MasterName set Addrs lists the Sentinels, with Cluster set it seeds
+// the cluster.
+type RedisConfig struct {
+	Addr       string   `yaml:"addr"`
+	Addrs      []string `yaml:"addrs"`
+	MasterName string   `yaml:"master_name"`
+	Cluster    bool     `yaml:"cluster"`
+	// Username selects an ACL user; empty authenticates as default
+	Username         string         `yaml:"username"`
+	Password         string         `yaml:"password"`
+	SentinelPassword string         `yaml:"sentinel_password"`
+	DB               int            `yaml:"db"`
+	TLS              RedisTLSConfig `yaml:"tls"`
+	// Namespace prefixes every key so environments or tenants can share
+	// a database; instances with the same namespace share entries
+	Namespace string `yaml:"namespace"`
+	// CompressMinKB zstd-compresses stored bodies of at least this size;
+	// 0 disables compression
+	CompressMinKB int `yaml:"compress_min_kb"`
+}
+
+// RedisTLSConfig describes TLS to Redis and Sentinel
+type RedisTLSConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// CAFile verifies the server; empty uses the system roots
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile authenticate the proxy with a client certificate
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// UpstreamConfig describes how the proxy talks to origin servers
+type UpstreamConfig struct {
+	// ParentProxy forwards all upstream traffic through another proxy
+	ParentProxy         string         `yaml:"parent_proxy"`
+	MaxIdleConns        int            `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost int            `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int            `yaml:"max_conns_per_host"`
+	IdleTimeout         Duration       `yaml:"idle_timeout"`
+	TotalTimeout        Duration       `yaml:"total_timeout"`
+	Routes              []TimeoutRoute `yaml:"routes"`
+}
+
+// TimeoutRoute overrides response timeouts for matching requests
+type TimeoutRoute struct {
+	Pattern      string   `yaml:"pattern"`
+	IdleTimeout  Duration `yaml:"idle_timeout"`
+	TotalTimeout Duration `yaml:"total_timeout"`
+}
+
+// ACLConfig restricts who may use the proxy and where they may go
+type ACLConfig struct {
+	// AllowClients lists client CIDRs allowed to connect (empty = everyone)
+	AllowClients []string `yaml:"allow_clients"`
+	// DenyHosts lists destination host globs that are refused
+	DenyHosts []string `yaml:"deny_hosts"`
+}
+
+// ShutdownConfig describes graceful shutdown behaviour
+type ShutdownConfig struct {
+	ReadyDelay   Duration `yaml:"ready_delay"`
+	DrainTimeout Duration `yaml:"drain_timeout"`
+}
+
+// WebSocketConfig describes WebSocket relaying
+type WebSocketConfig struct {
+	LogFrames bool `yaml:"log_frames"`
+}
+
+// MetricsConfig describes the Prometheus /metrics endpoint
+type MetricsConfig struct {
+	// MaxHosts caps distinct host label values; later hosts are counted as
+	// "other" and 0 disables per-host metrics
+	MaxHosts int `yaml:"max_hosts"`
+}
+
+// TracingConfig describes OpenTelemetry span export
+type TracingConfig struct {
+	// Endpoint is the OTLP/HTTP collector, e.g. http://otel-collector:4318;
+	// empty disables tracing
+	Endpoint    string  `yaml:"endpoint"`
+	ServiceName string  `yaml:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio"` // of new traces; incoming traceparent flags win
+}
+
+// AccessLogConfig describes the access log format and destination
+type AccessLogConfig struct {
+	// Format is json, squid (native access.log), combined (Apache) or
+	// custom, which renders Template
+	Format   string `yaml:"format"`
+	Template string `yaml:"template"`
+	// File is the log path; empty or "stdout" writes to standard output
+	File           string   `yaml:"file"`
+	MaxSizeMB      int      `yaml:"max_size_mb"`
+	MaxBackups     int      `yaml:"max_backups"`
+	MaxAgeDays     int      `yaml:"max_age_days"`
+	Compress       bool     `yaml:"compress"`
+	RotateInterval Duration `yaml:"rotate_interval"` // e.g. 24h rotates at midnight UTC
+}
+
+// RedactConfig describes how secrets are masked in logs, captures and
+// error messages. The lists extend the built-in rules (Authorization,
+// Cookie, token query parameters, JWTs, AWS keys and more).
+type RedactConfig struct {
+	// Mode is mask, hash (a keyed hash so values can be correlated) or off
+	Mode        string   `yaml:"mode"`
+	HashKey     string   `yaml:"hash_key"` // empty picks a random key on every start
+	Headers     []string `yaml:"headers"`
+	QueryParams []string `yaml:"query_params"`
+	Patterns    []string `yaml:"patterns"` // regexps; with a group only the group is masked
+}
+
+// FixturesConfig describes record/replay mode for offline test fixtures
+type FixturesConfig struct {
+	// Mode is off, record (store every upstream response) or replay
+	// (answer only from the store, never touching the network)
+	Mode      string `yaml:"mode"`
+	Dir       string `yaml:"dir"`
+	MatchBody bool   `yaml:"match_body"` // include the request body in the key
+	// IgnoreParams lists query parameters left out of the key, such as
+	// timestamps or cache busters
+	IgnoreParams []string `yaml:"ignore_params"`
+	MissStatus   int      `yaml:"miss_status"` // returned in replay mode when nothing was recorded
+}
+
+// Duration is a time.Duration that decodes from strings like "5m"
+type Duration time.Duration
+
+// UnmarshalYAML parses a duration string, reporting the line on failure
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	parsed, err := parseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration %q", node.Line, node.Value)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalYAML encodes the duration as a string
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+// Std returns the value as a time.Duration
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+// parseDuration parses a duration where a bare "0" means zero
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "0" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Default returns the built-in configuration
+func Default() *Config {
+	return &Config{
+		Listen: ListenConfig{
+			Proxy:           ":1488",
+			TransparentMode: "redirect",
+		},
+		Cache: CacheConfig{
+			Backend:         "memory",
+			SizeMB:          100,
+			MaxAge:          Duration(5 * time.Minute),
+			MaxObjectSizeMB: 10,
+			Admission: AdmissionConfig{
+				Policy: "tinylfu",
+			},
+			Disk: DiskCacheConfig{
+				SizeMB: 1024,
+			},
+			Redis: RedisConfig{
+				Addr:          "localhost:6379",
+				Namespace:     "4ebur",
+				CompressMinKB: 4,
+			},
+		},
+		Upstream: UpstreamConfig{
+			MaxIdleConns:        1000,
+			MaxIdleConnsPerHost: 100,
+			MaxConnsPerHost:     100,
+			IdleTimeout:         Duration(60 * time.Second),
+		},
+		Metrics: MetricsConfig{
+			MaxHosts: 100,
+		},
+		Tracing: TracingConfig{
+			ServiceName: "4ebur-net",
+			SampleRatio: 1,
+		},
+		AccessLog: AccessLogConfig{
+			Format:    "json",
+			MaxSizeMB: 100,
+		},
+		Redact: RedactConfig{
+			Mode: "mask",
+		},
+		Fixtures: FixturesConfig{
+			Mode:       "off",
+			Dir:        "fixtures",
+			MissStatus: 504,
+		},
+		Shutdown: ShutdownConfig{
+			DrainTimeout: Duration(30 * time.Second),
+		},
+	}
+}
+
+// Load builds the configuration from defaults, environment variables and,
+// if path is not empty, the config file, then validates it
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if err := cfg.ApplyEnv(); err != nil {
+		return nil, err
+	}
+
+	var lines map[string]int
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		if lines, err = cfg.decode(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if err := cfg.validate(lines); err != nil {
+		if path != "" {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Parse decodes and validates YAML config data on top of cfg
+func (c *Config) Parse(data []byte) error {
+	lines, err := c.decode(data)
+	if err != nil {
+		return err
+	}
+	return c.validate(lines)
+}
+
+// Validate checks the configuration for invalid values
+func (c *Config) Validate() error {
+	return c.validate(nil)
+}
+
+// decode strictly decodes YAML data into c and returns the line of every key
+func (c *Config) decode(data []byte) (map[string]int, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return nil, formatYAMLError(err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, formatYAMLError(err)
+	}
+
+	lines := make(map[string]int)
+	collectLines(&root, "", lines)
+	return lines, nil
+}
+
+// formatYAMLError flattens yaml.TypeError into a single message
+func formatYAMLError(err error) error {
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		return errors.New(strings.Join(typeErr.Errors, "; "))
+	}
+	return err
+}
+
+// collectLines records the line of every mapping key as "a.b[0].c"
+func collectLines(node *yaml.Node, prefix string, lines map[string]int) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			collectLines(child, prefix, lines)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			lines[key] = node.Content[i].Line
+			collectLines(node.Content[i+1], key, lines)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			key := fmt.Sprintf("%s[%d]", prefix, i)
+			lines[key] = child.Line
+			collectLines(child, key, lines)
+		}
+	}
+}
+
+// FieldError describes an invalid configuration value
+type FieldError struct {
+	Field string
+	Line  int // 0 when the value did not come from the config file
+	Msg   string
+}
+
+func (e *FieldError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", e.Line, e.Field, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Msg)
+}
+
+// ValidationErrors collects every invalid value found
+type ValidationErrors []*FieldError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// validate checks every value, attaching file lines where known
+func (c *Config) validate(lines map[string]int) error {
+	var errs ValidationErrors
+	fail := func(field, format string, args ...interface{}) {
+		errs = append(errs, &FieldError{Field: field, Line: lines[field], Msg: fmt.Sprintf(format, args...)})
+	}
+
+	if err := validateAddr(c.Listen.Proxy); err != nil {
+		fail("listen.proxy", "%v", err)
+	}
+	if c.Listen.Transparent != "" {
+		if err := validateAddr(c.Listen.Transparent); err != nil {
+			fail("listen.transparent", "%v", err)
+		}
+	}
+	if c.Listen.TransparentMode != "redirect" && c.Listen.TransparentMode != "tproxy" {
+		fail("listen.transparent_mode", "must be redirect or tproxy, got %q", c.Listen.TransparentMode)
+	}
+
+	if c.Admin.Listen != "" {
+		if path, ok := strings.CutPrefix(c.Admin.Listen, "unix:"); ok {
+			if path == "" {
+				fail("admin.listen", "unix socket path is empty")
+			}
+		} else if err := validateAddr(c.Admin.Listen); err != nil {
+			fail("admin.listen", "%v", err)
+		} else if c.Admin.Listen == c.Listen.Proxy {
+			fail("admin.listen", "must differ from listen.proxy")
+		}
+	}
+
+	if (c.Cert.CAFile == "") != (c.Cert.KeyFile == "") {
+		fail("cert.ca_file", "ca_file and key_file must be set together")
+	}
+
+	switch c.Cache.Backend {
+	case "memory":
+	case "disk":
+		if c.Cache.Disk.Dir == "" {
+			fail("cache.disk.dir", "required with the disk backend")
+		}
+	case "redis", "tiered":
+		c.Cache.Redis.validate(fail)
+	default:
+		fail("cache.backend", "must be memory, disk, redis or tiered, got %q", c.Cache.Backend)
+	}
+	if c.Cache.Disk.Dir != "" && c.Cache.Disk.SizeMB <= 0 {
+		fail("cache.disk.size_mb", "must be positive, got %d", c.Cache.Disk.SizeMB)
+	}
+	if c.Cache.SizeMB <= 0 {
+		fail("cache.size_mb", "must be positive, got %d", c.Cache.SizeMB)
+	}
+	if c.Cache.MaxAge <= 0 {
+		fail("cache.max_age", "must be positive")
+	}
+	if c.Cache.MaxObjectSizeMB < 0 {
+		fail("cache.max_object_size_mb", "must not be negative, got %d", c.Cache.MaxObjectSizeMB)
+	}
+	if p := c.Cache.Admission.Policy; p != "tinylfu" && p != "none" {
+		fail("cache.admission.policy", "must be tinylfu or none, got %q", p)
+	}
+	if c.Cache.Admission.MaxObjectSizeKB < 0 {
+		fail("cache.admission.max_object_size_kb", "must not be negative, got %d", c.Cache.Admission.MaxObjectSizeKB)
+	}
+
+	if c.Upstream.ParentProxy != "" {
+		if u, err := url.Parse(c.Upstream.ParentProxy); err != nil || u.Scheme == "" || u.Host == "" {
+			fail("upstream.parent_proxy", "must be an absolute URL like http://host:port")
+		}
+	}
+	for field, value := range map[string]int{
+		"upstream.max_idle_conns":          c.Upstream.MaxIdleConns,
+		"upstream.max_idle_conns_per_host": c.Upstream.MaxIdleConnsPerHost,
+		"upstream.max_conns_per_host":      c.Upstream.MaxConnsPerHost,
+	} {
+		if value < 0 {
+			fail(field, "must not be negative, got %d", value)
+		}
+	}
+	if c.Upstream.IdleTimeout < 0 {
+		fail("upstream.idle_timeout", "must not be negative")
+	}
+	if c.Upstream.TotalTimeout < 0 {
+		fail("upstream.total_timeout", "must not be negative")
+	}
+	for i, route := range c.Upstream.Routes {
+		field := fmt.Sprintf("upstream.routes[%d]", i)
+		hostPattern, _, _ := strings.Cut(route.Pattern, "/")
+		if _, err := path.Match(hostPattern, ""); route.Pattern == "" || err != nil {
+			fail(field+".pattern", "invalid pattern %q", route.Pattern)
+		}
+		if route.IdleTimeout < 0 || route.TotalTimeout < 0 {
+			fail(field, "timeouts must not be negative")
+		}
+	}
+
+	for i, cidr := range c.ACL.AllowClients {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			fail(fmt.Sprintf("acl.allow_clients[%d]", i), "invalid CIDR %q", cidr)
+		}
+	}
+	for i, pattern := range c.ACL.DenyHosts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			fail(fmt.Sprintf("acl.deny_hosts[%d]", i), "invalid host pattern %q", pattern)
+		}
+	}
+
+	if c.Metrics.MaxHosts < 0 {
+		fail("metrics.max_hosts", "must not be negative, got %d", c.Metrics.MaxHosts)
+	}
+
+	if c.Tracing.Endpoint != "" {
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("tracing.endpoint", "must be an http(s) URL like http://otel-collector:4318")
+		}
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		fail("tracing.sample_ratio", "must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+	}
+
+	switch c.AccessLog.Format {
+	case "json", "squid", "combined":
+	case "custom":
+		if c.AccessLog.Template == "" {
+			fail("access_log.template", "required for the custom format")
+		}
+	default:
+		fail("access_log.format", "must be json, squid, combined or custom, got %q", c.AccessLog.Format)
+	}
+	for field, value := range map[string]int{
+		"access_log.max_size_mb":  c.AccessLog.MaxSizeMB,
+		"access_log.max_backups":  c.AccessLog.MaxBackups,
+		"access_log.max_age_days": c.AccessLog.MaxAgeDays,
+	} {
+		if value < 0 {
+			fail(field, "must not be negative, got %d", value)
+		}
+	}
+	if c.AccessLog.RotateInterval < 0 {
+		fail("access_log.rotate_interval", "must not be negative")
+	}
+
+	if c.Redact.Mode != "mask" && c.Redact.Mode != "hash" && c.Redact.Mode != "off" {
+		fail("redact.mode", "must be mask, hash or off, got %q", c.Redact.Mode)
+	}
+	for i, pattern := range c.Redact.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			fail(fmt.Sprintf("redact.patterns[%d]", i), "%v", err)
+		}
+	}
+
+	switch c.Fixtures.Mode {
+	case "off":
+	case "record", "replay":
+		if c.Fixtures.Dir == "" {
+			fail("fixtures.dir", "required in %s mode", c.Fixtures.Mode)
+		}
+	default:
+		fail("fixtures.mode", "must be off, record or replay, got %q", c.Fixtures.Mode)
+	}
+	if c.Fixtures.MissStatus < 100 || c.Fixtures.MissStatus > 599 {
+		fail("fixtures.miss_status", "must be an HTTP status code, got %d", c.Fixtures.MissStatus)
+	}
+
+	if c.Shutdown.ReadyDelay < 0 {
+		fail("shutdown.ready_delay", "must not be negative")
+	}
+	if c.Shutdown.DrainTimeout < 0 {
+		fail("shutdown.drain_timeout", "must not be negative")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+	return errs
+}
+
+// validate checks the Redis settings used by the redis and tiered backends
+func (r *RedisConfig) validate(fail func(field, format string, args ...interface{})) {
+	switch {
+	case r.MasterName != "" && r.Cluster:
+		fail("cache.redis.cluster", "cannot be combined with master_name")
+	case r.MasterName != "" || r.Cluster:
+		if len(r.Addrs) == 0 {
+			fail("cache.redis.addrs", "required for sentinel and cluster")
+		}
+		for _, addr := range r.Addrs {
+			if err := validateAddr(addr); err != nil {
+				fail("cache.redis.addrs", "%v", err)
+			}
+		}
+	default:
+		if err := validateAddr(r.Addr); err != nil {
+			fail("cache.redis.addr", "%v", err)
+		}
+	}
+
+	if r.DB < 0 {
+		fail("cache.redis.db", "must not be negative, got %d", r.DB)
+	} else if r.Cluster && r.DB != 0 {
+		fail("cache.redis.db", "must be 0 in cluster mode")
+	}
+	if (r.TLS.CertFile == "") != (r.TLS.KeyFile == "") {
+		fail("cache.redis.tls.cert_file", "cert_file and key_file must be set together")
+	}
+	if r.Namespace == "" || strings.ContainsAny(r.Namespace, "*?[]\\ \t\n") {
+		fail("cache.redis.namespace", "must be non-empty without spaces or glob characters, got %q", r.Namespace)
+	}
+	if r.CompressMinKB < 0 {
+		fail("cache.redis.compress_min_kb", "must not be negative, got %d", r.CompressMinKB)
+	}
+}
+
+// validateAddr checks a host:port listen address
+func validateAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %v", addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port in %q", addr)
+	}
+	return nil
+}
+
+// RestartRequired returns the names of settings that differ between c and
+// other and cannot be changed without a restart
+func (c *Config) RestartRequired(other *Config) []string {
+	var fields []string
+	if c.Listen != other.Listen {
+		fields = append(fields, "listen")
+	}
+	if c.Admin.Listen != other.Admin.Listen {
+		fields = append(fields, "admin.listen")
+	}
+	if c.Cert != other.Cert {
+		fields = append(fields, "cert")
+	}
+	if c.Cache.SizeMB != other.Cache.SizeMB {
+		fields = append(fields, "cache.size_mb")
+	}
+	if c.Cache.Admission != other.Cache.Admission {
+		fields = append(fields, "cache.admission")
+	}
+	if c.Cache.Disk != other.Cache.Disk {
+		fields = append(fields, "cache.disk")
+	}
+	if c.Cache.Backend != other.Cache.Backend || !reflect.DeepEqual(c.Cache.Redis, other.Cache.Redis) {
+		fields = append(fields, "cache backend")
+	}
+	if c.Upstream.MaxIdleConns != other.Upstream.MaxIdleConns ||
+		c.Upstream.MaxIdleConnsPerHost != other.Upstream.MaxIdleConnsPerHost ||
+		c.Upstream.MaxConnsPerHost != other.Upstream.MaxConnsPerHost {
+		fields = append(fields, "upstream connection pool")
+	}
+	if c.Tracing != other.Tracing {
+		fields = append(fields, "tracing")
+	}
+	if c.AccessLog != other.AccessLog {
+		fields = append(fields, "access_log")
+	}
+	if !reflect.DeepEqual(c.Redact, other.Redact) {
+		fields = append(fields, "redact")
+	}
+	if !reflect.DeepEqual(c.Fixtures, other.Fixtures) {
+		fields = append(fields, "fixtures")
+	}
+	return fields
+}
@@ -0,0 +1,338 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimeoutRoutes(t *testing.T) {
+	routes, err := ParseTimeoutRoutes("stream.example.com/events=5m/0; *.example.com=30s/2m")
+	if err != nil {
+		t.Fatalf("ParseTimeoutRoutes failed: %v", err)
+	}
+
+	if len(routes) != 2 {
+		t.Fatalf("Expected 2 routes, got %d", len(routes))
+	}
+	if routes[0].Pattern != "stream.example.com/events" || routes[0].IdleTimeout.Std() != 5*time.Minute || routes[0].TotalTimeout != 0 {
+		t.Errorf("Unexpected first route: %+v", routes[0])
+	}
+	if routes[1].Pattern != "*.example.com" || routes[1].TotalTimeout.Std() != 2*time.Minute {
+		t.Errorf("Unexpected second route: %+v", routes[1])
+	}
+
+	for _, spec := range []string{"example.com", "example.com=5m", "example.com=soon/0"} {
+		if _, err := ParseTimeoutRoutes(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg := Default()
+	err := cfg.Parse([]byte(`
+listen:
+  proxy: "127.0.0.1:8080"
+cache:
+  max_age: 10m
+upstream:
+  parent_proxy: http://parent:3128
+  routes:
+    - pattern: "*.example.com"
+      idle_timeout: 30s
+acl:
+  allow_clients: [10.0.0.0/8]
+  deny_hosts: ["*.ads.example"]
+`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if cfg.Listen.Proxy != "127.0.0.1:8080" {
+		t.Errorf("Expected proxy listener from file, got %q", cfg.Listen.Proxy)
+	}
+	if cfg.Cache.MaxAge.Std() != 10*time.Minute {
+		t.Errorf("Expected max_age 10m, got %v", cfg.Cache.MaxAge.Std())
+	}
+	if cfg.Cache.SizeMB != Default().Cache.SizeMB {
+		t.Errorf("Unset values should keep defaults, got size_mb %d", cfg.Cache.SizeMB)
+	}
+	if len(cfg.Upstream.Routes) != 1 || cfg.Upstream.Routes[0].IdleTimeout.Std() != 30*time.Second {
+		t.Errorf("Unexpected routes: %+v", cfg.Upstream.Routes)
+	}
+}
+
+func TestValidationErrorsIncludeLines(t *testing.T) {
+	cfg := Default()
+	err := cfg.Parse([]byte(`listen:
+  proxy: "no-port"
+cache:
+  size_mb: -1
+acl:
+  allow_clients:
+    - 10.0.0.0/8
+    - not-a-cidr
+metrics:
+  max_hosts: -5
+tracing:
+  endpoint: otel:4318
+  sample_ratio: 2
+access_log:
+  format: apache
+redact:
+  patterns: ["("]
+fixtures:
+  mode: playback
+`))
+
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) {
+		t.Fatalf("Expected ValidationErrors, got %v", err)
+	}
+
+	want := map[string]int{
+		"listen.proxy":         2,
+		"cache.size_mb":        4,
+		"acl.allow_clients[1]": 8,
+		"metrics.max_hosts":    10,
+		"tracing.endpoint":     12,
+		"tracing.sample_ratio": 13,
+		"access_log.format":    15,
+		"redact.patterns[0]":   17,
+		"fixtures.mode":        19,
+	}
+	for _, fe := range verrs {
+		if line, ok := want[fe.Field]; ok {
+			if fe.Line != line {
+				t.Errorf("%s: expected line %d, got %d", fe.Field, line, fe.Line)
+			}
+			delete(want, fe.Field)
+		}
+	}
+	for field := range want {
+		t.Errorf("Missing error for %s in:\n%v", field, err)
+	}
+}
+
+func TestValidateAdminListen(t *testing.T) {
+	tests := []struct {
+		listen string
+		valid  bool
+	}{
+		{"", true},
+		{"127.0.0.1:9090", true},
+		{"unix:/run/4ebur-net/admin.sock", true},
+		{"unix:", false},
+		{"no-port", false},
+		{":1488", false}, // same as the proxy listener
+	}
+
+	for _, tt := range tests {
+		cfg := Default()
+		cfg.Admin.Listen = tt.listen
+		if err := cfg.Validate(); (err == nil) != tt.valid {
+			t.Errorf("admin.listen %q: valid=%v, got %v", tt.listen, tt.valid, err)
+		}
+	}
+}
+
+func TestValidateRedisDeployments(t *testing.T) {
+	tests := []struct {
+		name  string
+		redis RedisConfig
+		want  string // failing field; empty when valid
+	}{
+		{"single", RedisConfig{Addr: "localhost:6379"}, ""},
+		{"sentinel", RedisConfig{MasterName: "mymaster", Addrs: []string{"s1:26379", "s2:26379"}}, ""},
+		{"cluster", RedisConfig{Cluster: true, Addrs: []string{"n1:6379"}}, ""},
+		{"sentinel without addrs", RedisConfig{Addr: "localhost:6379", MasterName: "mymaster"}, "cache.redis.addrs"},
+		{"bad sentinel addr", RedisConfig{MasterName: "mymaster", Addrs: []string{"s1"}}, "cache.redis.addrs"},
+		{"sentinel and cluster", RedisConfig{MasterName: "m", Cluster: true, Addrs: []string{"n1:6379"}}, "cache.redis.cluster"},
+		{"cluster db", RedisConfig{Cluster: true, Addrs: []string{"n1:6379"}, DB: 1}, "cache.redis.db"},
+		{"client cert without key", RedisConfig{Addr: "localhost:6379", TLS: RedisTLSConfig{CertFile: "c.pem"}}, "cache.redis.tls.cert_file"},
+		{"negative compression threshold", RedisConfig{Addr: "localhost:6379", CompressMinKB: -1}, "cache.redis.compress_min_kb"},
+		{"glob in namespace", RedisConfig{Addr: "localhost:6379", Namespace: "prod*"}, "cache.redis.namespace"},
+	}
+
+	for _, tt := range tests {
+		cfg := Default()
+		cfg.Cache.Backend = "tiered"
+		cfg.Cache.Redis = tt.redis
+		if cfg.Cache.Redis.Namespace == "" {
+			cfg.Cache.Redis.Namespace = "4ebur"
+		}
+		err := cfg.Validate()
+		if tt.want == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("%s: expected %s error, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestParseRejectsUnknownFieldsAndBadDurations(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"unknown field", "cache:\n  max_sise_mb: 5\n", "line 2"},
+		{"bad duration", "cache:\n  max_age: soon\n", "line 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Default().Parse([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error mentioning %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestLoadLayersEnvAndFile(t *testing.T) {
+	t.Setenv("CACHE_SIZE_MB", "50")
+	t.Setenv("CACHE_MAX_AGE", "1m")
+
+	path := filepath.Join(t.TempDir(), "proxy.yml")
+	if err := os.WriteFile(path, []byte("cache:\n  max_age: 2m\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if cfg.Cache.SizeMB != 50 {
+		t.Errorf("Expected size_mb from env, got %d", cfg.Cache.SizeMB)
+	}
+	if cfg.Cache.MaxAge.Std() != 2*time.Minute {
+		t.Errorf("Expected file to override env max_age, got %v", cfg.Cache.MaxAge.Std())
+	}
+}
+
+func TestLoadRedisEnabledSelectsTieredBackend(t *testing.T) {
+	t.Setenv("REDIS_ENABLED", "true")
+	t.Setenv("REDIS_ADDR", "redis-master:6379")
+	t.Setenv("REDIS_DB", "2")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Cache.Backend != "tiered" || cfg.Cache.Redis.Addr != "redis-master:6379" || cfg.Cache.Redis.DB != 2 {
+		t.Errorf("Unexpected cache config %+v", cfg.Cache)
+	}
+
+	t.Setenv("CACHE_BACKEND", "redis")
+	if cfg, err = Load(""); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Cache.Backend != "redis" {
+		t.Errorf("CACHE_BACKEND should win over REDIS_ENABLED, got %q", cfg.Cache.Backend)
+	}
+}
+
+func TestLoadRedisSentinelFromEnv(t *testing.T) {
+	t.Setenv("CACHE_BACKEND", "tiered")
+	t.Setenv("REDIS_MASTER_NAME", "mymaster")
+	t.Setenv("REDIS_ADDRS", "sentinel-1:26379, sentinel-2:26379")
+	t.Setenv("REDIS_USERNAME", "cache")
+	t.Setenv("REDIS_TLS", "true")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	redis := cfg.Cache.Redis
+	if redis.MasterName != "mymaster" || len(redis.Addrs) != 2 || redis.Addrs[1] != "sentinel-2:26379" ||
+		redis.Username != "cache" || !redis.TLS.Enabled {
+		t.Errorf("Unexpected redis config %+v", redis)
+	}
+
+	t.Setenv("REDIS_CLUSTER", "maybe")
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "REDIS_CLUSTER") {
+		t.Errorf("Expected REDIS_CLUSTER error, got %v", err)
+	}
+}
+
+func TestLoadRejectsMalformedEnv(t *testing.T) {
+	t.Setenv("CACHE_SIZE_MB", "lots")
+
+	_, err := Load("")
+	if err == nil || !strings.Contains(err.Error(), "CACHE_SIZE_MB") {
+		t.Errorf("Expected CACHE_SIZE_MB error, got %v", err)
+	}
+}
+
+func TestLoadCacheAdmission(t *testing.T) {
+	t.Setenv("CACHE_ADMISSION", "none")
+	t.Setenv("CACHE_ADMISSION_MAX_OBJECT_KB", "512")
+	t.Setenv("CACHE_EVICT_LARGE_FIRST", "true")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	want := AdmissionConfig{Policy: "none", MaxObjectSizeKB: 512, EvictLargeFirst: true}
+	if cfg.Cache.Admission != want {
+		t.Errorf("Expected %+v, got %+v", want, cfg.Cache.Admission)
+	}
+
+	t.Setenv("CACHE_ADMISSION", "lfu")
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "cache.admission.policy") {
+		t.Errorf("Expected an admission policy error, got %v", err)
+	}
+}
+
+func TestLoadCacheDisk(t *testing.T) {
+	t.Setenv("CACHE_BACKEND", "disk")
+	t.Setenv("CACHE_DISK_DIR", "/var/cache/4ebur-net")
+	t.Setenv("CACHE_DISK_SIZE_MB", "2048")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	want := DiskCacheConfig{Dir: "/var/cache/4ebur-net", SizeMB: 2048}
+	if cfg.Cache.Backend != "disk" || cfg.Cache.Disk != want {
+		t.Errorf("Expected disk backend with %+v, got %q %+v", want, cfg.Cache.Backend, cfg.Cache.Disk)
+	}
+
+	t.Setenv("CACHE_DISK_SIZE_MB", "0")
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "cache.disk.size_mb") {
+		t.Errorf("Expected a disk size error, got %v", err)
+	}
+
+	t.Setenv("CACHE_DISK_DIR", "")
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "cache.disk.dir") {
+		t.Errorf("Expected a disk dir error, got %v", err)
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	old := Default()
+	next := Default()
+	next.Cache.MaxAge = Duration(time.Hour)
+	if fields := old.RestartRequired(next); len(fields) != 0 {
+		t.Errorf("max_age should reload without restart, got %v", fields)
+	}
+
+	next.Listen.Proxy = ":9090"
+	fields := old.RestartRequired(next)
+	if len(fields) != 1 || fields[0] != "listen" {
+		t.Errorf("Expected listen to require restart, got %v", fields)
+	}
+
+	next = Default()
+	next.Cache.Admission.Policy = "none"
+	if fields := old.RestartRequired(next); len(fields) != 1 || fields[0] != "cache.admission" {
+		t.Errorf("Expected cache.admission to require restart, got %v", fields)
+	}
+}
@@ -0,0 +1,235 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ApplyEnv overrides c with values from environment variables. Unlike the
+// old getEnv helpers a malformed value is an error, not a silent default.
+func (c *Config) ApplyEnv() error {
+	var errs ValidationErrors
+	fail := func(key, value, want string) {
+		errs = append(errs, &FieldError{Field: key, Msg: fmt.Sprintf("invalid %s %q", want, value)})
+	}
+
+	if port := os.Getenv("PROXY_PORT"); port != "" {
+		c.Listen.Proxy = ":" + port
+	}
+	if port := os.Getenv("TRANSPARENT_PORT"); port != "" {
+		c.Listen.Transparent = ":" + port
+	}
+	getEnvString("TRANSPARENT_MODE", &c.Listen.TransparentMode)
+
+	getEnvString("ADMIN_LISTEN", &c.Admin.Listen)
+	getEnvString("ADMIN_TOKEN", &c.Admin.Token)
+
+	getEnvString("CA_CERT_FILE", &c.Cert.CAFile)
+	getEnvString("CA_KEY_FILE", &c.Cert.KeyFile)
+
+	getEnvInt64("CACHE_SIZE_MB", &c.Cache.SizeMB, fail)
+	getEnvDuration("CACHE_MAX_AGE", &c.Cache.MaxAge, fail)
+	getEnvInt64("CACHE_MAX_OBJECT_SIZE_MB", &c.Cache.MaxObjectSizeMB, fail)
+	getEnvString("CACHE_ADMISSION", &c.Cache.Admission.Policy)
+	getEnvInt64("CACHE_ADMISSION_MAX_OBJECT_KB", &c.Cache.Admission.MaxObjectSizeKB, fail)
+	getEnvBool("CACHE_EVICT_LARGE_FIRST", &c.Cache.Admission.EvictLargeFirst, fail)
+	getEnvString("CACHE_DISK_DIR", &c.Cache.Disk.Dir)
+	getEnvInt64("CACHE_DISK_SIZE_MB", &c.Cache.Disk.SizeMB, fail)
+	// REDIS_ENABLED predates CACHE_BACKEND and means tiered
+	if value := os.Getenv("REDIS_ENABLED"); value != "" {
+		if b, err := strconv.ParseBool(value); err != nil {
+			fail("REDIS_ENABLED", value, "boolean")
+		} else if b {
+			c.Cache.Backend = "tiered"
+		}
+	}
+	getEnvString("CACHE_BACKEND", &c.Cache.Backend)
+	getEnvString("REDIS_ADDR", &c.Cache.Redis.Addr)
+	getEnvList("REDIS_ADDRS", &c.Cache.Redis.Addrs)
+	getEnvString("REDIS_MASTER_NAME", &c.Cache.Redis.MasterName)
+	getEnvBool("REDIS_CLUSTER", &c.Cache.Redis.Cluster, fail)
+	getEnvString("REDIS_USERNAME", &c.Cache.Redis.Username)
+	getEnvString("REDIS_PASSWORD", &c.Cache.Redis.Password)
+	getEnvString("REDIS_SENTINEL_PASSWORD", &c.Cache.Redis.SentinelPassword)
+	getEnvInt("REDIS_DB", &c.Cache.Redis.DB, fail)
+	getEnvString("REDIS_NAMESPACE", &c.Cache.Redis.Namespace)
+	getEnvBool("REDIS_TLS", &c.Cache.Redis.TLS.Enabled, fail)
+	getEnvString("REDIS_TLS_CA_FILE", &c.Cache.Redis.TLS.CAFile)
+	getEnvString("REDIS_TLS_CERT_FILE", &c.Cache.Redis.TLS.CertFile)
+	getEnvString("REDIS_TLS_KEY_FILE", &c.Cache.Redis.TLS.KeyFile)
+	getEnvString("REDIS_TLS_SERVER_NAME", &c.Cache.Redis.TLS.ServerName)
+	getEnvBool("REDIS_TLS_INSECURE_SKIP_VERIFY", &c.Cache.Redis.TLS.InsecureSkipVerify, fail)
+	getEnvInt("REDIS_COMPRESS_MIN_KB", &c.Cache.Redis.CompressMinKB, fail)
+
+	getEnvString("PARENT_PROXY", &c.Upstream.ParentProxy)
+	getEnvInt("MAX_IDLE_CONNS", &c.Upstream.MaxIdleConns, fail)
+	getEnvInt("MAX_IDLE_CONNS_PER_HOST", &c.Upstream.MaxIdleConnsPerHost, fail)
+	getEnvInt("MAX_CONNS_PER_HOST", &c.Upstream.MaxConnsPerHost, fail)
+	getEnvDuration("RESPONSE_IDLE_TIMEOUT", &c.Upstream.IdleTimeout, fail)
+	getEnvDuration("RESPONSE_TOTAL_TIMEOUT", &c.Upstream.TotalTimeout, fail)
+	if spec := os.Getenv("TIMEOUT_ROUTES"); spec != "" {
+		routes, err := ParseTimeoutRoutes(spec)
+		if err != nil {
+			errs = append(errs, &FieldError{Field: "TIMEOUT_ROUTES", Msg: err.Error()})
+		} else {
+			c.Upstream.Routes = routes
+		}
+	}
+
+	getEnvList("ACL_ALLOW_CLIENTS", &c.ACL.AllowClients)
+	getEnvList("ACL_DENY_HOSTS", &c.ACL.DenyHosts)
+
+	getEnvDuration("SHUTDOWN_READY_DELAY", &c.Shutdown.ReadyDelay, fail)
+	getEnvDuration("SHUTDOWN_DRAIN_TIMEOUT", &c.Shutdown.DrainTimeout, fail)
+
+	getEnvBool("WEBSOCKET_LOG_FRAMES", &c.WebSocket.LogFrames, fail)
+
+	getEnvInt("METRICS_MAX_HOSTS", &c.Metrics.MaxHosts, fail)
+
+	// Standard OpenTelemetry SDK variable names
+	getEnvString("OTEL_EXPORTER_OTLP_ENDPOINT", &c.Tracing.Endpoint)
+	getEnvString("OTEL_SERVICE_NAME", &c.Tracing.ServiceName)
+	if value := os.Getenv("TRACING_SAMPLE_RATIO"); value != "" {
+		if ratio, err := strconv.ParseFloat(value, 64); err != nil {
+			fail("TRACING_SAMPLE_RATIO", value, "number")
+		} else {
+			c.Tracing.SampleRatio = ratio
+		}
+	}
+
+	getEnvString("ACCESS_LOG_FORMAT", &c.AccessLog.Format)
+	getEnvString("ACCESS_LOG_TEMPLATE", &c.AccessLog.Template)
+	getEnvString("ACCESS_LOG_FILE", &c.AccessLog.File)
+	getEnvInt("ACCESS_LOG_MAX_SIZE_MB", &c.AccessLog.MaxSizeMB, fail)
+	getEnvInt("ACCESS_LOG_MAX_BACKUPS", &c.AccessLog.MaxBackups, fail)
+	getEnvInt("ACCESS_LOG_MAX_AGE_DAYS", &c.AccessLog.MaxAgeDays, fail)
+	getEnvBool("ACCESS_LOG_COMPRESS", &c.AccessLog.Compress, fail)
+	getEnvDuration("ACCESS_LOG_ROTATE_INTERVAL", &c.AccessLog.RotateInterval, fail)
+
+	getEnvString("REDACT_MODE", &c.Redact.Mode)
+	getEnvString("REDACT_HASH_KEY", &c.Redact.HashKey)
+	getEnvList("REDACT_HEADERS", &c.Redact.Headers)
+	getEnvList("REDACT_QUERY_PARAMS", &c.Redact.QueryParams)
+
+	getEnvString("FIXTURES_MODE", &c.Fixtures.Mode)
+	getEnvString("FIXTURES_DIR", &c.Fixtures.Dir)
+	getEnvBool("FIXTURES_MATCH_BODY", &c.Fixtures.MatchBody, fail)
+	getEnvList("FIXTURES_IGNORE_PARAMS", &c.Fixtures.IgnoreParams)
+	getEnvInt("FIXTURES_MISS_STATUS", &c.Fixtures.MissStatus, fail)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ParseTimeoutRoutes parses "pattern=idle/total;..." as used by
+// TIMEOUT_ROUTES, e.g. "stream.example.com/events=5m/0;*.example.com=30s/2m"
+func ParseTimeoutRoutes(spec string) ([]TimeoutRoute, error) {
+	var routes []TimeoutRoute
+
+	for _, item := range strings.Split(spec, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		pattern, limits, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("timeout rule %q: expected pattern=idle/total", item)
+		}
+		idleStr, totalStr, ok := strings.Cut(limits, "/")
+		if !ok {
+			return nil, fmt.Errorf("timeout rule %q: expected idle/total", item)
+		}
+
+		idle, err := parseDuration(idleStr)
+		if err != nil {
+			return nil, fmt.Errorf("timeout rule %q: invalid idle timeout: %w", item, err)
+		}
+		total, err := parseDuration(totalStr)
+		if err != nil {
+			return nil, fmt.Errorf("timeout rule %q: invalid total timeout: %w", item, err)
+		}
+
+		routes = append(routes, TimeoutRoute{
+			Pattern:      strings.TrimSpace(pattern),
+			IdleTimeout:  Duration(idle),
+			TotalTimeout: Duration(total),
+		})
+	}
+
+	return routes, nil
+}
+
+// getEnvString sets *dst from environment variable if present
+func getEnvString(key string, dst *string) {
+	if value := os.Getenv(key); value != "" {
+		*dst = value
+	}
+}
+
+// getEnvList sets *dst from a comma-separated environment variable
+func getEnvList(key string, dst *[]string) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*dst = items
+}
+
+// getEnvInt sets *dst from an integer environment variable
+func getEnvInt(key string, dst *int, fail func(key, value, want string)) {
+	if value := os.Getenv(key); value != "" {
+		intValue, err := strconv.Atoi(value)
+		if err != nil {
+			fail(key, value, "integer")
+			return
+		}
+		*dst = intValue
+	}
+}
+
+// getEnvInt64 sets *dst from an int64 environment variable
+func getEnvInt64(key string, dst *int64, fail func(key, value, want string)) {
+	if value := os.Getenv(key); value != "" {
+		intValue, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			fail(key, value, "integer")
+			return
+		}
+		*dst = intValue
+	}
+}
+
+// getEnvBool sets *dst from a boolean environment variable
+func getEnvBool(key string, dst *bool, fail func(key, value, want string)) {
+	if value := os.Getenv(key); value != "" {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			fail(key, value, "boolean")
+			return
+		}
+		*dst = b
+	}
+}
+
+// getEnvDuration sets *dst from a duration environment variable
+func getEnvDuration(key string, dst *Duration, fail func(key, value, want string)) {
+	if value := os.Getenv(key); value != "" {
+		duration, err := parseDuration(value)
+		if err != nil {
+			fail(key, value, "duration")
+			return
+		}
+		*dst = Duration(duration)
+	}
+}
@@ -0,0 +1,561 @@
+package har
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/onixus/4ebur-net/internal/logger"
+)
+
+const (
+	// DefaultMaxBodyBytes caps each captured request and response body
+	DefaultMaxBodyBytes = 1 << 20
+	// DefaultMaxEntries caps the entries kept by one capture
+	DefaultMaxEntries = 10000
+)
+
+// Filter selects the exchanges a capture records. Empty lists match
+// everything; within a list any item may match.
+type Filter struct {
+	Hosts        []string `json:"hosts,omitempty"`         // globs, e.g. *.example.com
+	Paths        []string `json:"paths,omitempty"`         // globs; a trailing ** matches any suffix
+	Clients      []string `json:"clients,omitempty"`       // client IPs or CIDRs
+	ContentTypes []string `json:"content_types,omitempty"` // response media types, e.g. application/json or image/*
+
+	clientNets []*net.IPNet
+}
+
+// compile validates the patterns and parses client networks
+func (f *Filter) compile() error {
+	for _, pattern := range append(append([]string(nil), f.Hosts...), f.Paths...) {
+		if _, err := path.Match(strings.TrimSuffix(pattern, "**"), ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	for _, pattern := range f.ContentTypes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid content type pattern %q", pattern)
+		}
+	}
+
+	f.clientNets = nil
+	for _, client := range f.Clients {
+		if !strings.Contains(client, "/") {
+			if ip := net.ParseIP(client); ip != nil && ip.To4() != nil {
+				client += "/32"
+			} else {
+				client += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(client)
+		if err != nil {
+			return fmt.Errorf("invalid client %q", client)
+		}
+		f.clientNets = append(f.clientNets, network)
+	}
+	return nil
+}
+
+// matchRequest checks everything known before the response arrives
+func (f *Filter) matchRequest(host, urlPath, client string) bool {
+	if len(f.Hosts) > 0 && !matchAny(f.Hosts, host, path.Match) {
+		return false
+	}
+	if len(f.Paths) > 0 && !matchAny(f.Paths, urlPath, matchPath) {
+		return false
+	}
+	if len(f.clientNets) > 0 {
+		ip := net.ParseIP(client)
+		found := false
+		for _, network := range f.clientNets {
+			if ip != nil && network.Contains(ip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// matchContentType checks the response media type
+func (f *Filter) matchContentType(contentType string) bool {
+	if len(f.ContentTypes) == 0 {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return matchAny(f.ContentTypes, mediaType, path.Match)
+}
+
+func matchAny(patterns []string, value string, match func(pattern, value string) (bool, error)) bool {
+	for _, pattern := range patterns {
+		if ok, _ := match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath is path.Match where a trailing "**" matches any suffix,
+// including further segments
+func matchPath(pattern, urlPath string) (bool, error) {
+	prefix, ok := strings.CutSuffix(pattern, "**")
+	if !ok {
+		return path.Match(pattern, urlPath)
+	}
+	if !strings.ContainsAny(prefix, `*?[\`) {
+		return strings.HasPrefix(urlPath, prefix), nil
+	}
+	// Match the globbed prefix against the same number of segments
+	n := strings.Count(prefix, "/")
+	parts := strings.SplitAfter(urlPath, "/")
+	if len(parts) < n {
+		return false, nil
+	}
+	return path.Match(prefix, strings.Join(parts[:n], ""))
+}
+
+// Options describe a new capture
+type Options struct {
+	Filter       Filter
+	MaxBodyBytes int64 // per body; 0 uses DefaultMaxBodyBytes, <0 records no bodies
+	MaxEntries   int   // 0 uses DefaultMaxEntries
+}
+
+// Capture collects matching exchanges until it is stopped
+type Capture struct {
+	id      string
+	opts    Options
+	started time.Time
+
+	mu      sync.Mutex
+	stopped time.Time
+	entries []Entry
+	dropped int
+}
+
+// Info summarizes a capture for the admin API
+type Info struct {
+	ID           string     `json:"id"`
+	Filter       Filter     `json:"filter"`
+	MaxBodyBytes int64      `json:"max_body_bytes"`
+	MaxEntries   int        `json:"max_entries"`
+	Started      time.Time  `json:"started"`
+	Stopped      *time.Time `json:"stopped,omitempty"`
+	Entries      int        `json:"entries"`
+	Dropped      int        `json:"dropped"` // exchanges beyond max_entries
+}
+
+// Info returns the current state of c
+func (c *Capture) Info() Info {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info := Info{
+		ID:           c.id,
+		Filter:       c.opts.Filter,
+		MaxBodyBytes: c.opts.MaxBodyBytes,
+		MaxEntries:   c.opts.MaxEntries,
+		Started:      c.started,
+		Entries:      len(c.entries),
+		Dropped:      c.dropped,
+	}
+	if !c.stopped.IsZero() {
+		stopped := c.stopped
+		info.Stopped = &stopped
+	}
+	return info
+}
+
+// HAR returns the entries recorded so far as a HAR document
+func (c *Capture) HAR() *File {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f := &File{Log: Log{
+		Version: "1.2",
+		Creator: Creator{Name: "4ebur-net", Version: "1.0"},
+		Entries: append([]Entry{}, c.entries...),
+	}}
+	if c.dropped > 0 {
+		f.Log.Comment = fmt.Sprintf("%d exchanges dropped after max_entries", c.dropped)
+	}
+	return f
+}
+
+func (c *Capture) add(e Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.stopped.IsZero() {
+		return
+	}
+	if len(c.entries) >= c.opts.MaxEntries {
+		c.dropped++
+		return
+	}
+	c.entries = append(c.entries, e)
+}
+
+func (c *Capture) active() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stopped.IsZero()
+}
+
+// Recorder holds the captures of a proxy. A nil *Recorder records nothing.
+type Recorder struct {
+	redactor *logger.Redactor
+
+	mu       sync.RWMutex
+	captures map[string]*Capture
+	running  int // captures not yet stopped, checked on every request
+}
+
+// NewRecorder creates a recorder that passes headers, query strings and
+// text bodies through redactor
+func NewRecorder(redactor *logger.Redactor) *Recorder {
+	return &Recorder{redactor: redactor, captures: make(map[string]*Capture)}
+}
+
+// Start begins a capture
+func (r *Recorder) Start(opts Options) (*Capture, error) {
+	if err := opts.Filter.compile(); err != nil {
+		return nil, err
+	}
+	if opts.MaxBodyBytes == 0 {
+		opts.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultMaxEntries
+	}
+
+	var id [6]byte
+	rand.Read(id[:])
+	c := &Capture{id: hex.EncodeToString(id[:]), opts: opts, started: time.Now()}
+
+	r.mu.Lock()
+	r.captures[c.id] = c
+	r.running++
+	r.mu.Unlock()
+	return c, nil
+}
+
+// Stop ends a capture; its entries stay available until Delete
+func (r *Recorder) Stop(id string) (*Capture, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.captures[id]
+	if !ok {
+		return nil, false
+	}
+	c.mu.Lock()
+	if c.stopped.IsZero() {
+		c.stopped = time.Now()
+		r.running--
+	}
+	c.mu.Unlock()
+	return c, true
+}
+
+// Delete stops a capture and discards its entries
+func (r *Recorder) Delete(id string) bool {
+	if _, ok := r.Stop(id); !ok {
+		return false
+	}
+	r.mu.Lock()
+	delete(r.captures, id)
+	r.mu.Unlock()
+	return true
+}
+
+// Get returns a capture by ID
+func (r *Recorder) Get(id string) (*Capture, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.captures[id]
+	return c, ok
+}
+
+// List returns all captures, oldest first
+func (r *Recorder) List() []*Capture {
+	r.mu.RLock()
+	list := make([]*Capture, 0, len(r.captures))
+	for _, c := range r.captures {
+		list = append(list, c)
+	}
+	r.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].started.Before(list[j].started) })
+	return list
+}
+
+// Exchange collects one request/response pair for the captures whose
+// request filters matched. A nil *Exchange records nothing.
+type Exchange struct {
+	recorder *Recorder
+	captures []*Capture
+	maxBody  int64
+	started  time.Time
+
+	req     *http.Request // headers and URL only; the body is in reqBody
+	reqBody *limitedBuffer
+
+	status     int
+	statusLine string
+	proto      string
+	respHeader http.Header
+	respBody   *limitedBuffer
+	cached     bool
+}
+
+// Begin starts an exchange for req from client (host:port); it returns
+// nil unless a running capture matches. The request body is wrapped so
+// that it is recorded as it is forwarded.
+func (r *Recorder) Begin(req *http.Request, client string) *Exchange {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	if r.running == 0 {
+		r.mu.RUnlock()
+		return nil
+	}
+	candidates := make([]*Capture, 0, len(r.captures))
+	for _, c := range r.captures {
+		candidates = append(candidates, c)
+	}
+	r.mu.RUnlock()
+
+	clientIP := client
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		clientIP = host
+	}
+
+	x := &Exchange{recorder: r, started: time.Now()}
+	for _, c := range candidates {
+		if c.active() && c.opts.Filter.matchRequest(req.URL.Hostname(), req.URL.Path, clientIP) {
+			x.captures = append(x.captures, c)
+			if c.opts.MaxBodyBytes > x.maxBody {
+				x.maxBody = c.opts.MaxBodyBytes
+			}
+		}
+	}
+	if len(x.captures) == 0 {
+		return nil
+	}
+
+	x.req = &http.Request{
+		Method: req.Method,
+		URL:    req.URL,
+		Proto:  req.Proto,
+		Header: req.Header.Clone(),
+	}
+	if x.maxBody > 0 && req.Body != nil && req.Body != http.NoBody {
+		x.reqBody = &limitedBuffer{max: x.maxBody}
+		req.Body = &teeBody{ReadCloser: req.Body, buf: x.reqBody}
+	}
+	return x
+}
+
+// Response records the response headers and wraps resp.Body to record
+// it; captures whose content type filter does not match are dropped
+func (x *Exchange) Response(resp *http.Response) {
+	if x == nil {
+		return
+	}
+	x.status, x.statusLine, x.proto = resp.StatusCode, resp.Status, resp.Proto
+	x.respHeader = resp.Header.Clone()
+	if !x.filterContentType() {
+		return
+	}
+	// A 101 body is the upgraded connection; leave it alone
+	if x.maxBody > 0 && resp.StatusCode != http.StatusSwitchingProtocols {
+		x.respBody = &limitedBuffer{max: x.maxBody}
+		resp.Body = &teeBody{ReadCloser: resp.Body, buf: x.respBody}
+	}
+}
+
+// Cached records a response served from the cache
+func (x *Exchange) Cached(status int, header http.Header, body []byte) {
+	if x == nil {
+		return
+	}
+	x.status, x.proto, x.cached = status, "HTTP/1.1", true
+	x.respHeader = header.Clone()
+	if !x.filterContentType() {
+		return
+	}
+	if x.maxBody > 0 {
+		x.respBody = &limitedBuffer{max: x.maxBody}
+		x.respBody.Write(body)
+	}
+}
+
+func (x *Exchange) filterContentType() bool {
+	contentType := x.respHeader.Get("Content-Type")
+	kept := x.captures[:0]
+	for _, c := range x.captures {
+		if c.opts.Filter.matchContentType(contentType) {
+			kept = append(kept, c)
+		}
+	}
+	x.captures = kept
+	return len(kept) > 0
+}
+
+// Finish adds the exchange to its captures. wait is the time until the
+// response headers arrived, total the whole exchange, bytesIn and
+// bytesOut the full body sizes and err the failure, if any.
+func (x *Exchange) Finish(wait, total time.Duration, bytesIn, bytesOut int64, tunnelID string, err error) {
+	if x == nil || len(x.captures) == 0 {
+		return
+	}
+	redactor := x.recorder.redactor
+
+	entry := Entry{
+		StartedDateTime: x.started,
+		Time:            millis(total),
+		Connection:      tunnelID,
+		Timings:         Timings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Wait: millis(wait), Receive: millis(total - wait)},
+		Request: Request{
+			Method:      x.req.Method,
+			URL:         redactor.String(x.req.URL.String()),
+			HTTPVersion: httpVersion(x.req.Proto),
+			Cookies:     cookieList(x.req.Cookies(), redactor, "Cookie"),
+			Headers:     headerList(x.req.Header, redactor),
+			QueryString: queryList(x.req.URL, redactor),
+			HeadersSize: headersSize(x.req.Header),
+			BodySize:    bytesIn,
+		},
+		Response: Response{
+			Status:      x.status,
+			StatusText:  statusText(x.statusLine, x.status),
+			HTTPVersion: httpVersion(x.proto),
+			Cookies:     []NameValue{},
+			Headers:     []NameValue{},
+			HeadersSize: -1,
+			BodySize:    bytesOut,
+			Content:     Content{Size: bytesOut},
+		},
+	}
+	if wait <= 0 {
+		entry.Timings.Wait, entry.Timings.Receive = 0, millis(total)
+	}
+
+	if x.reqBody != nil {
+		text, _ := bodyText(x.reqBody.Bytes(), redactor)
+		entry.Request.PostData = &PostData{MimeType: x.req.Header.Get("Content-Type"), Text: text}
+		if x.reqBody.truncated {
+			entry.Request.PostData.Comment = fmt.Sprintf("truncated to %d bytes", x.reqBody.max)
+		}
+	}
+
+	if x.respHeader != nil {
+		resp := &http.Response{Header: x.respHeader}
+		entry.Response.Cookies = cookieList(resp.Cookies(), redactor, "Set-Cookie")
+		entry.Response.Headers = headerList(x.respHeader, redactor)
+		entry.Response.HeadersSize = headersSize(x.respHeader)
+		entry.Response.RedirectURL = redactor.String(x.respHeader.Get("Location"))
+		entry.Response.Content.MimeType = x.respHeader.Get("Content-Type")
+	}
+	if x.respBody != nil {
+		entry.Response.Content.Text, entry.Response.Content.Encoding = bodyText(x.respBody.Bytes(), redactor)
+		if x.respBody.truncated {
+			entry.Response.Content.Comment = fmt.Sprintf("truncated to %d bytes", x.respBody.max)
+		}
+	}
+	if x.cached {
+		entry.Comment = "served from cache"
+	}
+	if err != nil {
+		entry.Response.Comment = redactor.Error(err)
+	}
+
+	for _, c := range x.captures {
+		e := entry
+		if c.opts.MaxBodyBytes < x.maxBody {
+			e = trimBodies(e, c.opts.MaxBodyBytes)
+		}
+		c.add(e)
+	}
+}
+
+// trimBodies applies a smaller body cap for one capture
+func trimBodies(e Entry, max int64) Entry {
+	if max < 0 {
+		e.Request.PostData = nil
+		e.Response.Content.Text, e.Response.Content.Encoding, e.Response.Content.Comment = "", "", "body not recorded"
+		return e
+	}
+	if e.Request.PostData != nil && int64(len(e.Request.PostData.Text)) > max {
+		pd := *e.Request.PostData
+		pd.Text, pd.Comment = strings.ToValidUTF8(pd.Text[:max], ""), fmt.Sprintf("truncated to %d bytes", max)
+		e.Request.PostData = &pd
+	}
+	if e.Response.Content.Encoding == "" && int64(len(e.Response.Content.Text)) > max {
+		e.Response.Content.Text = strings.ToValidUTF8(e.Response.Content.Text[:max], "")
+		e.Response.Content.Comment = fmt.Sprintf("truncated to %d bytes", max)
+	}
+	return e
+}
+
+// limitedBuffer keeps the first max bytes written to it
+type limitedBuffer struct {
+	mu        sync.Mutex
+	buf       []byte
+	max       int64
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.max - int64(len(b.buf)); room < int64(len(p)) {
+		b.truncated = true
+		if room > 0 {
+			b.buf = append(b.buf, p[:room]...)
+		}
+		return len(p), nil
+	}
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+func (b *limitedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf
+}
+
+// teeBody copies what is read from a body into buf
+type teeBody struct {
+	io.ReadCloser
+	buf *limitedBuffer
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		t.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
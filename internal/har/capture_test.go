@@ -0,0 +1,176 @@
+package har
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/onixus/4ebur-net/internal/logger"
+)
+
+func TestFilterMatchRequest(t *testing.T) {
+	f := Filter{
+		Hosts:   []string{"*.example.com"},
+		Paths:   []string{"/api/**", "/login"},
+		Clients: []string{"10.0.0.0/8", "192.168.1.5"},
+	}
+	if err := f.compile(); err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+
+	tests := []struct {
+		host, path, client string
+		want               bool
+	}{
+		{"api.example.com", "/api/v1/users", "10.1.2.3", true},
+		{"api.example.com", "/login", "192.168.1.5", true},
+		{"example.com", "/api/v1", "10.1.2.3", false},
+		{"api.example.com", "/static/app.js", "10.1.2.3", false},
+		{"api.example.com", "/api/v1", "192.168.1.6", false},
+	}
+	for _, tt := range tests {
+		if got := f.matchRequest(tt.host, tt.path, tt.client); got != tt.want {
+			t.Errorf("matchRequest(%s, %s, %s) = %v, want %v", tt.host, tt.path, tt.client, got, tt.want)
+		}
+	}
+
+	for _, bad := range []Filter{{Hosts: []string{"["}}, {Clients: []string{"not-an-ip"}}} {
+		if err := bad.compile(); err == nil {
+			t.Errorf("Expected error compiling %+v", bad)
+		}
+	}
+}
+
+func TestMatchPathGlobPrefix(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/v*/users/**", "/v2/users/42/avatar", true},
+		{"/v*/users/**", "/v2/groups/1", false},
+		{"/static/*.js", "/static/app.js", true},
+		{"/static/*.js", "/static/js/app.js", false},
+	} {
+		if got, _ := matchPath(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFilterContentType(t *testing.T) {
+	f := Filter{ContentTypes: []string{"application/json", "image/*"}}
+	for contentType, want := range map[string]bool{
+		"application/json; charset=utf-8": true,
+		"image/png":                       true,
+		"text/html":                       false,
+		"":                                false,
+	} {
+		if got := f.matchContentType(contentType); got != want {
+			t.Errorf("matchContentType(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+}
+
+// exchange runs one request through an exchange the way the proxy does
+func exchange(t *testing.T, r *Recorder, req *http.Request, status int, contentType, body string) {
+	t.Helper()
+	x := r.Begin(req, "10.0.0.1:5000")
+	if req.Body != nil {
+		io.Copy(io.Discard, req.Body)
+	}
+	resp := &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Proto:      "HTTP/1.1",
+		Header:     http.Header{"Content-Type": {contentType}, "Set-Cookie": {"sid=abc"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+	x.Response(resp)
+	io.Copy(io.Discard, resp.Body)
+	x.Finish(20*time.Millisecond, 30*time.Millisecond, req.ContentLength, int64(len(body)), "tun1", nil)
+}
+
+func TestCaptureRecordsExchange(t *testing.T) {
+	redactor, _ := logger.NewRedactor(logger.RedactOptions{
+		Headers:     logger.DefaultRedactHeaders,
+		QueryParams: logger.DefaultRedactQueryParams,
+	})
+	r := NewRecorder(redactor)
+	c, err := r.Start(Options{Filter: Filter{ContentTypes: []string{"application/json"}}, MaxBodyBytes: 8})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "https://api.example.com/items?token=s3cr3t&page=2", strings.NewReader(`{"name":"widget"}`))
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	req.Header.Set("Content-Type", "application/json")
+	exchange(t, r, req, http.StatusCreated, "application/json", `{"id":1234567890}`)
+	exchange(t, r, httptest.NewRequest(http.MethodGet, "https://api.example.com/logo.png", nil), http.StatusOK, "image/png", "\x89PNG")
+
+	entries := c.HAR().Log.Entries
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry after the content type filter, got %d", len(entries))
+	}
+	e := entries[0]
+
+	if e.Request.URL != "https://api.example.com/items?token=[REDACTED]&page=2" {
+		t.Errorf("Unexpected URL %q", e.Request.URL)
+	}
+	for _, h := range e.Request.Headers {
+		if h.Name == "Authorization" && h.Value != logger.Redacted {
+			t.Errorf("Authorization not redacted: %q", h.Value)
+		}
+	}
+	for _, q := range e.Request.QueryString {
+		if q.Name == "token" && q.Value != logger.Redacted {
+			t.Errorf("token not redacted: %q", q.Value)
+		}
+	}
+	if e.Request.PostData == nil || e.Request.PostData.Text != `{"name":` || e.Request.PostData.Comment == "" {
+		t.Errorf("Expected a truncated request body, got %+v", e.Request.PostData)
+	}
+	if e.Response.Status != http.StatusCreated || e.Response.Content.Size != 17 || e.Response.Content.Text != `{"id":12` {
+		t.Errorf("Unexpected response %+v", e.Response)
+	}
+	if len(e.Response.Cookies) != 1 || e.Response.Cookies[0].Value != logger.Redacted {
+		t.Errorf("Set-Cookie not redacted: %+v", e.Response.Cookies)
+	}
+	if e.Timings.Wait != 20 || e.Timings.Receive != 10 || e.Time != 30 || e.Connection != "tun1" {
+		t.Errorf("Unexpected timings %+v", e)
+	}
+}
+
+func TestCaptureStopAndLimits(t *testing.T) {
+	r := NewRecorder(nil)
+	if x := r.Begin(httptest.NewRequest(http.MethodGet, "http://a.test/", nil), "10.0.0.1:1"); x != nil {
+		t.Fatal("Expected no exchange without captures")
+	}
+
+	c, _ := r.Start(Options{MaxEntries: 1, MaxBodyBytes: -1})
+	exchange(t, r, httptest.NewRequest(http.MethodGet, "http://a.test/1", nil), 200, "text/plain", "one")
+	exchange(t, r, httptest.NewRequest(http.MethodGet, "http://a.test/2", nil), 200, "text/plain", "two")
+
+	info := c.Info()
+	if info.Entries != 1 || info.Dropped != 1 {
+		t.Errorf("Expected 1 entry and 1 dropped, got %+v", info)
+	}
+	if text := c.HAR().Log.Entries[0].Response.Content.Text; text != "" {
+		t.Errorf("Expected no body with max_body_bytes < 0, got %q", text)
+	}
+
+	r.Stop(c.id)
+	if x := r.Begin(httptest.NewRequest(http.MethodGet, "http://a.test/3", nil), "10.0.0.1:1"); x != nil {
+		t.Error("Expected no exchange after stop")
+	}
+	if !r.Delete(c.id) || len(r.List()) != 0 {
+		t.Error("Delete did not remove the capture")
+	}
+
+	var nilExchange *Exchange
+	nilExchange.Response(&http.Response{})
+	nilExchange.Finish(0, 0, 0, 0, "", errors.New("ignored"))
+}
@@ -0,0 +1,143 @@
+package har
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Handler serves the capture API under /har/:
+//
+//	POST /har/start     start a capture; form fields host, path, client and
+//	                    content_type (repeatable or comma-separated),
+//	                    max_body_bytes and max_entries
+//	POST /har/stop      stop capture id
+//	POST /har/delete    stop capture id and discard its entries
+//	GET  /har/captures  list captures
+//	GET  /har/download  download capture id as a .har file
+func (r *Recorder) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/har/start", r.handleStart)
+	mux.HandleFunc("/har/stop", r.handleStop)
+	mux.HandleFunc("/har/delete", r.handleDelete)
+	mux.HandleFunc("/har/captures", r.handleList)
+	mux.HandleFunc("/har/download", r.handleDownload)
+	return mux
+}
+
+func (r *Recorder) handleStart(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	opts := Options{Filter: Filter{
+		Hosts:        formList(req, "host"),
+		Paths:        formList(req, "path"),
+		Clients:      formList(req, "client"),
+		ContentTypes: formList(req, "content_type"),
+	}}
+	if value := req.FormValue("max_body_bytes"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid max_body_bytes")
+			return
+		}
+		opts.MaxBodyBytes = n
+	}
+	if value := req.FormValue("max_entries"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid max_entries")
+			return
+		}
+		opts.MaxEntries = n
+	}
+
+	c, err := r.Start(opts)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("🎥 HAR capture %s started from %s", c.id, req.RemoteAddr)
+	writeJSON(w, c.Info())
+}
+
+func (r *Recorder) handleStop(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	c, ok := r.Stop(req.FormValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such capture")
+		return
+	}
+	info := c.Info()
+	log.Printf("⏹️  HAR capture %s stopped with %d entries", info.ID, info.Entries)
+	writeJSON(w, info)
+}
+
+func (r *Recorder) handleDelete(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	id := req.FormValue("id")
+	if !r.Delete(id) {
+		writeError(w, http.StatusNotFound, "no such capture")
+		return
+	}
+	writeJSON(w, map[string]string{"status": "deleted", "id": id})
+}
+
+func (r *Recorder) handleList(w http.ResponseWriter, _ *http.Request) {
+	infos := []Info{}
+	for _, c := range r.List() {
+		infos = append(infos, c.Info())
+	}
+	writeJSON(w, infos)
+}
+
+func (r *Recorder) handleDownload(w http.ResponseWriter, req *http.Request) {
+	c, ok := r.Get(req.FormValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such capture")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="4ebur-net-`+c.id+`.har"`)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(c.HAR())
+}
+
+// formList collects a repeatable, comma-separated form field
+func formList(req *http.Request, key string) []string {
+	var items []string
+	for _, value := range req.Form[key] {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "error", "error": msg})
+}
@@ -0,0 +1,79 @@
+package har
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHandlerLifecycle(t *testing.T) {
+	r := NewRecorder(nil)
+	h := r.Handler()
+
+	do := func(method, target string, form url.Values) *httptest.ResponseRecorder {
+		var req *http.Request
+		if form != nil {
+			req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest(method, target, nil)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/har/start", url.Values{"host": {"a.test, *.b.test"}, "max_entries": {"5"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("start: %d %s", w.Code, w.Body)
+	}
+	var info Info
+	json.Unmarshal(w.Body.Bytes(), &info)
+	if info.ID == "" || len(info.Filter.Hosts) != 2 || info.MaxEntries != 5 {
+		t.Fatalf("Unexpected capture %+v", info)
+	}
+
+	exchange(t, r, httptest.NewRequest(http.MethodGet, "http://x.b.test/", nil), 200, "text/plain", "hi")
+
+	w = do(http.MethodGet, "/har/download?id="+info.ID, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Disposition"), info.ID+".har") {
+		t.Fatalf("download: %d %v", w.Code, w.Header())
+	}
+	var file File
+	if err := json.Unmarshal(w.Body.Bytes(), &file); err != nil {
+		t.Fatalf("Invalid HAR: %v", err)
+	}
+	if file.Log.Version != "1.2" || len(file.Log.Entries) != 1 || file.Log.Entries[0].Response.Content.Text != "hi" {
+		t.Errorf("Unexpected HAR %+v", file.Log)
+	}
+
+	if w = do(http.MethodPost, "/har/stop", url.Values{"id": {info.ID}}); w.Code != http.StatusOK {
+		t.Errorf("stop: %d %s", w.Code, w.Body)
+	}
+	w = do(http.MethodGet, "/har/captures", nil)
+	var list []Info
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list) != 1 || list[0].Stopped == nil || list[0].Entries != 1 {
+		t.Errorf("Unexpected list %s", w.Body)
+	}
+
+	for _, tt := range []struct {
+		method, target string
+		form           url.Values
+		want           int
+	}{
+		{http.MethodGet, "/har/start", nil, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/har/start", url.Values{"client": {"nope"}}, http.StatusBadRequest},
+		{http.MethodPost, "/har/start", url.Values{"max_entries": {"-1"}}, http.StatusBadRequest},
+		{http.MethodPost, "/har/stop", url.Values{"id": {"missing"}}, http.StatusNotFound},
+		{http.MethodGet, "/har/download?id=missing", nil, http.StatusNotFound},
+		{http.MethodPost, "/har/delete", url.Values{"id": {info.ID}}, http.StatusOK},
+	} {
+		if w := do(tt.method, tt.target, tt.form); w.Code != tt.want {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.target, tt.want, w.Code)
+		}
+	}
+}
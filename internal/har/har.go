@@ -0,0 +1,180 @@
+// Package har records decrypted proxy traffic as HTTP Archive (HAR 1.2)
+// files. Captures are started with a filter and collect every matching
+// request/response pair until they are stopped.
+package har
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/onixus/4ebur-net/internal/logger"
+)
+
+// Log is the top-level HAR object
+type Log struct {
+	Version string  `json:"version"`
+	Creator Creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+	Comment string  `json:"comment,omitempty"`
+}
+
+// File is a complete HAR document
+type File struct {
+	Log Log `json:"log"`
+}
+
+// Creator names the application that wrote the file
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Entry is one request/response exchange
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	Time            float64   `json:"time"` // milliseconds
+	Request         Request   `json:"request"`
+	Response        Response  `json:"response"`
+	Cache           struct{}  `json:"cache"`
+	Timings         Timings   `json:"timings"`
+	Connection      string    `json:"connection,omitempty"` // tunnel ID for HTTPS
+	Comment         string    `json:"comment,omitempty"`
+}
+
+// Request describes the request sent by the client
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+	Comment     string      `json:"comment,omitempty"`
+}
+
+// Response describes the response returned to the client
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+	Comment     string      `json:"comment,omitempty"`
+}
+
+// NameValue is a header, cookie or query parameter
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// PostData is a captured request body
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// Content is a captured response body
+type Content struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"` // "base64" for binary bodies
+	Comment  string `json:"comment,omitempty"`
+}
+
+// Timings splits Entry.Time; -1 means not applicable
+type Timings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// millis converts d to fractional milliseconds
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// headerList flattens h in a stable order, redacting secret values
+func headerList(h http.Header, r *logger.Redactor) []NameValue {
+	out := []NameValue{}
+	for _, name := range sortedKeys(h) {
+		for _, value := range h[name] {
+			out = append(out, NameValue{Name: name, Value: r.HeaderValue(name, value)})
+		}
+	}
+	return out
+}
+
+// queryList lists the query parameters of u, redacting secret values
+func queryList(u *url.URL, r *logger.Redactor) []NameValue {
+	out := []NameValue{}
+	query := u.Query()
+	for _, name := range sortedKeys(query) {
+		for _, value := range query[name] {
+			out = append(out, NameValue{Name: name, Value: r.QueryValue(name, value)})
+		}
+	}
+	return out
+}
+
+// cookieList lists cookies of a Cookie or Set-Cookie header
+func cookieList(cookies []*http.Cookie, r *logger.Redactor, header string) []NameValue {
+	out := []NameValue{}
+	for _, c := range cookies {
+		out = append(out, NameValue{Name: c.Name, Value: r.HeaderValue(header, c.Value)})
+	}
+	return out
+}
+
+// headersSize estimates the size of a header block as sent on the wire
+func headersSize(h http.Header) int {
+	size := 2 // final CRLF
+	for name, values := range h {
+		for _, value := range values {
+			size += len(name) + 2 + len(value) + 2
+		}
+	}
+	return size
+}
+
+// bodyText renders a captured body as HAR text, base64 for binary data;
+// text bodies pass through the redactor
+func bodyText(body []byte, r *logger.Redactor) (text, encoding string) {
+	if utf8.Valid(body) {
+		return r.String(string(body)), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+// httpVersion returns the protocol of a request or response for HAR
+func httpVersion(proto string) string {
+	if proto == "" {
+		return "HTTP/1.1"
+	}
+	return proto
+}
+
+// statusText returns the reason phrase of an HTTP status line
+func statusText(status string, code int) string {
+	if _, text, ok := strings.Cut(status, " "); ok {
+		return text
+	}
+	return http.StatusText(code)
+}
@@ -0,0 +1,131 @@
+package logger
+
+import (
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// TLSInfo describes the client side of an intercepted TLS connection
+type TLSInfo struct {
+	Version    string
+	Cipher     string
+	ServerName string
+	ALPN       string
+}
+
+// AccessEntry describes one proxied request
+type AccessEntry struct {
+	RequestID   string
+	TunnelID    string // CONNECT or transparent connection the request came through
+	TraceID     string
+	ClientAddr  string
+	User        string
+	Method      string
+	URL         string
+	Host        string
+	Scheme      string
+	Status      int
+	BytesIn     int64
+	BytesOut    int64
+	Duration    time.Duration
+	Upstream    time.Duration // until upstream response headers; 0 for cache hits
+	Cache       string        // "hit" or "miss"
+	ContentType string        // of the response
+	Referer     string
+	UserAgent   string
+	TLS         *TLSInfo
+	Error       string
+}
+
+// TunnelEntry describes a CONNECT or transparent TLS connection
+type TunnelEntry struct {
+	TunnelID   string
+	Kind       string // "connect" or "transparent"
+	ClientAddr string
+	User       string
+	Host       string
+	Duration   time.Duration
+	TLS        *TLSInfo
+	Error      string
+}
+
+// LogAccess writes an access log line; the level follows the status code
+func (l *Logger) LogAccess(e *AccessEntry) {
+	event := l.statusEvent(e.Status)
+
+	event.
+		Str("request_id", e.RequestID).
+		Str("client_addr", e.ClientAddr).
+		Str("method", e.Method).
+		Str("url", e.URL).
+		Str("host", e.Host).
+		Str("scheme", e.Scheme).
+		Int("status", e.Status).
+		Int64("bytes_in", e.BytesIn).
+		Int64("bytes_out", e.BytesOut).
+		Dur("duration_ms", e.Duration).
+		Str("cache", e.Cache)
+
+	if e.Upstream > 0 {
+		event.Dur("upstream_latency_ms", e.Upstream)
+	}
+	optionalStr(event, "tunnel_id", e.TunnelID)
+	optionalStr(event, "trace_id", e.TraceID)
+	optionalStr(event, "user", e.User)
+	optionalStr(event, "user_agent", e.UserAgent)
+	optionalStr(event, "error", e.Error)
+	addTLS(event, e.TLS)
+
+	event.Msg("access")
+}
+
+// LogTunnel writes a line when a tunnel closes
+func (l *Logger) LogTunnel(e *TunnelEntry) {
+	event := l.logger.Info()
+	if e.Error != "" {
+		event = l.logger.Warn()
+	}
+
+	event.
+		Str("tunnel_id", e.TunnelID).
+		Str("kind", e.Kind).
+		Str("client_addr", e.ClientAddr).
+		Str("host", e.Host).
+		Dur("duration_ms", e.Duration)
+
+	optionalStr(event, "user", e.User)
+	optionalStr(event, "error", e.Error)
+	addTLS(event, e.TLS)
+
+	event.Msg("tunnel")
+}
+
+// statusEvent picks info, warn or error for an HTTP status
+func (l *Logger) statusEvent(status int) *zerolog.Event {
+	switch {
+	case status >= 500:
+		return l.logger.Error()
+	case status >= 400:
+		return l.logger.Warn()
+	default:
+		return l.logger.Info()
+	}
+}
+
+func optionalStr(event *zerolog.Event, key, value string) {
+	if value != "" {
+		event.Str(key, value)
+	}
+}
+
+func addTLS(event *zerolog.Event, info *TLSInfo) {
+	if info == nil {
+		return
+	}
+	event.
+		Str("tls_version", info.Version).
+		Str("tls_cipher", info.Cipher)
+	optionalStr(event, "tls_sni", info.ServerName)
+	optionalStr(event, "tls_alpn", info.ALPN)
+}
@@ -0,0 +1,105 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func decodeLine(t *testing.T, buf *bytes.Buffer) map[string]interface{} {
+	t.Helper()
+	var fields map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		t.Fatalf("Failed to parse log output %q: %v", buf.String(), err)
+	}
+	buf.Reset()
+	return fields
+}
+
+func TestLogAccess(t *testing.T) {
+	t.Setenv("LOG_FORMAT", "json")
+	t.Setenv("LOG_LEVEL", "info")
+
+	var buf bytes.Buffer
+	logger := NewLoggerWithWriter(&buf)
+
+	logger.LogAccess(&AccessEntry{
+		RequestID:  "abc123",
+		TunnelID:   "tun1",
+		ClientAddr: "10.0.0.5:51234",
+		User:       "alice",
+		Method:     "GET",
+		URL:        "https://example.com/",
+		Host:       "example.com",
+		Scheme:     "https",
+		Status:     200,
+		BytesIn:    12,
+		BytesOut:   3400,
+		Duration:   80 * time.Millisecond,
+		Upstream:   50 * time.Millisecond,
+		Cache:      "miss",
+		TLS:        &TLSInfo{Version: "TLS 1.3", Cipher: "TLS_AES_128_GCM_SHA256", ServerName: "example.com"},
+	})
+
+	fields := decodeLine(t, &buf)
+	for key, want := range map[string]interface{}{
+		"message":             "access",
+		"level":               "info",
+		"request_id":          "abc123",
+		"tunnel_id":           "tun1",
+		"client_addr":         "10.0.0.5:51234",
+		"user":                "alice",
+		"host":                "example.com",
+		"status":              float64(200),
+		"bytes_in":            float64(12),
+		"bytes_out":           float64(3400),
+		"duration_ms":         float64(80),
+		"upstream_latency_ms": float64(50),
+		"cache":               "miss",
+		"tls_version":         "TLS 1.3",
+		"tls_sni":             "example.com",
+	} {
+		if fields[key] != want {
+			t.Errorf("%s: expected %v, got %v", key, want, fields[key])
+		}
+	}
+	for _, key := range []string{"trace_id", "error", "tls_alpn", "user_agent"} {
+		if _, ok := fields[key]; ok {
+			t.Errorf("Empty field %s should be omitted", key)
+		}
+	}
+
+	// Cache hits have no upstream latency; 5xx are logged as errors
+	logger.LogAccess(&AccessEntry{Status: 502, Cache: "hit", Error: "upstream refused"})
+	fields = decodeLine(t, &buf)
+	if fields["level"] != "error" || fields["error"] != "upstream refused" {
+		t.Errorf("Expected error level with message, got %v", fields)
+	}
+	if _, ok := fields["upstream_latency_ms"]; ok {
+		t.Error("upstream_latency_ms should be omitted when zero")
+	}
+}
+
+func TestLogTunnel(t *testing.T) {
+	t.Setenv("LOG_FORMAT", "json")
+
+	var buf bytes.Buffer
+	logger := NewLoggerWithWriter(&buf)
+
+	logger.LogTunnel(&TunnelEntry{
+		TunnelID:   "tun1",
+		Kind:       "connect",
+		ClientAddr: "10.0.0.5:51234",
+		Host:       "example.com:443",
+		Error:      "TLS handshake: EOF",
+	})
+
+	fields := decodeLine(t, &buf)
+	if fields["message"] != "tunnel" || fields["kind"] != "connect" || fields["level"] != "warn" {
+		t.Errorf("Unexpected tunnel log %v", fields)
+	}
+	if _, ok := fields["tls_version"]; ok {
+		t.Error("TLS fields should be omitted before the handshake completes")
+	}
+}
@@ -0,0 +1,177 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// AccessLogOptions selects the access log format and destination
+type AccessLogOptions struct {
+	Format   string // json, squid, combined or custom
+	Template string // for the custom format
+	// File is the log path; empty or "stdout" writes to standard output
+	File           string
+	MaxSizeMB      int  // rotate when the file grows past this size
+	MaxBackups     int  // rotated files to keep; 0 keeps all
+	MaxAgeDays     int  // delete rotated files older than this; 0 keeps all
+	Compress       bool // gzip rotated files
+	RotateInterval time.Duration
+	Redactor       *Redactor // applied to every line; nil logs as is
+}
+
+// AccessLog writes access and tunnel lines in a configurable format to
+// stdout or a rotating file
+type AccessLog struct {
+	format   string
+	template *Template
+	json     *Logger // for the json format
+
+	mu  sync.Mutex
+	out io.Writer
+	now func() time.Time
+
+	file *lumberjack.Logger // nil when writing to stdout
+	stop chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// NewAccessLog opens an access log as described by opts
+func NewAccessLog(opts AccessLogOptions) (*AccessLog, error) {
+	a := &AccessLog{format: opts.Format, now: time.Now}
+
+	switch opts.Format {
+	case "", FormatJSON:
+		a.format = FormatJSON
+	case FormatSquid, FormatCombined:
+	case FormatCustom:
+		tmpl, err := ParseTemplate(opts.Template)
+		if err != nil {
+			return nil, fmt.Errorf("access log template: %w", err)
+		}
+		a.template = tmpl
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", opts.Format)
+	}
+
+	if opts.File == "" || opts.File == "stdout" {
+		a.out = os.Stdout
+	} else {
+		a.file = &lumberjack.Logger{
+			Filename:   opts.File,
+			MaxSize:    opts.MaxSizeMB,
+			MaxBackups: opts.MaxBackups,
+			MaxAge:     opts.MaxAgeDays,
+			Compress:   opts.Compress,
+		}
+		a.out = a.file
+		if opts.RotateInterval > 0 {
+			a.stop = make(chan struct{})
+			a.wg.Add(1)
+			go a.rotateEvery(opts.RotateInterval)
+		}
+	}
+
+	a.out = opts.Redactor.Writer(a.out)
+
+	if a.format == FormatJSON {
+		a.json = &Logger{logger: zerolog.New(a).With().
+			Timestamp().
+			Str("service", "4ebur-net").
+			Str("version", getVersion()).
+			Logger()}
+	}
+	return a, nil
+}
+
+// Write serializes writes to the destination; zerolog uses it for the
+// json format
+func (a *AccessLog) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.out.Write(p)
+}
+
+// LogAccess writes a line for a completed request
+func (a *AccessLog) LogAccess(e *AccessEntry) {
+	if a.json != nil {
+		a.json.LogAccess(e)
+		return
+	}
+	a.writeLine(e)
+}
+
+// LogTunnel writes a line when a tunnel closes; the text formats log it
+// as a CONNECT request with result code TCP_TUNNEL
+func (a *AccessLog) LogTunnel(e *TunnelEntry) {
+	if a.json != nil {
+		a.json.LogTunnel(e)
+		return
+	}
+	a.writeLine(tunnelAccess(e))
+}
+
+func (a *AccessLog) writeLine(e *AccessEntry) {
+	at := a.now()
+	var line string
+	switch a.format {
+	case FormatSquid:
+		line = formatSquid(e, at)
+	case FormatCombined:
+		line = formatCombined(e, at)
+	default:
+		line = a.template.Format(e, at)
+	}
+	a.Write([]byte(line + "\n"))
+}
+
+// Rotate starts a new log file, keeping the old one as a backup; it does
+// nothing when logging to stdout
+func (a *AccessLog) Rotate() error {
+	if a.file == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Rotate()
+}
+
+// rotateEvery rotates at multiples of interval since the Unix epoch, so a
+// 24h interval rotates at midnight UTC
+func (a *AccessLog) rotateEvery(interval time.Duration) {
+	defer a.wg.Done()
+	for {
+		next := a.now().Truncate(interval).Add(interval)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			if err := a.Rotate(); err != nil {
+				log.Printf("✗ Access log rotation failed: %v", err)
+			}
+		case <-a.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Close stops scheduled rotation and closes the file
+func (a *AccessLog) Close() error {
+	if a.stop != nil {
+		a.once.Do(func() { close(a.stop) })
+		a.wg.Wait()
+	}
+	if a.file == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
@@ -0,0 +1,116 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sampleEntry() *AccessEntry {
+	return &AccessEntry{
+		ClientAddr:  "10.0.0.5:51234",
+		User:        "alice",
+		Method:      "GET",
+		URL:         "https://example.com/index.html",
+		Host:        "example.com",
+		Status:      200,
+		BytesOut:    3400,
+		Duration:    80 * time.Millisecond,
+		Cache:       "miss",
+		ContentType: "text/html",
+		UserAgent:   "curl/8.0",
+	}
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 0, 0, 250*int(time.Millisecond), time.UTC)
+
+	hit := sampleEntry()
+	hit.Cache = "hit"
+	replayed := sampleEntry()
+	replayed.Cache = "fixture"
+
+	tunnel := tunnelAccess(&TunnelEntry{ClientAddr: "10.0.0.5:51234", Host: "example.com:443", Duration: 2 * time.Second})
+
+	tmpl, err := ParseTemplate(`{time_unix} {client_ip} {squid_code}/{status} "{referer}" {{x}`)
+	if err != nil {
+		t.Fatalf("ParseTemplate: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"squid miss", formatSquid(sampleEntry(), at),
+			"1709294400.250     80 10.0.0.5 TCP_MISS/200 3400 GET https://example.com/index.html alice HIER_DIRECT/example.com text/html"},
+		{"squid hit", formatSquid(hit, at),
+			"1709294400.250     80 10.0.0.5 TCP_HIT/200 3400 GET https://example.com/index.html alice HIER_NONE/- text/html"},
+		{"squid fixture", formatSquid(replayed, at),
+			"1709294400.250     80 10.0.0.5 TCP_OFFLINE_HIT/200 3400 GET https://example.com/index.html alice HIER_NONE/- text/html"},
+		{"squid tunnel", formatSquid(tunnel, at),
+			"1709294400.250   2000 10.0.0.5 TCP_TUNNEL/200 0 CONNECT example.com:443 - HIER_DIRECT/example.com:443 -"},
+		{"combined", formatCombined(sampleEntry(), at),
+			`10.0.0.5 - alice [01/Mar/2024:12:00:00 +0000] "GET https://example.com/index.html HTTP/1.1" 200 3400 "-" "curl/8.0"`},
+		{"custom", tmpl.Format(sampleEntry(), at),
+			`1709294400.250 10.0.0.5 TCP_MISS/200 "-" {x}`},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestParseTemplateErrors(t *testing.T) {
+	for _, text := range []string{"", "no fields", "{nope}", "{status"} {
+		if _, err := ParseTemplate(text); err == nil {
+			t.Errorf("ParseTemplate(%q): expected error", text)
+		}
+	}
+}
+
+func TestAccessLogFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	a, err := NewAccessLog(AccessLogOptions{Format: FormatSquid, File: path, MaxSizeMB: 1})
+	if err != nil {
+		t.Fatalf("NewAccessLog: %v", err)
+	}
+
+	a.LogAccess(sampleEntry())
+	if err := a.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	a.LogTunnel(&TunnelEntry{ClientAddr: "10.0.0.5:1", Host: "example.com:443"})
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], "TCP_TUNNEL/200") {
+		t.Errorf("Expected only the tunnel line after rotation, got %q", data)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "access-*.log"))
+	if len(files) != 1 {
+		t.Fatalf("Expected one rotated backup, got %v", files)
+	}
+	backup, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(backup), "TCP_MISS/200") {
+		t.Errorf("Backup is missing the first line: %q", backup)
+	}
+}
+
+func TestNewAccessLogRejectsUnknownFormat(t *testing.T) {
+	if _, err := NewAccessLog(AccessLogOptions{Format: "apache"}); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+	if _, err := NewAccessLog(AccessLogOptions{Format: FormatCustom}); err == nil {
+		t.Error("Expected error for custom format without template")
+	}
+}
@@ -0,0 +1,207 @@
+package logger
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Access log formats
+const (
+	FormatJSON     = "json"
+	FormatSquid    = "squid"    // Squid native access.log, for SARG and LightSquid
+	FormatCombined = "combined" // Apache combined, for GoAccess and friends
+	FormatCustom   = "custom"   // user template, see ParseTemplate
+)
+
+// SquidCode returns the Squid result code for e: TCP_HIT when served from
+// the cache, TCP_OFFLINE_HIT when replayed from a fixture, TCP_TUNNEL for
+// tunnels and TCP_MISS otherwise
+func SquidCode(e *AccessEntry) string {
+	switch {
+	case e.Method == "CONNECT":
+		return "TCP_TUNNEL"
+	case e.Cache == "hit":
+		return "TCP_HIT"
+	case e.Cache == "fixture":
+		return "TCP_OFFLINE_HIT"
+	default:
+		return "TCP_MISS"
+	}
+}
+
+// formatSquid renders e like Squid's default "squid" logformat:
+//
+//	time.ms elapsed client code/status bytes method url user hierarchy/peer type
+//
+// at is the completion time of the request
+func formatSquid(e *AccessEntry, at time.Time) string {
+	hier := "HIER_DIRECT/" + dash(e.Host)
+	switch e.Cache {
+	case "hit", "fixture", "fixture_miss": // answered without going upstream
+		hier = "HIER_NONE/-"
+	}
+	return fmt.Sprintf("%d.%03d %6d %s %s/%03d %d %s %s %s %s %s",
+		at.Unix(), at.Nanosecond()/int(time.Millisecond),
+		e.Duration.Milliseconds(),
+		dash(clientIP(e.ClientAddr)),
+		SquidCode(e), e.Status,
+		e.BytesOut,
+		dash(e.Method),
+		dash(e.URL),
+		dash(e.User),
+		hier,
+		dash(e.ContentType))
+}
+
+// formatCombined renders e in the Apache combined log format; at is the
+// completion time and the logged time is when the request started
+func formatCombined(e *AccessEntry, at time.Time) string {
+	return fmt.Sprintf("%s - %s [%s] %s %d %d %s %s",
+		dash(clientIP(e.ClientAddr)),
+		dash(e.User),
+		at.Add(-e.Duration).Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(e.Method+" "+e.URL+" HTTP/1.1"),
+		e.Status,
+		e.BytesOut,
+		strconv.Quote(dash(e.Referer)),
+		strconv.Quote(dash(e.UserAgent)))
+}
+
+// templateFields maps template field names to their values; empty values
+// are written as "-"
+var templateFields = map[string]func(e *AccessEntry, at time.Time) string{
+	"time": func(_ *AccessEntry, at time.Time) string { return at.Format(time.RFC3339Nano) },
+	"time_unix": func(_ *AccessEntry, at time.Time) string {
+		return fmt.Sprintf("%d.%03d", at.Unix(), at.Nanosecond()/int(time.Millisecond))
+	},
+	"time_local": func(e *AccessEntry, at time.Time) string {
+		return at.Add(-e.Duration).Format("02/Jan/2006:15:04:05 -0700")
+	},
+	"duration_ms":  func(e *AccessEntry, _ time.Time) string { return strconv.FormatInt(e.Duration.Milliseconds(), 10) },
+	"upstream_ms":  func(e *AccessEntry, _ time.Time) string { return strconv.FormatInt(e.Upstream.Milliseconds(), 10) },
+	"client":       func(e *AccessEntry, _ time.Time) string { return e.ClientAddr },
+	"client_ip":    func(e *AccessEntry, _ time.Time) string { return clientIP(e.ClientAddr) },
+	"user":         func(e *AccessEntry, _ time.Time) string { return e.User },
+	"method":       func(e *AccessEntry, _ time.Time) string { return e.Method },
+	"url":          func(e *AccessEntry, _ time.Time) string { return e.URL },
+	"host":         func(e *AccessEntry, _ time.Time) string { return e.Host },
+	"scheme":       func(e *AccessEntry, _ time.Time) string { return e.Scheme },
+	"status":       func(e *AccessEntry, _ time.Time) string { return strconv.Itoa(e.Status) },
+	"bytes_in":     func(e *AccessEntry, _ time.Time) string { return strconv.FormatInt(e.BytesIn, 10) },
+	"bytes_out":    func(e *AccessEntry, _ time.Time) string { return strconv.FormatInt(e.BytesOut, 10) },
+	"cache":        func(e *AccessEntry, _ time.Time) string { return e.Cache },
+	"squid_code":   func(e *AccessEntry, _ time.Time) string { return SquidCode(e) },
+	"content_type": func(e *AccessEntry, _ time.Time) string { return e.ContentType },
+	"referer":      func(e *AccessEntry, _ time.Time) string { return e.Referer },
+	"user_agent":   func(e *AccessEntry, _ time.Time) string { return e.UserAgent },
+	"request_id":   func(e *AccessEntry, _ time.Time) string { return e.RequestID },
+	"tunnel_id":    func(e *AccessEntry, _ time.Time) string { return e.TunnelID },
+	"trace_id":     func(e *AccessEntry, _ time.Time) string { return e.TraceID },
+	"tls_version": func(e *AccessEntry, _ time.Time) string {
+		if e.TLS == nil {
+			return ""
+		}
+		return e.TLS.Version
+	},
+	"error": func(e *AccessEntry, _ time.Time) string { return e.Error },
+}
+
+// Template is a parsed custom access log format
+type Template struct {
+	literals []string // one more than fields
+	fields   []func(e *AccessEntry, at time.Time) string
+}
+
+// ParseTemplate parses a format such as
+//
+//	{time_unix} {client_ip} {squid_code}/{status} {bytes_out} {method} {url}
+//
+// where each {name} is one of the fields listed in the README; "{{" is a
+// literal brace
+func ParseTemplate(text string) (*Template, error) {
+	t := &Template{}
+	var lit strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c != '{' {
+			lit.WriteByte(c)
+			continue
+		}
+		if strings.HasPrefix(text[i:], "{{") {
+			lit.WriteByte('{')
+			i++
+			continue
+		}
+
+		end := strings.IndexByte(text[i:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated field at offset %d", i)
+		}
+		name := text[i+1 : i+end]
+		field, ok := templateFields[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown field {%s}", name)
+		}
+		t.literals = append(t.literals, lit.String())
+		t.fields = append(t.fields, field)
+		lit.Reset()
+		i += end
+	}
+	t.literals = append(t.literals, lit.String())
+
+	if len(t.fields) == 0 {
+		return nil, fmt.Errorf("template has no {fields}")
+	}
+	return t, nil
+}
+
+// Format renders e with the template; at is the completion time
+func (t *Template) Format(e *AccessEntry, at time.Time) string {
+	var b strings.Builder
+	for i, field := range t.fields {
+		b.WriteString(t.literals[i])
+		b.WriteString(dash(field(e, at)))
+	}
+	b.WriteString(t.literals[len(t.fields)])
+	return b.String()
+}
+
+// tunnelAccess describes a tunnel as an access entry for the text formats,
+// the way Squid logs CONNECT requests
+func tunnelAccess(e *TunnelEntry) *AccessEntry {
+	status := 200
+	if e.Error != "" {
+		status = 502
+	}
+	return &AccessEntry{
+		TunnelID:   e.TunnelID,
+		ClientAddr: e.ClientAddr,
+		User:       e.User,
+		Method:     "CONNECT",
+		URL:        e.Host,
+		Host:       e.Host,
+		Status:     status,
+		Duration:   e.Duration,
+		TLS:        e.TLS,
+		Error:      e.Error,
+	}
+}
+
+// clientIP strips the port from a client address
+func clientIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// dash returns "-" for empty values, as both Squid and Apache logs do
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -1,28 +1,37 @@
 package logger
 
 import (
+	"io"
 	"os"
 	"time"
 
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
 )
 
 // Logger wraps zerolog with proxy-specific functionality
 type Logger struct {
 	logger zerolog.Logger
+	out    io.Writer // where lines are written, for WithRedactor
+}
+
+// zerolog reads the time format globally, so set it once rather than in
+// every constructor while other loggers may be writing
+func init() {
+	zerolog.TimeFieldFormat = time.RFC3339Nano
 }
 
 // NewLogger creates a new production-ready logger
 func NewLogger() *Logger {
-	// Configure time format
-	zerolog.TimeFieldFormat = time.RFC3339Nano
+	return NewLoggerWithWriter(os.Stdout)
+}
 
+// NewLoggerWithWriter creates a logger that writes to w
+func NewLoggerWithWriter(w io.Writer) *Logger {
 	// Determine output format
-	var output = os.Stdout
+	output := w
 	if os.Getenv("LOG_FORMAT") == "pretty" {
 		output = zerolog.ConsoleWriter{
-			Out:        os.Stdout,
+			Out:        w,
 			TimeFormat: "15:04:05",
 		}
 	}
@@ -45,7 +54,17 @@ func NewLogger() *Logger {
 		Str("version", getVersion()).
 		Logger()
 
-	return &Logger{logger: logger}
+	return &Logger{logger: logger, out: output}
+}
+
+// WithRedactor returns a logger that passes every line through r; a nil
+// r returns l
+func (l *Logger) WithRedactor(r *Redactor) *Logger {
+	if r == nil || l.out == nil {
+		return l
+	}
+	out := r.Writer(l.out)
+	return &Logger{logger: l.logger.Output(out), out: out}
 }
 
 // Info logs an info message
@@ -126,6 +145,7 @@ func (l *Logger) LogCacheStats(hits, misses uint64, hitRate float64, sizeBytes i
 func (l *Logger) WithField(key string, value interface{}) *Logger {
 	return &Logger{
 		logger: l.logger.With().Interface(key, value).Logger(),
+		out:    l.out,
 	}
 }
 
@@ -137,6 +157,7 @@ func (l *Logger) WithFields(fields map[string]interface{}) *Logger {
 	}
 	return &Logger{
 		logger: ctx.Logger(),
+		out:    l.out,
 	}
 }
 
@@ -0,0 +1,224 @@
+package logger
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces secrets in mask mode
+const Redacted = "[REDACTED]"
+
+// Redaction modes
+const (
+	RedactMask = "mask" // replace secrets with [REDACTED]
+	RedactHash = "hash" // replace secrets with a keyed hash so they can be correlated
+	RedactOff  = "off"
+)
+
+// DefaultRedactHeaders, DefaultRedactQueryParams and DefaultRedactPatterns
+// are the built-in rules
+var (
+	DefaultRedactHeaders = []string{
+		"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Auth-Token",
+	}
+	DefaultRedactQueryParams = []string{
+		"api_key", "apikey", "access_token", "refresh_token", "id_token", "token", "password", "secret",
+		"client_secret", "signature", "X-Amz-Signature", "X-Amz-Credential",
+	}
+	DefaultRedactPatterns = []string{
+		`(?i)\bbearer\s+([A-Za-z0-9._~+/-]+=*)`,                             // bearer tokens
+		`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`,               // JWTs
+		`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,                                     // AWS access key IDs
+		`(?i)aws_secret_access_key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})`, // AWS secret keys
+	}
+)
+
+// RedactOptions configures a Redactor
+type RedactOptions struct {
+	Mode        string   // mask (default), hash or off
+	HashKey     string   // HMAC key for hash mode; empty picks a random key per process
+	Headers     []string // header names whose values are secret
+	QueryParams []string // query parameter names whose values are secret
+	Patterns    []string // regular expressions; with a capture group only the group is replaced
+}
+
+// Redactor masks secrets in log lines, URLs, headers and error messages.
+// A nil *Redactor returns everything unchanged.
+type Redactor struct {
+	hashKey []byte          // nil in mask mode
+	headers map[string]bool // canonical names
+	params  map[string]bool // lower-case names
+	rules   []*regexp.Regexp
+}
+
+// NewRedactor compiles opts; it returns nil when redaction is off
+func NewRedactor(opts RedactOptions) (*Redactor, error) {
+	r := &Redactor{headers: make(map[string]bool), params: make(map[string]bool)}
+
+	switch opts.Mode {
+	case "", RedactMask:
+	case RedactHash:
+		r.hashKey = []byte(opts.HashKey)
+		if len(r.hashKey) == 0 {
+			r.hashKey = make([]byte, 32)
+			rand.Read(r.hashKey)
+		}
+	case RedactOff:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown redaction mode %q", opts.Mode)
+	}
+
+	// Header values in free text: "Cookie: a=b" and JSON "cookie":"a=b".
+	// Values stop at a backslash so JSON escapes stay intact.
+	if len(opts.Headers) > 0 {
+		names := make([]string, len(opts.Headers))
+		for i, name := range opts.Headers {
+			r.headers[http.CanonicalHeaderKey(name)] = true
+			names[i] = regexp.QuoteMeta(name)
+		}
+		r.rules = append(r.rules, regexp.MustCompile(
+			`(?i)"?\b(?:`+strings.Join(names, "|")+`)"?\s*[:=]\s*"?([^"\\\r\n]*)`))
+	}
+	// Query parameters anywhere a URL appears
+	if len(opts.QueryParams) > 0 {
+		names := make([]string, len(opts.QueryParams))
+		for i, name := range opts.QueryParams {
+			r.params[strings.ToLower(name)] = true
+			names[i] = regexp.QuoteMeta(name)
+		}
+		r.rules = append(r.rules, regexp.MustCompile(
+			`(?i)[?&;](?:`+strings.Join(names, "|")+`)=([^&#\s"\\]*)`))
+	}
+	for _, pattern := range opts.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction pattern %q: %w", pattern, err)
+		}
+		r.rules = append(r.rules, re)
+	}
+	return r, nil
+}
+
+// replacement returns what a secret is written as
+func (r *Redactor) replacement(secret string) string {
+	if r.hashKey == nil {
+		return Redacted
+	}
+	mac := hmac.New(sha256.New, r.hashKey)
+	mac.Write([]byte(secret))
+	return "[HASH:" + hex.EncodeToString(mac.Sum(nil)[:8]) + "]"
+}
+
+// String redacts every rule match in s
+func (r *Redactor) String(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, re := range r.rules {
+		s = r.replaceAll(re, s)
+	}
+	return s
+}
+
+// replaceAll replaces matches of re, or only their first group if re has
+// one, skipping empty and already redacted values
+func (r *Redactor) replaceAll(re *regexp.Regexp, s string) string {
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if len(m) >= 4 {
+			start, end = m[2], m[3]
+		}
+		if start < 0 || start == end || isRedacted(s[start:end]) {
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(r.replacement(s[start:end]))
+		last = end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+func isRedacted(s string) bool {
+	return s == Redacted || strings.HasPrefix(s, "[HASH:")
+}
+
+// Error returns the redacted message of err
+func (r *Redactor) Error(err error) string {
+	return r.String(err.Error())
+}
+
+// Header returns a copy of h with secret header values replaced and the
+// patterns applied to the rest
+func (r *Redactor) Header(h http.Header) http.Header {
+	if r == nil {
+		return h
+	}
+	out := make(http.Header, len(h))
+	for name, values := range h {
+		redacted := make([]string, len(values))
+		for i, v := range values {
+			redacted[i] = r.HeaderValue(name, v)
+		}
+		out[name] = redacted
+	}
+	return out
+}
+
+// HeaderValue redacts a single header value
+func (r *Redactor) HeaderValue(name, value string) string {
+	if r == nil {
+		return value
+	}
+	if r.headers[http.CanonicalHeaderKey(name)] {
+		return r.replacement(value)
+	}
+	return r.String(value)
+}
+
+// QueryValue redacts a single query parameter value
+func (r *Redactor) QueryValue(name, value string) string {
+	if r == nil {
+		return value
+	}
+	if r.params[strings.ToLower(name)] {
+		return r.replacement(value)
+	}
+	return r.String(value)
+}
+
+// Writer wraps w so that every write, one log line for zerolog, is
+// redacted
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+	if r == nil {
+		return w
+	}
+	return &redactingWriter{w: w, r: r}
+}
+
+type redactingWriter struct {
+	w io.Writer
+	r *Redactor
+}
+
+func (rw *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, rw.r.String(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
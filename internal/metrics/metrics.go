@@ -0,0 +1,404 @@
+// Package metrics is a small Prometheus-compatible metrics registry. It
+// supports labeled counters, gauges and histograms plus scrape-time
+// collectors, and renders the text exposition format (version 0.0.4).
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Kind is the Prometheus metric type
+type Kind string
+
+const (
+	KindCounter   Kind = "counter"
+	KindGauge     Kind = "gauge"
+	KindHistogram Kind = "histogram"
+)
+
+// DefaultBuckets suit request latencies in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Registry holds metric families in registration order
+type Registry struct {
+	mu       sync.RWMutex
+	families []family
+	names    map[string]bool
+}
+
+// family is anything that can write its samples
+type family interface {
+	write(w *bufio.Writer)
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.families = append(r.families, f)
+}
+
+// WriteText renders all metrics in the Prometheus text format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.RLock()
+	families := append([]family(nil), r.families...)
+	r.mu.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry for Prometheus scrapes
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.WriteText(w)
+	})
+}
+
+// desc is the shared part of every metric family
+type desc struct {
+	name   string
+	help   string
+	kind   Kind
+	labels []string
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, strings.ReplaceAll(d.help, "\n", " "))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+// labelString renders {a="x",b="y"} plus optional extra pairs
+func (d *desc) labelString(values []string, extra ...string) string {
+	if len(d.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range d.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		writeLabel(&b, name, values[i])
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		writeLabel(&b, extra[i], extra[i+1])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeLabel(b *strings.Builder, name, value string) {
+	b.WriteString(name)
+	b.WriteString(`="`)
+	b.WriteString(labelEscaper.Replace(value))
+	b.WriteByte('"')
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// atomicFloat is a float64 updated with compare-and-swap
+type atomicFloat struct {
+	bits uint64
+}
+
+func (f *atomicFloat) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&f.bits, old, next) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) Set(v float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+}
+
+func (f *atomicFloat) Load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+// vec maps label values to series
+type vec[T any] struct {
+	desc
+	mu     sync.RWMutex
+	series map[string]*T
+	values map[string][]string
+	newT   func() *T
+}
+
+func newVec[T any](name, help string, kind Kind, labels []string, newT func() *T) *vec[T] {
+	return &vec[T]{
+		desc:   desc{name: name, help: help, kind: kind, labels: labels},
+		series: make(map[string]*T),
+		values: make(map[string][]string),
+		newT:   newT,
+	}
+}
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	s, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok = v.series[key]; !ok {
+		s = v.newT()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), values...)
+	}
+	return s
+}
+
+// each visits series in label order so output is stable
+func (v *vec[T]) each(fn func(values []string, s *T)) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	series := make([]*T, len(keys))
+	values := make([][]string, len(keys))
+	for i, key := range keys {
+		series[i], values[i] = v.series[key], v.values[key]
+	}
+	v.mu.RUnlock()
+
+	for i := range keys {
+		fn(values[i], series[i])
+	}
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	v atomicFloat
+}
+
+// Inc adds one
+func (c *Counter) Inc() { c.v.Add(1) }
+
+// Add adds a non-negative delta
+func (c *Counter) Add(delta float64) {
+	if delta > 0 {
+		c.v.Add(delta)
+	}
+}
+
+// Value returns the current count
+func (c *Counter) Value() float64 { return c.v.Load() }
+
+// CounterVec is a counter family partitioned by labels
+type CounterVec struct {
+	*vec[Counter]
+}
+
+// NewCounterVec registers a counter family
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, KindCounter, labels, func() *Counter { return &Counter{} })}
+	r.register(name, c)
+	return c
+}
+
+// With returns the counter for the given label values
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.with(values)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.each(func(values []string, s *Counter) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(values), formatValue(s.Value()))
+	})
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	v atomicFloat
+}
+
+// Set replaces the value
+func (g *Gauge) Set(v float64) { g.v.Set(v) }
+
+// Add adds delta, which may be negative
+func (g *Gauge) Add(delta float64) { g.v.Add(delta) }
+
+// Inc adds one
+func (g *Gauge) Inc() { g.v.Add(1) }
+
+// Dec subtracts one
+func (g *Gauge) Dec() { g.v.Add(-1) }
+
+// Value returns the current value
+func (g *Gauge) Value() float64 { return g.v.Load() }
+
+// GaugeVec is a gauge family partitioned by labels
+type GaugeVec struct {
+	*vec[Gauge]
+}
+
+// NewGaugeVec registers a gauge family
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, KindGauge, labels, func() *Gauge { return &Gauge{} })}
+	r.register(name, g)
+	return g
+}
+
+// With returns the gauge for the given label values
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.with(values)
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	g.each(func(values []string, s *Gauge) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelString(values), formatValue(s.Value()))
+	})
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	bounds []float64
+	counts []uint64 // one per bound plus +Inf
+	sum    atomicFloat
+}
+
+// Observe records one value
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	atomic.AddUint64(&h.counts[i], 1)
+	h.sum.Add(v)
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	var total uint64
+	for i := range h.counts {
+		total += atomic.LoadUint64(&h.counts[i])
+	}
+	return total
+}
+
+// HistogramVec is a histogram family partitioned by labels
+type HistogramVec struct {
+	*vec[Histogram]
+}
+
+// NewHistogramVec registers a histogram family with the given upper bounds
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	h := &HistogramVec{newVec(name, help, KindHistogram, labels, func() *Histogram {
+		return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+	})}
+	r.register(name, h)
+	return h
+}
+
+// With returns the histogram for the given label values
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.with(values)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.each(func(values []string, s *Histogram) {
+		var cumulative uint64
+		for i, bound := range s.bounds {
+			cumulative += atomic.LoadUint64(&s.counts[i])
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(values, "le", formatValue(bound)), cumulative)
+		}
+		cumulative += atomic.LoadUint64(&s.counts[len(s.bounds)])
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(values, "le", "+Inf"), cumulative)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(values), formatValue(s.sum.Load()))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(values), cumulative)
+	})
+}
+
+// Sample is one series produced by a collector
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// collector produces samples at scrape time
+type collector struct {
+	desc
+	fn func() []Sample
+}
+
+// NewCollector registers a counter or gauge family whose samples are read
+// from fn on every scrape, for values owned by other components
+func (r *Registry) NewCollector(name, help string, kind Kind, labels []string, fn func() []Sample) {
+	r.register(name, &collector{desc: desc{name: name, help: help, kind: kind, labels: labels}, fn: fn})
+}
+
+// NewGaugeFunc registers an unlabeled gauge read from fn on every scrape
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.NewCollector(name, help, KindGauge, nil, func() []Sample { return []Sample{{Value: fn()}} })
+}
+
+// NewCounterFunc registers an unlabeled counter read from fn on every scrape
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.NewCollector(name, help, KindCounter, nil, func() []Sample { return []Sample{{Value: fn()}} })
+}
+
+func (c *collector) write(w *bufio.Writer) {
+	samples := c.fn()
+	if len(samples) == 0 {
+		return
+	}
+	c.writeHeader(w)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(s.LabelValues), formatValue(s.Value))
+	}
+}
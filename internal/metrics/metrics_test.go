@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func render(t *testing.T, r *Registry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	return buf.String()
+}
+
+func TestCounterAndGaugeText(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("test_requests_total", "Requests.", "method", "status")
+	requests.With("GET", "200").Inc()
+	requests.With("GET", "200").Add(2)
+	requests.With("POST", "500").Inc()
+	requests.With("POST", "500").Add(-5) // ignored: counters never go down
+
+	inflight := r.NewGaugeVec("test_inflight", "In flight.").With()
+	inflight.Inc()
+	inflight.Inc()
+	inflight.Dec()
+
+	out := render(t, r)
+	for _, want := range []string{
+		"# HELP test_requests_total Requests.\n# TYPE test_requests_total counter\n",
+		`test_requests_total{method="GET",status="200"} 3` + "\n",
+		`test_requests_total{method="POST",status="500"} 1` + "\n",
+		"# TYPE test_inflight gauge\ntest_inflight 1\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestHistogramText(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("test_seconds", "Latency.", []float64{0.1, 1}, "scheme").With("https")
+	h.Observe(0.05)
+	h.Observe(0.1) // upper bounds are inclusive
+	h.Observe(0.5)
+	h.Observe(3)
+
+	out := render(t, r)
+	for _, want := range []string{
+		`test_seconds_bucket{scheme="https",le="0.1"} 2`,
+		`test_seconds_bucket{scheme="https",le="1"} 3`,
+		`test_seconds_bucket{scheme="https",le="+Inf"} 4`,
+		`test_seconds_sum{scheme="https"} 3.65`,
+		`test_seconds_count{scheme="https"} 4`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("Output missing %q:\n%s", want, out)
+		}
+	}
+	if h.Count() != 4 {
+		t.Errorf("Expected 4 observations, got %d", h.Count())
+	}
+}
+
+func TestLabelEscapingAndCollectors(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_hosts_total", "Hosts.", "host").With("a\"b\\c\nd").Inc()
+	r.NewCollector("test_tier_hits_total", "Tier hits.", KindCounter, []string{"tier"}, func() []Sample {
+		return []Sample{{LabelValues: []string{"memory"}, Value: 7}}
+	})
+	r.NewGaugeFunc("test_size_bytes", "Size.", func() float64 { return 1.5e9 })
+
+	out := render(t, r)
+	for _, want := range []string{
+		`test_hosts_total{host="a\"b\\c\nd"} 1`,
+		`test_tier_hits_total{tier="memory"} 7`,
+		`test_size_bytes 1.5e+09`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("Output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestDuplicateRegistrationPanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_total", "Test.")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic on duplicate metric name")
+		}
+	}()
+	r.NewGaugeVec("test_total", "Test.")
+}
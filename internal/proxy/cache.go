@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/onixus/4ebur-net/internal/cache"
+	"github.com/onixus/4ebur-net/internal/config"
+)
+
+// newResponseCache builds the configured cache backend, with the disk tier
+// when one is configured. When Redis or the disk cannot be used the proxy
+// starts with the remaining tiers rather than failing.
+func newResponseCache(cfg config.CacheConfig, maxAge time.Duration) *cache.TieredCache {
+	newMemory := func() *cache.HTTPCache {
+		return cache.NewHTTPCacheWithOptions(cache.HTTPCacheOptions{
+			MaxSize:         cfg.SizeMB * 1024 * 1024,
+			MaxAge:          maxAge,
+			Admission:       cfg.Admission.Policy == "tinylfu",
+			MaxObjectSize:   cfg.Admission.MaxObjectSizeKB * 1024,
+			EvictLargeFirst: cfg.Admission.EvictLargeFirst,
+		})
+	}
+
+	var disk *cache.DiskCache
+	if cfg.Disk.Dir != "" {
+		var err error
+		if disk, err = cache.NewDiskCache(cfg.Disk.Dir, cfg.Disk.SizeMB*1024*1024); err != nil {
+			log.Printf("⚠️  Disk cache at %s unavailable: %v", cfg.Disk.Dir, err)
+		}
+	}
+
+	switch cfg.Backend {
+	case "memory", "":
+		return cache.NewTieredCache(newMemory(), nil, maxAge).WithDisk(disk)
+	case "disk":
+		if disk == nil {
+			return cache.NewTieredCache(newMemory(), nil, maxAge)
+		}
+		return cache.NewTieredCache(nil, nil, maxAge).WithDisk(disk)
+	}
+
+	opts, err := redisOptions(cfg.Redis)
+	var redis *cache.RedisBackend
+	if err == nil {
+		redis, err = cache.NewRedisBackendWithOptions(opts)
+	}
+	if err != nil {
+		log.Printf("⚠️  Redis cache at %s unavailable, using local tiers only: %v", opts, err)
+		return cache.NewTieredCache(newMemory(), nil, maxAge).WithDisk(disk)
+	}
+	log.Printf("🗄️  Redis cache: %s (%s)", opts, cfg.Backend)
+
+	var memory *cache.HTTPCache
+	if cfg.Backend == "tiered" {
+		memory = newMemory()
+	}
+	tiered := cache.NewTieredCache(memory, redis, maxAge).WithDisk(disk)
+	tiered.ListenInvalidations()
+	return tiered
+}
+
+// redisOptions translates the Redis settings, loading TLS files
+func redisOptions(cfg config.RedisConfig) (cache.RedisOptions, error) {
+	opts := cache.RedisOptions{
+		Addrs:            cfg.Addrs,
+		MasterName:       cfg.MasterName,
+		Cluster:          cfg.Cluster,
+		Username:         cfg.Username,
+		Password:         cfg.Password,
+		SentinelPassword: cfg.SentinelPassword,
+		DB:               cfg.DB,
+		Namespace:        cfg.Namespace,
+		CompressMinSize:  cfg.CompressMinKB * 1024,
+	}
+	if cfg.MasterName == "" && !cfg.Cluster {
+		opts.Addrs = []string{cfg.Addr}
+	}
+	if !cfg.TLS.Enabled {
+		return opts, nil
+	}
+
+	opts.TLS = &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.TLS.ServerName,
+		InsecureSkipVerify: cfg.TLS.InsecureSkipVerify,
+	}
+	if cfg.TLS.CAFile != "" {
+		pem, err := os.ReadFile(cfg.TLS.CAFile)
+		if err != nil {
+			return opts, fmt.Errorf("redis tls ca_file: %w", err)
+		}
+		opts.TLS.RootCAs = x509.NewCertPool()
+		if !opts.TLS.RootCAs.AppendCertsFromPEM(pem) {
+			return opts, errors.New("redis tls ca_file: no certificates found")
+		}
+	}
+	if cfg.TLS.CertFile != "" {
+		pair, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return opts, fmt.Errorf("redis tls client certificate: %w", err)
+		}
+		opts.TLS.Certificates = []tls.Certificate{pair}
+	}
+	return opts, nil
+}
+
+// cacheBackend names the tiers actually in use, which differs from the
+// configured backend when Redis or the disk was unusable at startup
+func (p *ProxyServer) cacheBackend() string {
+	var backend string
+	switch {
+	case p.cache.Redis() == nil && p.cache.Memory() == nil:
+		return "disk"
+	case p.cache.Redis() == nil:
+		backend = "memory"
+	case p.cache.Memory() == nil:
+		backend = "redis"
+	default:
+		backend = "tiered"
+	}
+	if p.cache.Disk() != nil {
+		backend += "+disk"
+	}
+	return backend
+}
+
+// GetCacheTiers returns the active cache backend and hits per tier
+func (p *ProxyServer) GetCacheTiers() (backend string, tierHits map[string]uint64) {
+	_, _, l1Hits, l2Hits, _ := p.cache.Stats()
+	backend = p.cacheBackend()
+	tierHits = make(map[string]uint64)
+	if p.cache.Memory() != nil {
+		tierHits["memory"] = l1Hits
+	}
+	if p.cache.Disk() != nil {
+		tierHits["disk"] = p.cache.DiskHits()
+	}
+	if p.cache.Redis() != nil {
+		tierHits["redis"] = l2Hits
+	}
+	return backend, tierHits
+}
@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/onixus/4ebur-net/internal/cert"
+	"github.com/onixus/4ebur-net/internal/config"
+)
+
+func newCacheProxy(t *testing.T, backend, redisAddr string) *ProxyServer {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Cache.Backend = backend
+	cfg.Cache.Redis.Addr = redisAddr
+
+	server, err := NewProxyServerWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func TestTieredCacheSharedBetweenProxies(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	var hits int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("shared"))
+	}))
+	defer backend.Close()
+
+	get := func(server *ProxyServer) string {
+		t.Helper()
+		proxy := httptest.NewServer(server)
+		defer proxy.Close()
+		resp, err := proxyClient(proxy).Get(backend.URL + "/asset")
+		if err != nil {
+			t.Fatalf("Request through proxy failed: %v", err)
+		}
+		defer resp.Body.Close()
+		io.ReadAll(resp.Body)
+		return resp.Header.Get("X-Cache")
+	}
+
+	first := newCacheProxy(t, "tiered", mr.Addr())
+	second := newCacheProxy(t, "tiered", mr.Addr())
+	if got := get(first); got != "MISS" {
+		t.Errorf("First request: expected MISS, got %q", got)
+	}
+	if got := get(second); got != "HIT" {
+		t.Errorf("Second proxy should hit the shared Redis tier, got %q", got)
+	}
+	if atomic.LoadInt32(&hits) != 1 {
+		t.Errorf("Expected 1 upstream request, got %d", hits)
+	}
+
+	backendName, tierHits := second.GetCacheTiers()
+	if backendName != "tiered" || tierHits["redis"] != 1 || tierHits["memory"] != 0 {
+		t.Errorf("Unexpected tiers %s %v", backendName, tierHits)
+	}
+}
+
+func TestDiskCacheSurvivesRestart(t *testing.T) {
+	body := strings.Repeat("large ", 100<<10)
+	var hits int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(body))
+	}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	get := func() string {
+		t.Helper()
+		cfg := config.Default()
+		cfg.Cache.Backend = "disk"
+		cfg.Cache.Disk.Dir = dir
+		server, err := NewProxyServerWithConfig(cfg)
+		if err != nil {
+			t.Fatalf("Failed to create proxy server: %v", err)
+		}
+		defer server.Close()
+		proxy := httptest.NewServer(server)
+		defer proxy.Close()
+
+		resp, err := proxyClient(proxy).Get(backend.URL + "/large")
+		if err != nil {
+			t.Fatalf("Request through proxy failed: %v", err)
+		}
+		defer resp.Body.Close()
+		got, _ := io.ReadAll(resp.Body)
+		if string(got) != body {
+			t.Errorf("Body differs: got %d bytes", len(got))
+		}
+		if backendName, _ := server.GetCacheTiers(); backendName != "disk" {
+			t.Errorf("Expected disk backend, got %q", backendName)
+		}
+		if _, _, size, entries, _ := server.GetCacheStats(); resp.Header.Get("X-Cache") == "HIT" && (entries != 1 || size < int64(len(body))) {
+			t.Errorf("Stats should describe the disk tier: %d entries, %d bytes", entries, size)
+		}
+		return resp.Header.Get("X-Cache")
+	}
+
+	if got := get(); got != "MISS" {
+		t.Errorf("First request: expected MISS, got %q", got)
+	}
+	if got := get(); got != "HIT" {
+		t.Errorf("Restarted proxy should hit the disk tier, got %q", got)
+	}
+	if atomic.LoadInt32(&hits) != 1 {
+		t.Errorf("Expected 1 upstream request, got %d", hits)
+	}
+}
+
+func TestUnreachableRedisFallsBackToMemory(t *testing.T) {
+	mr := miniredis.RunT(t)
+	addr := mr.Addr()
+	mr.Close()
+
+	server := newCacheProxy(t, "redis", addr)
+	if backend, _ := server.GetCacheTiers(); backend != "memory" {
+		t.Errorf("Expected memory fallback, got %q", backend)
+	}
+}
+
+func TestRedisTLSAndACLFromConfig(t *testing.T) {
+	ca, err := cert.NewCertManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := ca.GetCertificate("redis.internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr, err := miniredis.RunTLS(&tls.Config{Certificates: []tls.Certificate{*leaf}})
+	if err != nil {
+		t.Fatalf("RunTLS: %v", err)
+	}
+	defer mr.Close()
+	mr.RequireUserAuth("proxy", "s3cret")
+
+	caFile := filepath.Join(t.TempDir(), "redis-ca.pem")
+	if err := os.WriteFile(caFile, ca.GetCACertPEM(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Default()
+	cfg.Cache.Backend = "redis"
+	cfg.Cache.Redis = config.RedisConfig{
+		Addr:     mr.Addr(),
+		Username: "proxy",
+		Password: "s3cret",
+		TLS:      config.RedisTLSConfig{Enabled: true, CAFile: caFile, ServerName: "redis.internal"},
+	}
+	server, err := NewProxyServerWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	defer server.Close()
+	if backend, _ := server.GetCacheTiers(); backend != "redis" {
+		t.Errorf("Expected the TLS Redis tier, got %q", backend)
+	}
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/onixus/4ebur-net/internal/cache"
+	"github.com/onixus/4ebur-net/internal/config"
+	"github.com/onixus/4ebur-net/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fixtures is the record/replay state; a nil *fixtures means normal
+// proxying through the HTTP cache
+type fixtures struct {
+	store      *cache.FixtureStore
+	replay     bool // false: record
+	missStatus int
+}
+
+// newFixtures opens the fixture store; it returns nil when the mode is off
+func newFixtures(cfg config.FixturesConfig) (*fixtures, error) {
+	if cfg.Mode == "" || cfg.Mode == "off" {
+		return nil, nil
+	}
+	store, err := cache.NewFixtureStore(cfg.Dir, cfg.MatchBody, cfg.IgnoreParams)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("📼 Fixtures: %s mode, store %s", cfg.Mode, cfg.Dir)
+	return &fixtures{store: store, replay: cfg.Mode == "replay", missStatus: cfg.MissStatus}, nil
+}
+
+// lookupFixture is lookupCache in record/replay mode. Recording always
+// misses so every response is fetched and stored; replaying always
+// answers, with the configured miss status when nothing was recorded.
+func (p *ProxyServer) lookupFixture(ctx context.Context, rec *requestRecord, r *http.Request, upgrade bool) (*cache.CacheEntry, bool) {
+	key, err := p.fixtures.store.Key(r)
+	if err != nil {
+		rec.err = err
+		return fixtureMiss(http.StatusBadRequest, p.redactor.Error(err)), p.fixtures.replay
+	}
+	rec.fixtureKey = key
+	if !p.fixtures.replay {
+		return nil, false
+	}
+
+	_, span := tracing.Start(ctx, "FixtureStore.Get", trace.SpanKindInternal)
+	defer span.End()
+
+	var entry *cache.CacheEntry
+	if !upgrade {
+		if entry, err = p.fixtures.store.Get(r, key); err != nil {
+			rec.err = err
+			tracing.SetError(span, err)
+		}
+	}
+	span.SetAttributes(attribute.Bool("cache.hit", entry != nil))
+
+	if entry == nil {
+		rec.cache = "fixture_miss"
+		return fixtureMiss(p.fixtures.missStatus, "no fixture recorded for "+r.Method+" "+p.redactor.String(r.URL.String())), true
+	}
+	rec.cache = "fixture"
+	if entry.Headers == nil {
+		entry.Headers = make(http.Header)
+	}
+	entry.Headers.Set("X-Fixture", "HIT")
+	rec.log.LogCacheOperation("get", key, true, entry.Size)
+	return entry, true
+}
+
+// fixtureMiss builds the response for a request that cannot be replayed
+func fixtureMiss(status int, msg string) *cache.CacheEntry {
+	body := []byte(msg + "\n")
+	return &cache.CacheEntry{
+		StatusCode: status,
+		Headers: http.Header{
+			"Content-Type":   {"text/plain; charset=utf-8"},
+			"Content-Length": {fmt.Sprint(len(body))},
+			"X-Fixture":      {"MISS"},
+		},
+		Body:     body,
+		CachedAt: time.Now(),
+		Size:     int64(len(body)),
+	}
+}
+
+// newFixtureBody wraps resp.Body so the complete response is recorded as
+// it streams, whatever its status, cache headers or size. The fixture is
+// written in the background, or right away when too many writes are
+// pending, since a recording must not be lost.
+func (p *ProxyServer) newFixtureBody(ctx context.Context, rec *requestRecord, req *http.Request, resp *http.Response) io.ReadCloser {
+	ctx = context.WithoutCancel(ctx)
+	return cache.NewFillBody(resp, 0, 0, func(entry *cache.CacheEntry) {
+		entry.URL = req.URL.String()
+		record := func() {
+			_, span := tracing.Start(ctx, "FixtureStore.Set", trace.SpanKindInternal, attribute.Int64("cache.entry_size", entry.Size))
+			defer span.End()
+
+			if err := p.fixtures.store.Set(req, rec.fixtureKey, entry); err != nil {
+				rec.log.Warn("not recording " + p.redactor.String(entry.URL) + ": " + err.Error())
+				tracing.SetError(span, err)
+				return
+			}
+			rec.log.LogCacheOperation("record", rec.fixtureKey, false, entry.Size)
+		}
+		if !p.stores.run(rec.fixtureKey, record) {
+			record()
+		}
+	})
+}
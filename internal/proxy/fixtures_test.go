@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/onixus/4ebur-net/internal/config"
+)
+
+func newFixtureProxy(t *testing.T, mode, dir string) *ProxyServer {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Fixtures.Mode = mode
+	cfg.Fixtures.Dir = dir
+	cfg.Fixtures.MatchBody = true
+	cfg.Fixtures.MissStatus = http.StatusNotImplemented
+
+	server, err := NewProxyServerWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	return server
+}
+
+func TestFixturesRecordThenReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	var hits int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		body, _ := io.ReadAll(r.Body)
+		// Uncacheable on purpose: recording ignores cache headers
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("echo " + string(body)))
+	}))
+	target := backend.URL + "/rpc"
+
+	post := func(server *ProxyServer, body string) (int, string, http.Header) {
+		t.Helper()
+		proxy := httptest.NewServer(server)
+		defer proxy.Close()
+		resp, err := proxyClient(proxy).Post(target, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Request through proxy failed: %v", err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data), resp.Header
+	}
+
+	recorder := newFixtureProxy(t, "record", dir)
+	for i := 0; i < 2; i++ {
+		if status, body, _ := post(recorder, `{"op": "ping"}`); status != http.StatusOK || body != `echo {"op": "ping"}` {
+			t.Fatalf("Record: got %d %q", status, body)
+		}
+	}
+	recorder.Close()
+	if atomic.LoadInt32(&hits) != 2 {
+		t.Errorf("Recording should always go upstream, backend saw %d requests", hits)
+	}
+
+	// Replay must not touch the network
+	backend.Close()
+	replayer := newFixtureProxy(t, "replay", dir)
+	defer replayer.Close()
+
+	status, body, header := post(replayer, `{"op":"ping"}`)
+	if status != http.StatusOK || body != `echo {"op": "ping"}` || header.Get("X-Fixture") != "HIT" {
+		t.Errorf("Replay hit: got %d %q %v", status, body, header)
+	}
+
+	status, _, header = post(replayer, `{"op":"pong"}`)
+	if status != http.StatusNotImplemented || header.Get("X-Fixture") != "MISS" {
+		t.Errorf("Replay miss: expected configured status 501, got %d %v", status, header)
+	}
+}
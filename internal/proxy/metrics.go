@@ -0,0 +1,248 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/onixus/4ebur-net/internal/cache"
+	"github.com/onixus/4ebur-net/internal/metrics"
+)
+
+// otherHost labels hosts beyond the per-host cardinality limit
+const otherHost = "other"
+
+// otherMethod labels request methods outside knownMethods, which clients
+// could otherwise use to create any number of series
+const otherMethod = "OTHER"
+
+// knownMethods are the methods of RFC 9110 and PATCH
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// methodLabel returns the metric label of a request method
+func methodLabel(method string) string {
+	if knownMethods[method] {
+		return method
+	}
+	return otherMethod
+}
+
+// proxyMetrics holds the instruments exported on /metrics
+type proxyMetrics struct {
+	registry *metrics.Registry
+
+	requests      *metrics.CounterVec   // method, status, scheme
+	responseBytes *metrics.CounterVec   // scheme, cache
+	ttfb          *metrics.HistogramVec // scheme
+	duration      *metrics.HistogramVec // scheme, cache
+	hostRequests  *metrics.CounterVec   // host, code
+	hostBytes     *metrics.CounterVec   // host
+
+	cacheServed   *metrics.Counter
+	cacheStored   *metrics.Counter
+	revalidations *metrics.CounterVec // result
+	connections   *metrics.Gauge
+
+	certsGenerated *metrics.CounterVec // result
+	certDuration   *metrics.Histogram
+
+	hosts hostLimiter
+}
+
+// newProxyMetrics registers all proxy metrics; cache and tunnel values are
+// read from p at scrape time
+func newProxyMetrics(p *ProxyServer) *proxyMetrics {
+	reg := metrics.NewRegistry()
+	m := &proxyMetrics{registry: reg}
+
+	m.requests = reg.NewCounterVec("cheburnet_requests_total",
+		"Proxied requests by method, response status and scheme.", "method", "status", "scheme")
+	m.responseBytes = reg.NewCounterVec("cheburnet_response_bytes_total",
+		"Response body bytes sent to clients.", "scheme", "cache")
+	m.ttfb = reg.NewHistogramVec("cheburnet_upstream_ttfb_seconds",
+		"Time from forwarding a request until upstream response headers arrive.", metrics.DefaultBuckets, "scheme")
+	m.duration = reg.NewHistogramVec("cheburnet_request_duration_seconds",
+		"Total time to serve a request, including the response body.", metrics.DefaultBuckets, "scheme", "cache")
+	m.hostRequests = reg.NewCounterVec("cheburnet_host_requests_total",
+		"Requests per upstream host; hosts beyond metrics.max_hosts are labeled \"other\".", "host", "code")
+	m.hostBytes = reg.NewCounterVec("cheburnet_host_response_bytes_total",
+		"Response body bytes per upstream host; hosts beyond metrics.max_hosts are labeled \"other\".", "host")
+
+	reg.NewCounterFunc("cheburnet_cache_hits_total", "Cache lookups that found a fresh entry.", func() float64 {
+		hits, _, _, _, _ := p.cache.Stats()
+		return float64(hits)
+	})
+	reg.NewCounterFunc("cheburnet_cache_misses_total", "Cache lookups that found no fresh entry.", func() float64 {
+		_, misses, _, _, _ := p.cache.Stats()
+		return float64(misses)
+	})
+	if memory := p.cache.Memory(); memory != nil {
+		reg.NewCounterFunc("cheburnet_cache_evictions_total", "Entries evicted to make room for new ones.", func() float64 {
+			return float64(memory.Evictions())
+		})
+		reg.NewCounterFunc("cheburnet_cache_admissions_rejected_total", "Responses the admission policy kept out of the memory cache.", func() float64 {
+			return float64(memory.Rejections())
+		})
+		reg.NewGaugeFunc("cheburnet_cache_size_bytes", "Bytes currently held in the memory cache.", func() float64 {
+			_, _, size, _ := memory.Stats()
+			return float64(size)
+		})
+		reg.NewGaugeFunc("cheburnet_cache_entries", "Entries currently held in the memory cache.", func() float64 {
+			_, _, _, entries := memory.Stats()
+			return float64(entries)
+		})
+	}
+	if disk := p.cache.Disk(); disk != nil {
+		reg.NewCounterFunc("cheburnet_disk_cache_evictions_total", "Disk cache files evicted to make room for new ones.", func() float64 {
+			return float64(disk.Evictions())
+		})
+		reg.NewGaugeFunc("cheburnet_disk_cache_size_bytes", "Body bytes currently held in the disk cache.", func() float64 {
+			_, _, size, _ := disk.Stats()
+			return float64(size)
+		})
+		reg.NewGaugeFunc("cheburnet_disk_cache_entries", "Entries currently held in the disk cache.", func() float64 {
+			_, _, _, entries := disk.Stats()
+			return float64(entries)
+		})
+	}
+	if p.cache.Redis() != nil || p.cache.Disk() != nil {
+		registerTieredCacheMetrics(reg, p.cache)
+	}
+	m.cacheServed = reg.NewCounterVec("cheburnet_cache_served_bytes_total",
+		"Response body bytes served from the cache.").With()
+	m.cacheStored = reg.NewCounterVec("cheburnet_cache_stored_bytes_total",
+		"Response body bytes written to the cache.").With()
+	m.revalidations = reg.NewCounterVec("cheburnet_cache_revalidations_total",
+		"Stale cache entries revalidated upstream, by whether the origin answered 304.", "result")
+
+	reg.NewGaugeFunc("cheburnet_active_tunnels", "Open CONNECT, upgrade and transparent TLS tunnels.", func() float64 {
+		return float64(p.tunnels.count())
+	})
+	m.connections = reg.NewGaugeVec("cheburnet_active_connections",
+		"Open client connections still handled by the HTTP server.").With()
+
+	m.certsGenerated = reg.NewCounterVec("cheburnet_certs_generated_total",
+		"Leaf certificates minted for intercepted hosts.", "result")
+	m.certDuration = reg.NewHistogramVec("cheburnet_cert_generation_seconds",
+		"Time to mint a leaf certificate.", []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2}).With()
+
+	return m
+}
+
+// observeCert records one certificate generation
+func (m *proxyMetrics) observeCert(_ string, took time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.certsGenerated.With(result).Inc()
+	m.certDuration.Observe(took.Seconds())
+}
+
+// registerTieredCacheMetrics exports per-tier hit counters of tc
+func registerTieredCacheMetrics(reg *metrics.Registry, tc *cache.TieredCache) {
+	reg.NewCollector("cheburnet_tiered_cache_hits_total", "Tiered cache hits by tier.",
+		metrics.KindCounter, []string{"tier"}, func() []metrics.Sample {
+			_, _, l1Hits, l2Hits, _ := tc.Stats()
+			var samples []metrics.Sample
+			if tc.Memory() != nil {
+				samples = append(samples, metrics.Sample{LabelValues: []string{"memory"}, Value: float64(l1Hits)})
+			}
+			if tc.Disk() != nil {
+				samples = append(samples, metrics.Sample{LabelValues: []string{"disk"}, Value: float64(tc.DiskHits())})
+			}
+			if tc.Redis() != nil {
+				samples = append(samples, metrics.Sample{LabelValues: []string{"redis"}, Value: float64(l2Hits)})
+			}
+			return samples
+		})
+	reg.NewCounterFunc("cheburnet_tiered_cache_misses_total", "Lookups that missed every tier.", func() float64 {
+		_, misses, _, _, _ := tc.Stats()
+		return float64(misses)
+	})
+	if tc.Redis() != nil {
+		reg.NewCounterFunc("cheburnet_tiered_cache_redis_errors_total", "Failed Redis operations; each one skips Redis for a few seconds.", func() float64 {
+			return float64(tc.L2Errors())
+		})
+	}
+}
+
+// hostLimiter caps the number of distinct host label values
+type hostLimiter struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+// label returns host if it is (or can become) one of the first max hosts,
+// otherwise "other"; with max <= 0 per-host metrics are disabled
+func (l *hostLimiter) label(host string, max int) (string, bool) {
+	if max <= 0 {
+		return "", false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.seen[host]; ok {
+		return host, true
+	}
+	if len(l.seen) >= max {
+		return otherHost, true
+	}
+	if l.seen == nil {
+		l.seen = make(map[string]struct{})
+	}
+	l.seen[host] = struct{}{}
+	return host, true
+}
+
+// observeRequest records metrics for a completed request
+func (m *proxyMetrics) observeRequest(rec *requestRecord, maxHosts int) {
+	status := strconv.Itoa(rec.status)
+
+	m.requests.With(methodLabel(rec.method), status, rec.scheme).Inc()
+	m.responseBytes.With(rec.scheme, rec.cache).Add(float64(rec.bytes))
+	if rec.ttfb > 0 {
+		m.ttfb.With(rec.scheme).Observe(rec.ttfb.Seconds())
+	}
+	// Upgraded connections last as long as the session; keep them out of
+	// the latency distribution
+	if rec.status != http.StatusSwitchingProtocols {
+		m.duration.With(rec.scheme, rec.cache).Observe(rec.duration.Seconds())
+	}
+	if rec.cache == "hit" || rec.cache == "revalidated" {
+		m.cacheServed.Add(float64(rec.bytes))
+	}
+
+	if host, ok := m.hosts.label(rec.host, maxHosts); ok {
+		m.hostRequests.With(host, status[:1]+"xx").Inc()
+		m.hostBytes.With(host).Add(float64(rec.bytes))
+	}
+}
+
+// ConnState tracks open client connections; set it as http.Server.ConnState
+func (p *ProxyServer) ConnState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		p.metrics.connections.Inc()
+	case http.StateHijacked, http.StateClosed:
+		p.metrics.connections.Dec()
+	}
+}
+
+// Metrics returns the registry served on /metrics
+func (p *ProxyServer) Metrics() *metrics.Registry {
+	return p.metrics.registry
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsRecordRequests(t *testing.T) {
+	server, err := NewProxyServer()
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	defer server.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("hello"))
+	}))
+	defer backend.Close()
+
+	proxy := httptest.NewServer(server)
+	defer proxy.Close()
+
+	// Miss, then hit
+	for i := 0; i < 2; i++ {
+		resp, err := proxyClient(proxy).Get(backend.URL + "/page")
+		if err != nil {
+			t.Fatalf("Request through proxy failed: %v", err)
+		}
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	var buf bytes.Buffer
+	server.Metrics().WriteText(&buf)
+	out := buf.String()
+
+	for _, want := range []string{
+		`cheburnet_requests_total{method="GET",status="200",scheme="http"} 2`,
+		`cheburnet_response_bytes_total{scheme="http",cache="hit"} 5`,
+		`cheburnet_response_bytes_total{scheme="http",cache="miss"} 5`,
+		`cheburnet_upstream_ttfb_seconds_count{scheme="http"} 1`,
+		`cheburnet_host_requests_total{host="127.0.0.1",code="2xx"} 2`,
+		`cheburnet_cache_hits_total 1`,
+		`cheburnet_cache_stored_bytes_total 5`,
+		`cheburnet_cache_served_bytes_total 5`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("Metrics missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestHostLimiter(t *testing.T) {
+	var l hostLimiter
+
+	if _, ok := l.label("a.example", 0); ok {
+		t.Error("max 0 should disable host labels")
+	}
+
+	for _, tt := range []struct{ host, want string }{
+		{"a.example", "a.example"},
+		{"b.example", "b.example"},
+		{"c.example", otherHost},
+		{"a.example", "a.example"},
+	} {
+		if got, _ := l.label(tt.host, 2); got != tt.want {
+			t.Errorf("label(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestMethodLabel(t *testing.T) {
+	for method, want := range map[string]string{
+		"GET":           "GET",
+		"CONNECT":       "CONNECT",
+		"PATCH":         "PATCH",
+		"get":           otherMethod,
+		"PROPFIND":      otherMethod,
+		"X-RANDOM-1234": otherMethod,
+	} {
+		if got := methodLabel(method); got != want {
+			t.Errorf("methodLabel(%q) = %q, want %q", method, got, want)
+		}
+	}
+}
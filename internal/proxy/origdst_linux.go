@@ -0,0 +1,96 @@
+//go:build linux
+
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// soOriginalDst is SO_ORIGINAL_DST / IP6T_SO_ORIGINAL_DST from netfilter
+const soOriginalDst = 80
+
+// originalDst returns the pre-NAT destination (host:port) of conn
+func originalDst(conn net.Conn) (string, error) {
+	if tc, ok := conn.(*tproxyConn); ok {
+		return tc.LocalAddr().String(), nil
+	}
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return "", errors.New("original destination requires a TCP connection")
+	}
+
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+
+	isIPv6 := false
+	if addr, ok := tcpConn.LocalAddr().(*net.TCPAddr); ok && addr.IP.To4() == nil {
+		isIPv6 = true
+	}
+
+	var dst string
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		dst, sockErr = getsockoptOriginalDst(int(fd), isIPv6)
+	})
+	if err != nil {
+		return "", err
+	}
+	if sockErr != nil {
+		return "", fmt.Errorf("SO_ORIGINAL_DST: %w", sockErr)
+	}
+
+	return dst, nil
+}
+
+// getsockoptOriginalDst reads the sockaddr stored by netfilter. x/sys has
+// no generic getsockopt, so the sockaddr is read through structures of
+// matching size: sockaddr_in fits IPv6Mreq, sockaddr_in6 fits IPv6MTUInfo.
+func getsockoptOriginalDst(fd int, isIPv6 bool) (string, error) {
+	if isIPv6 {
+		info, err := unix.GetsockoptIPv6MTUInfo(fd, unix.SOL_IPV6, soOriginalDst)
+		if err != nil {
+			return "", err
+		}
+		// Port is stored in network byte order regardless of host endianness
+		portBytes := (*[2]byte)(unsafe.Pointer(&info.Addr.Port))
+		port := int(portBytes[0])<<8 | int(portBytes[1])
+		return net.JoinHostPort(net.IP(info.Addr.Addr[:]).String(), strconv.Itoa(port)), nil
+	}
+
+	mreq, err := unix.GetsockoptIPv6Mreq(fd, unix.SOL_IP, soOriginalDst)
+	if err != nil {
+		return "", err
+	}
+	// struct sockaddr_in: family(2) port(2, big endian) addr(4)
+	port := int(mreq.Multiaddr[2])<<8 | int(mreq.Multiaddr[3])
+	ip := net.IPv4(mreq.Multiaddr[4], mreq.Multiaddr[5], mreq.Multiaddr[6], mreq.Multiaddr[7])
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port)), nil
+}
+
+// transparentControl sets IP_TRANSPARENT on the listening socket for TPROXY
+func transparentControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+		if sockErr == nil && network == "tcp6" {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("IP_TRANSPARENT: %w", sockErr)
+	}
+	return nil
+}
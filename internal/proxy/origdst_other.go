@@ -0,0 +1,25 @@
+//go:build !linux
+
+package proxy
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// errTransparentUnsupported is returned on platforms without netfilter
+var errTransparentUnsupported = errors.New("transparent proxy mode is only supported on Linux")
+
+// originalDst returns the pre-NAT destination (host:port) of conn
+func originalDst(conn net.Conn) (string, error) {
+	if tc, ok := conn.(*tproxyConn); ok {
+		return tc.LocalAddr().String(), nil
+	}
+	return "", errTransparentUnsupported
+}
+
+// transparentControl sets IP_TRANSPARENT on the listening socket for TPROXY
+func transparentControl(network, address string, c syscall.RawConn) error {
+	return errTransparentUnsupported
+}
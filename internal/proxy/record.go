@@ -0,0 +1,322 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/onixus/4ebur-net/internal/cache"
+	"github.com/onixus/4ebur-net/internal/config"
+	"github.com/onixus/4ebur-net/internal/har"
+	"github.com/onixus/4ebur-net/internal/logger"
+	"github.com/onixus/4ebur-net/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestRecord collects what is known about one proxied request for
+// metrics, tracing and the access log
+type requestRecord struct {
+	id         string
+	tunnelID   string
+	start      time.Time
+	duration   time.Duration
+	method     string
+	scheme     string
+	host       string
+	url        string
+	client     string
+	user       string
+	userAgent  string
+	referer    string
+	status     int
+	bytesIn    int64 // updated atomically while the request body is forwarded
+	bytes      int64
+	cache      string // "hit", "miss", "revalidated", "fixture" or "fixture_miss"
+	ctype      string // response Content-Type
+	ttfb       time.Duration
+	tls        *logger.TLSInfo
+	err        error
+	span       trace.Span
+	har        *har.Exchange  // nil unless a HAR capture matches
+	fixtureKey string         // set when recording or replaying
+	log        *logger.Logger // carries request_id
+}
+
+// beginRequest starts a record for r, sent to host; the request body is
+// wrapped to count the bytes forwarded upstream
+func (p *ProxyServer) beginRequest(r *http.Request, scheme, host string) *requestRecord {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	rec := &requestRecord{
+		id:        newRequestID(),
+		start:     time.Now(),
+		method:    r.Method,
+		scheme:    scheme,
+		host:      host,
+		url:       r.URL.String(),
+		client:    r.RemoteAddr,
+		user:      proxyUser(r.Header),
+		userAgent: r.UserAgent(),
+		referer:   r.Referer(),
+		cache:     "miss",
+	}
+	rec.log = p.logger.WithField("request_id", rec.id)
+
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &countingBody{ReadCloser: r.Body, n: &rec.bytesIn}
+	}
+	rec.har = p.har.Begin(r, r.RemoteAddr)
+	return rec
+}
+
+// headersReceived marks the arrival of upstream response headers
+func (rec *requestRecord) headersReceived(resp *http.Response) {
+	rec.ttfb = time.Since(rec.start)
+	rec.status = resp.StatusCode
+	rec.ctype = resp.Header.Get("Content-Type")
+	rec.har.Response(resp)
+}
+
+// servedFromCache marks the request as answered with entry
+func (rec *requestRecord) servedFromCache(entry *cache.CacheEntry) {
+	if rec.cache == "miss" { // replays are already marked
+		rec.cache = "hit"
+	}
+	rec.status = entry.StatusCode
+	rec.bytes = entry.Size
+	rec.ctype = entry.Headers.Get("Content-Type")
+	rec.har.Cached(entry.StatusCode, entry.Headers, entry.Body)
+}
+
+// notModifiedFromCache marks the request as answered with a 304 for entry
+func (rec *requestRecord) notModifiedFromCache(entry *cache.CacheEntry) {
+	rec.cache = "hit"
+	rec.status = http.StatusNotModified
+	rec.bytes = 0
+	rec.ctype = entry.Headers.Get("Content-Type")
+	rec.har.Cached(http.StatusNotModified, entry.NotModifiedHeader(), nil)
+}
+
+// finishRequest records metrics, ends the span and writes the access log
+// line for a completed request
+func (p *ProxyServer) finishRequest(rec *requestRecord) {
+	rec.duration = time.Since(rec.start)
+	if rec.status == 0 {
+		rec.status = http.StatusBadGateway
+	}
+
+	p.metrics.observeRequest(rec, p.current().metricsMaxHosts)
+
+	if rec.span != nil {
+		rec.span.SetAttributes(
+			attribute.Int("http.response.status_code", rec.status),
+			attribute.String("cache.result", rec.cache),
+			attribute.Int64("http.response.body.size", rec.bytes))
+		if rec.err != nil {
+			tracing.SetError(rec.span, p.redactedError(rec.err))
+		} else if rec.status >= 500 {
+			tracing.SetError(rec.span, fmt.Errorf("status %d", rec.status))
+		}
+		rec.span.End()
+	}
+
+	rec.har.Finish(rec.ttfb, rec.duration, atomic.LoadInt64(&rec.bytesIn), rec.bytes, rec.tunnelID, rec.err)
+
+	entry := &logger.AccessEntry{
+		RequestID:   rec.id,
+		TunnelID:    rec.tunnelID,
+		ClientAddr:  rec.client,
+		User:        rec.user,
+		Method:      rec.method,
+		URL:         rec.url,
+		Host:        rec.host,
+		Scheme:      rec.scheme,
+		Status:      rec.status,
+		BytesIn:     atomic.LoadInt64(&rec.bytesIn),
+		BytesOut:    rec.bytes,
+		Duration:    rec.duration,
+		Upstream:    rec.ttfb,
+		Cache:       rec.cache,
+		ContentType: rec.ctype,
+		Referer:     rec.referer,
+		UserAgent:   rec.userAgent,
+		TLS:         rec.tls,
+	}
+	if rec.span != nil && p.provider != nil {
+		entry.TraceID = rec.span.SpanContext().TraceID().String()
+	}
+	if rec.err != nil {
+		entry.Error = rec.err.Error()
+	}
+	if p.accessLog != nil {
+		p.accessLog.LogAccess(entry)
+	} else {
+		p.logger.LogAccess(entry)
+	}
+}
+
+// tunnelRecord collects what is known about a CONNECT or transparent TLS
+// connection; requests inside it refer to its id
+type tunnelRecord struct {
+	id     string
+	kind   string
+	start  time.Time
+	client string
+	user   string
+	host   string
+	tls    *logger.TLSInfo
+	err    error
+}
+
+// beginTunnel starts a record for a tunnel from client to host
+func (p *ProxyServer) beginTunnel(kind, client, user, host string) *tunnelRecord {
+	return &tunnelRecord{id: newRequestID(), kind: kind, start: time.Now(), client: client, user: user, host: host}
+}
+
+// finishTunnel writes the tunnel log line
+func (p *ProxyServer) finishTunnel(t *tunnelRecord) {
+	entry := &logger.TunnelEntry{
+		TunnelID:   t.id,
+		Kind:       t.kind,
+		ClientAddr: t.client,
+		User:       t.user,
+		Host:       t.host,
+		Duration:   time.Since(t.start),
+		TLS:        t.tls,
+	}
+	if t.err != nil {
+		entry.Error = t.err.Error()
+	}
+	if p.accessLog != nil {
+		p.accessLog.LogTunnel(entry)
+	} else {
+		p.logger.LogTunnel(entry)
+	}
+}
+
+// logDenied logs a request or connection refused by the ACL
+func (p *ProxyServer) logDenied(client, host string, err error) {
+	p.logger.WithFields(map[string]interface{}{
+		"client_addr": client,
+		"host":        host,
+		"error":       err.Error(),
+	}).Warn("acl_denied")
+}
+
+// newAccessLog opens the configured access log; it returns nil for JSON
+// on stdout, which the structured logger already writes
+func newAccessLog(cfg config.AccessLogConfig, redactor *logger.Redactor) (*logger.AccessLog, error) {
+	if cfg.Format == logger.FormatJSON && (cfg.File == "" || cfg.File == "stdout") {
+		return nil, nil
+	}
+	accessLog, err := logger.NewAccessLog(logger.AccessLogOptions{
+		Format:         cfg.Format,
+		Template:       cfg.Template,
+		File:           cfg.File,
+		MaxSizeMB:      cfg.MaxSizeMB,
+		MaxBackups:     cfg.MaxBackups,
+		MaxAgeDays:     cfg.MaxAgeDays,
+		Compress:       cfg.Compress,
+		RotateInterval: cfg.RotateInterval.Std(),
+		Redactor:       redactor,
+	})
+	if err != nil {
+		return nil, err
+	}
+	destination := cfg.File
+	if destination == "" {
+		destination = "stdout"
+	}
+	log.Printf("📝 Access log: %s format to %s", cfg.Format, destination)
+	return accessLog, nil
+}
+
+// newRedactor builds the secret redactor from the built-in rules plus the
+// configured ones; it returns nil when redaction is off
+func newRedactor(cfg config.RedactConfig) (*logger.Redactor, error) {
+	return logger.NewRedactor(logger.RedactOptions{
+		Mode:        cfg.Mode,
+		HashKey:     cfg.HashKey,
+		Headers:     append(append([]string(nil), logger.DefaultRedactHeaders...), cfg.Headers...),
+		QueryParams: append(append([]string(nil), logger.DefaultRedactQueryParams...), cfg.QueryParams...),
+		Patterns:    append(append([]string(nil), logger.DefaultRedactPatterns...), cfg.Patterns...),
+	})
+}
+
+// redactedError returns err with secrets removed from its message, for
+// errors leaving the proxy in responses and spans
+func (p *ProxyServer) redactedError(err error) error {
+	if p.redactor == nil || err == nil {
+		return err
+	}
+	return errors.New(p.redactor.Error(err))
+}
+
+// SetLogger replaces the structured logger; call it before serving
+func (p *ProxyServer) SetLogger(l *logger.Logger) {
+	p.logger = l.WithRedactor(p.redactor)
+}
+
+// HAR returns the recorder behind the /har/ admin endpoints
+func (p *ProxyServer) HAR() *har.Recorder {
+	return p.har
+}
+
+// newRequestID returns a random 64-bit hex ID
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// proxyUser returns the user name from Basic Proxy-Authorization, if any
+func proxyUser(h http.Header) string {
+	scheme, credentials, ok := strings.Cut(h.Get("Proxy-Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return ""
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if err != nil {
+		return ""
+	}
+	user, _, _ := strings.Cut(string(decoded), ":")
+	return user
+}
+
+// tlsInfo describes the client side of an intercepted connection
+func tlsInfo(cs tls.ConnectionState) *logger.TLSInfo {
+	return &logger.TLSInfo{
+		Version:    tls.VersionName(cs.Version),
+		Cipher:     tls.CipherSuiteName(cs.CipherSuite),
+		ServerName: cs.ServerName,
+		ALPN:       cs.NegotiatedProtocol,
+	}
+}
+
+// countingBody counts bytes read from a body
+type countingBody struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(b.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,268 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/onixus/4ebur-net/internal/har"
+	"github.com/onixus/4ebur-net/internal/logger"
+)
+
+// logBuffer collects JSON log lines written concurrently by handlers
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// lines returns decoded log lines whose message is msg
+func (b *logBuffer) lines(t *testing.T, msg string) []map[string]interface{} {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var out []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("Invalid log line %q: %v", line, err)
+		}
+		if fields["message"] == msg {
+			out = append(out, fields)
+		}
+	}
+	return out
+}
+
+func newLoggedProxy(t *testing.T) (*ProxyServer, *logBuffer) {
+	t.Setenv("LOG_FORMAT", "json")
+	t.Setenv("LOG_LEVEL", "info")
+
+	server, err := NewProxyServer()
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	logs := &logBuffer{}
+	server.SetLogger(logger.NewLoggerWithWriter(logs))
+	return server, logs
+}
+
+func TestAccessLogFields(t *testing.T) {
+	server, logs := newLoggedProxy(t)
+	defer server.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("created"))
+	}))
+	defer backend.Close()
+
+	proxy := httptest.NewServer(server)
+
+	req, _ := http.NewRequest(http.MethodPost, backend.URL+"/items", strings.NewReader("payload"))
+	req.Header.Set("Proxy-Authorization", "Basic YWxpY2U6c2VjcmV0") // alice:secret
+	req.Header.Set("User-Agent", "logtest/1.0")
+	resp, err := proxyClient(proxy).Do(req)
+	if err != nil {
+		t.Fatalf("Request through proxy failed: %v", err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	proxy.Close() // waits for the handler to finish logging
+
+	lines := logs.lines(t, "access")
+	if len(lines) != 1 {
+		t.Fatalf("Expected one access log line, got %d", len(lines))
+	}
+	entry := lines[0]
+	for key, want := range map[string]interface{}{
+		"method":     "POST",
+		"host":       "127.0.0.1",
+		"scheme":     "http",
+		"user":       "alice",
+		"user_agent": "logtest/1.0",
+		"status":     float64(200),
+		"bytes_in":   float64(len("payload")),
+		"bytes_out":  float64(len("created")),
+		"cache":      "miss",
+	} {
+		if entry[key] != want {
+			t.Errorf("%s: expected %v, got %v", key, want, entry[key])
+		}
+	}
+	if id, _ := entry["request_id"].(string); len(id) != 16 {
+		t.Errorf("Expected a 16 character request_id, got %v", entry["request_id"])
+	}
+	if addr, _ := entry["client_addr"].(string); !strings.HasPrefix(addr, "127.0.0.1:") {
+		t.Errorf("Unexpected client_addr %v", entry["client_addr"])
+	}
+	if _, ok := entry["upstream_latency_ms"]; !ok {
+		t.Error("Missing upstream_latency_ms")
+	}
+}
+
+func TestAccessLogRedactsSecrets(t *testing.T) {
+	server, logs := newLoggedProxy(t)
+	defer server.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	proxy := httptest.NewServer(server)
+
+	resp, err := proxyClient(proxy).Get(backend.URL + "/data?api_key=hunter2&page=1")
+	if err != nil {
+		t.Fatalf("Request through proxy failed: %v", err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	proxy.Close()
+
+	lines := logs.lines(t, "access")
+	if len(lines) != 1 {
+		t.Fatalf("Expected one access log line, got %d", len(lines))
+	}
+	if got, want := lines[0]["url"], backend.URL+"/data?api_key=[REDACTED]&page=1"; got != want {
+		t.Errorf("Expected url %q, got %v", want, got)
+	}
+}
+
+func TestHARCaptureThroughProxy(t *testing.T) {
+	server, _ := newLoggedProxy(t)
+	defer server.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte(`{"echo":"` + string(body) + `"}`))
+	}))
+	defer backend.Close()
+
+	capture, err := server.HAR().Start(har.Options{Filter: har.Filter{Paths: []string{"/api/**"}}})
+	if err != nil {
+		t.Fatalf("Start capture: %v", err)
+	}
+
+	proxy := httptest.NewServer(server)
+	client := proxyClient(proxy)
+	for _, target := range []string{"/api/items", "/other"} {
+		resp, err := client.Post(backend.URL+target, "text/plain", strings.NewReader("ping"))
+		if err != nil {
+			t.Fatalf("Request through proxy failed: %v", err)
+		}
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	proxy.Close()
+
+	entries := capture.HAR().Log.Entries
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 captured entry, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Request.Method != http.MethodPost || e.Request.URL != backend.URL+"/api/items" {
+		t.Errorf("Unexpected request %s %s", e.Request.Method, e.Request.URL)
+	}
+	if e.Request.PostData == nil || e.Request.PostData.Text != "ping" || e.Request.BodySize != 4 {
+		t.Errorf("Unexpected request body %+v (size %d)", e.Request.PostData, e.Request.BodySize)
+	}
+	if e.Response.Status != http.StatusOK || e.Response.Content.Text != `{"echo":"ping"}` || e.Response.Content.MimeType != "application/json" {
+		t.Errorf("Unexpected response %+v", e.Response)
+	}
+}
+
+func TestAccessLogTunnel(t *testing.T) {
+	server, logs := newLoggedProxy(t)
+	defer server.Close()
+
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure"))
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	proxy := httptest.NewServer(server)
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("CONNECT " + backendURL.Host + " HTTP/1.1\r\nHost: " + backendURL.Host + "\r\n\r\n"))
+	if resp, err := http.ReadResponse(bufio.NewReader(conn), nil); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT failed: %v", err)
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, ServerName: "secure.test"})
+	tlsConn.Write([]byte("GET /secure HTTP/1.1\r\nHost: " + backendURL.Host + "\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(tlsConn), nil)
+	if err != nil {
+		t.Fatalf("Request through tunnel failed: %v", err)
+	}
+	io.ReadAll(resp.Body)
+	tlsConn.Close()
+
+	// Wait for the tunnel to close so both lines are written
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
+
+	tunnels := logs.lines(t, "tunnel")
+	access := logs.lines(t, "access")
+	if len(tunnels) != 1 || len(access) != 1 {
+		t.Fatalf("Expected one tunnel and one access line, got %d and %d", len(tunnels), len(access))
+	}
+	tunnel, entry := tunnels[0], access[0]
+
+	if tunnel["kind"] != "connect" || tunnel["tls_sni"] != "secure.test" || tunnel["tls_version"] == nil {
+		t.Errorf("Unexpected tunnel line %v", tunnel)
+	}
+	if entry["tunnel_id"] != tunnel["tunnel_id"] {
+		t.Errorf("Access line should reference tunnel %v, got %v", tunnel["tunnel_id"], entry["tunnel_id"])
+	}
+	if entry["scheme"] != "https" || entry["client_addr"] != tunnel["client_addr"] || entry["tls_cipher"] == nil {
+		t.Errorf("Access line missing tunnel details: %v", entry)
+	}
+}
+
+func TestProxyUser(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"Basic YWxpY2U6c2VjcmV0", "alice"},
+		{"basic Ym9i", "bob"},
+		{"Bearer token", ""},
+		{"Basic !!!", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		h.Set("Proxy-Authorization", tt.header)
+		if got := proxyUser(h); got != tt.want {
+			t.Errorf("proxyUser(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
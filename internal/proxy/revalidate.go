@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/onixus/4ebur-net/internal/cache"
+)
+
+// writeCached answers r with entry, or with 304 Not Modified when r's own
+// conditions hold for it. It reports false, with nothing written, if a
+// body kept on disk was evicted since the lookup.
+func (p *ProxyServer) writeCached(w http.ResponseWriter, r *http.Request, rec *requestRecord, entry *cache.CacheEntry) bool {
+	if p.fixtures == nil && entry.NotModified(r) {
+		entry.WriteNotModified(w)
+		rec.notModifiedFromCache(entry)
+		return true
+	}
+	err := entry.WriteToResponse(w)
+	if errors.Is(err, cache.ErrBodyGone) {
+		return false
+	}
+	rec.servedFromCache(entry)
+	rec.err = err
+	return true
+}
+
+// writeCachedTLS is writeCached for a request read from an intercepted
+// TLS connection
+func (p *ProxyServer) writeCachedTLS(conn net.Conn, req *http.Request, rec *requestRecord, entry *cache.CacheEntry) bool {
+	resp := &http.Response{
+		StatusCode: entry.StatusCode,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Request:    req,
+	}
+	if p.fixtures == nil && entry.NotModified(req) {
+		resp.StatusCode = http.StatusNotModified
+		resp.Header = entry.NotModifiedHeader()
+		resp.Header.Set("Age", entry.ResponseHeader().Get("Age"))
+		rec.notModifiedFromCache(entry)
+	} else {
+		body, err := entry.OpenBody()
+		if err != nil {
+			return false
+		}
+		defer body.Close()
+		resp.Header, resp.Body, resp.ContentLength = entry.ResponseHeader(), body, entry.Size
+		rec.servedFromCache(entry)
+	}
+
+	bw := bufio.NewWriter(conn)
+	if err := resp.Write(bw); err != nil {
+		rec.err = err
+	} else if err := bw.Flush(); err != nil {
+		rec.err = err
+	}
+	return true
+}
+
+// writeNotCachedTLS answers an only-if-cached request with nothing cached
+// for it with 504 Gateway Timeout, as RFC 9111 section 5.2.1.7 asks
+func writeNotCachedTLS(conn net.Conn, req *http.Request, rec *requestRecord) {
+	rec.status = http.StatusGatewayTimeout
+	resp := &http.Response{
+		StatusCode: http.StatusGatewayTimeout,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Request:    req,
+		Header:     http.Header{"Content-Length": {"0"}},
+	}
+	if err := resp.Write(conn); err != nil {
+		rec.err = err
+	}
+}
+
+// canRevalidate reports whether entry, which lookupCache found unusable
+// for r as is, is revalidated upstream rather than fetched again
+func (p *ProxyServer) canRevalidate(r *http.Request, entry *cache.CacheEntry, found bool) bool {
+	return p.fixtures == nil && entry != nil && !found && entry.Revalidatable() && cache.CanRevalidate(r)
+}
+
+// revalidate sends req upstream made conditional on the validators of the
+// stale entry. When the origin answers 304 Not Modified it returns the
+// refreshed entry instead of a response.
+func (p *ProxyServer) revalidate(rec *requestRecord, req *http.Request, stale *cache.CacheEntry) (*http.Response, *cache.CacheEntry, error) {
+	conditional := req.Clone(req.Context())
+	stale.AddValidators(conditional.Header)
+
+	resp, err := p.roundTrip(conditional)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		p.metrics.revalidations.With("modified").Inc()
+		return resp, nil, nil
+	}
+	resp.Body.Close()
+	rec.headersReceived(resp)
+	p.metrics.revalidations.With("not_modified").Inc()
+	return nil, stale.Revalidated(resp, p.current().cacheMaxAge), nil
+}
+
+// storeRevalidated puts entry, refreshed by a 304, back into the cache.
+// It runs once the response was served: a body streamed from disk is
+// copied from the very file that storing replaces.
+func (p *ProxyServer) storeRevalidated(ctx context.Context, rec *requestRecord, key string, entry *cache.CacheEntry) {
+	rec.cache = "revalidated"
+	if err := p.cache.SetContext(ctx, key, entry); err != nil && !errors.Is(err, cache.ErrNotAdmitted) {
+		rec.log.Warn("not refreshing " + entry.URL + " in the cache: " + err.Error())
+		return
+	}
+	rec.log.LogCacheOperation("revalidate", key, true, entry.Size)
+}
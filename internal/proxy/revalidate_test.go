@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+// validatingBackend serves a body with an ETag, answering matching
+// If-None-Match requests with 304
+func validatingBackend(useTLS bool, cacheControl string, full, notModified *int32) *httptest.Server {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", cacheControl)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(notModified, 1)
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(full, 1)
+		w.Write([]byte("body"))
+	})
+	if useTLS {
+		return httptest.NewTLSServer(handler)
+	}
+	return httptest.NewServer(handler)
+}
+
+func TestStaleEntryRevalidated(t *testing.T) {
+	for _, mitm := range []bool{false, true} {
+		var full, notModified int32
+		// max-age=0: stored, but stale right away
+		backend := validatingBackend(mitm, "max-age=0", &full, &notModified)
+
+		server, err := NewProxyServer()
+		if err != nil {
+			t.Fatalf("Failed to create proxy server: %v", err)
+		}
+		proxy := httptest.NewServer(server)
+		proxyURL, _ := url.Parse(proxy.URL)
+		client := &http.Client{Transport: &http.Transport{
+			Proxy:             http.ProxyURL(proxyURL),
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		}}
+
+		for i := 0; i < 3; i++ {
+			resp, err := client.Get(backend.URL + "/asset")
+			if err != nil {
+				t.Fatalf("mitm=%v request %d: %v", mitm, i, err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || string(body) != "body" {
+				t.Errorf("mitm=%v request %d: status %d body %q", mitm, i, resp.StatusCode, body)
+			}
+		}
+
+		// The second request revalidated; the 304 made the entry fresh for
+		// the third
+		if full != 1 || notModified != 1 {
+			t.Errorf("mitm=%v: expected 1 full response and 1 revalidation, got %d and %d", mitm, full, notModified)
+		}
+
+		proxy.Close()
+		backend.Close()
+		server.Close()
+	}
+}
+
+func TestRevalidationFetchesChangedBody(t *testing.T) {
+	var version atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf(`"v%d"`, version.Load())
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "max-age=0")
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(etag))
+	}))
+	defer backend.Close()
+
+	server, err := NewProxyServer()
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	defer server.Close()
+	proxy := httptest.NewServer(server)
+	defer proxy.Close()
+
+	get := func() string {
+		t.Helper()
+		resp, err := proxyClient(proxy).Get(backend.URL + "/changing")
+		if err != nil {
+			t.Fatalf("Request through proxy failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	get()
+	version.Store(1)
+	if body := get(); body != `"v1"` {
+		t.Errorf("Expected the changed body, got %s", body)
+	}
+	if body := get(); body != `"v1"` {
+		t.Errorf("Expected the changed body to be cached, got %s", body)
+	}
+}
+
+func TestClientConditionalAnsweredFromCache(t *testing.T) {
+	var full, notModified int32
+	backend := validatingBackend(false, "max-age=60", &full, &notModified)
+	defer backend.Close()
+
+	server, err := NewProxyServer()
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	defer server.Close()
+	proxy := httptest.NewServer(server)
+	defer proxy.Close()
+	client := proxyClient(proxy)
+
+	get := func(ifNoneMatch string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, backend.URL+"/asset", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request through proxy failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	get("")
+	resp, body := get(`"v0", W/"v1"`)
+	if resp.StatusCode != http.StatusNotModified || body != "" {
+		t.Errorf("Expected an empty 304, got %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("ETag") != `"v1"` || resp.Header.Get("X-Cache") != "HIT" {
+		t.Errorf("Unexpected 304 headers %v", resp.Header)
+	}
+
+	resp, body = get(`"v0"`)
+	if resp.StatusCode != http.StatusOK || body != "body" || resp.Header.Get("X-Cache") != "HIT" {
+		t.Errorf("Expected the cached body, got %d %q", resp.StatusCode, body)
+	}
+	if full != 1 || notModified != 0 {
+		t.Errorf("Expected a single upstream request, got %d full and %d 304", full, notModified)
+	}
+}
+
+func TestRequestCacheDirectives(t *testing.T) {
+	var full, notModified int32
+	backend := validatingBackend(false, "max-age=60", &full, &notModified)
+	defer backend.Close()
+
+	server, err := NewProxyServer()
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	defer server.Close()
+	proxy := httptest.NewServer(server)
+	defer proxy.Close()
+	client := proxyClient(proxy)
+
+	get := func(cacheControl string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, backend.URL+"/asset", nil)
+		req.Header.Set("Cache-Control", cacheControl)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request through proxy failed: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := get("only-if-cached"); resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("Expected 504 for an uncached only-if-cached request, got %d", resp.StatusCode)
+	}
+	if full != 0 {
+		t.Fatal("only-if-cached must not contact the origin")
+	}
+
+	get("")
+	if resp := get("only-if-cached"); resp.StatusCode != http.StatusOK || resp.Header.Get("X-Cache") != "HIT" {
+		t.Errorf("Expected a cache hit, got %d %s", resp.StatusCode, resp.Header.Get("X-Cache"))
+	} else if resp.Header.Get("Age") == "" {
+		t.Error("Cached responses should carry an Age header")
+	}
+
+	// A fresh entry is revalidated when the client asks for no-cache
+	if resp := get("no-cache"); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 after revalidation, got %d", resp.StatusCode)
+	}
+	if full != 1 || notModified != 1 {
+		t.Errorf("Expected 1 full response and 1 revalidation, got %d and %d", full, notModified)
+	}
+}
@@ -2,51 +2,127 @@ package proxy
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
-	"os"
-	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/onixus/4ebur-net/internal/cache"
 	"github.com/onixus/4ebur-net/internal/cert"
+	"github.com/onixus/4ebur-net/internal/config"
+	"github.com/onixus/4ebur-net/internal/har"
+	"github.com/onixus/4ebur-net/internal/logger"
+	"github.com/onixus/4ebur-net/internal/tracing"
 	"github.com/onixus/4ebur-net/pkg/pool"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ProxyServer is the main MITM proxy server
 type ProxyServer struct {
 	certManager *cert.CertManager
 	transport   *http.Transport
-	httpCache   *cache.HTTPCache
-	cacheMaxAge time.Duration
+	cache       *cache.TieredCache
+	config      *config.Config
+	settings    atomic.Pointer[runtimeSettings]
+	wsFrameHook WebSocketFrameHook
+	tunnels     *connTracker
+	stores      *storeTracker
+	metrics     *proxyMetrics
+	tracer      trace.Tracer
+	provider    *sdktrace.TracerProvider // nil: tracing disabled
+	logger      *logger.Logger
+	accessLog   *logger.AccessLog // nil: access lines go to logger
+	redactor    *logger.Redactor
+	har         *har.Recorder
+	fixtures    *fixtures // nil unless recording or replaying
+	draining    atomic.Bool
 	mu          sync.RWMutex
+
+	transparentServers []*transparentServer
 }
 
-// NewProxyServer creates a new proxy server instance
+// NewProxyServer creates a new proxy server instance configured from
+// environment variables
 func NewProxyServer() (*ProxyServer, error) {
-	certMgr, err := cert.NewCertManager()
+	cfg, err := config.Load("")
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return NewProxyServerWithConfig(cfg)
+}
+
+// NewProxyServerWithConfig creates a new proxy server instance from cfg
+func NewProxyServerWithConfig(cfg *config.Config) (*ProxyServer, error) {
+	var certMgr *cert.CertManager
+	var err error
+	if cfg.Cert.CAFile != "" {
+		certMgr, err = cert.NewCertManagerFromFiles(cfg.Cert.CAFile, cfg.Cert.KeyFile)
+	} else {
+		certMgr, err = cert.NewCertManager()
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create cert manager: %w", err)
 	}
 
-	// Get configuration from environment
-	maxIdleConns := getEnvInt("MAX_IDLE_CONNS", 1000)
-	maxIdleConnsPerHost := getEnvInt("MAX_IDLE_CONNS_PER_HOST", 100)
-	maxConnsPerHost := getEnvInt("MAX_CONNS_PER_HOST", 100)
-	cacheSize := getEnvInt64("CACHE_SIZE_MB", 100) * 1024 * 1024
-	cacheMaxAge := getEnvDuration("CACHE_MAX_AGE", 5*time.Minute)
+	settings, err := newRuntimeSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tracer, provider, err := newTracer(cfg.Tracing)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tracing configuration: %w", err)
+	}
+
+	redactor, err := newRedactor(cfg.Redact)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redaction configuration: %w", err)
+	}
+
+	accessLog, err := newAccessLog(cfg.AccessLog, redactor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access log configuration: %w", err)
+	}
+
+	fixtures, err := newFixtures(cfg.Fixtures)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fixtures configuration: %w", err)
+	}
+
+	p := &ProxyServer{
+		certManager: certMgr,
+		cache:       newResponseCache(cfg.Cache, settings.cacheMaxAge),
+		config:      cfg,
+		tunnels:     newConnTracker(),
+		stores:      newStoreTracker(),
+		tracer:      tracer,
+		provider:    provider,
+		logger:      logger.NewLogger().WithRedactor(redactor),
+		accessLog:   accessLog,
+		redactor:    redactor,
+		har:         har.NewRecorder(redactor),
+		fixtures:    fixtures,
+	}
+	p.settings.Store(settings)
+	p.metrics = newProxyMetrics(p)
+	certMgr.SetGenerateHook(p.metrics.observeCert)
 
 	// Create optimized HTTP transport
-	transport := &http.Transport{
-		MaxIdleConns:        maxIdleConns,
-		MaxIdleConnsPerHost: maxIdleConnsPerHost,
-		MaxConnsPerHost:     maxConnsPerHost,
+	p.transport = &http.Transport{
+		Proxy:               p.upstreamProxy,
+		DialContext:         dialPinned,
+		MaxIdleConns:        cfg.Upstream.MaxIdleConns,
+		MaxIdleConnsPerHost: cfg.Upstream.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     cfg.Upstream.MaxConnsPerHost,
 		IdleConnTimeout:     90 * time.Second,
 		TLSHandshakeTimeout: 10 * time.Second,
 		TLSClientConfig: &tls.Config{
@@ -56,22 +132,25 @@ func NewProxyServer() (*ProxyServer, error) {
 		ForceAttemptHTTP2:  true, // Enable HTTP/2
 	}
 
-	// Create HTTP cache
-	httpCache := cache.NewHTTPCache(cacheSize, cacheMaxAge)
-
 	// Log cache configuration
-	log.Printf("💾 Cache enabled: %dMB, max-age: %v", cacheSize/(1024*1024), cacheMaxAge)
+	log.Printf("💾 Cache enabled (%s): %dMB, max-age: %v, max object: %dMB",
+		p.cacheBackend(), cfg.Cache.SizeMB, settings.cacheMaxAge, cfg.Cache.MaxObjectSizeMB)
 
-	return &ProxyServer{
-		certManager: certMgr,
-		transport:   transport,
-		httpCache:   httpCache,
-		cacheMaxAge: cacheMaxAge,
-	}, nil
+	return p, nil
 }
 
 // ServeHTTP handles incoming HTTP requests
 func (p *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if r.Method != http.MethodConnect && r.URL.Host != "" {
+		host = r.URL.Host
+	}
+	if err := p.checkACL(r.RemoteAddr, host); err != nil {
+		p.logDenied(r.RemoteAddr, host, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	if r.Method == http.MethodConnect {
 		p.handleConnect(w, r)
 	} else {
@@ -81,24 +160,39 @@ func (p *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // handleHTTP handles regular HTTP requests with caching
 func (p *ProxyServer) handleHTTP(w http.ResponseWriter, r *http.Request) {
-	log.Printf("→ %s %s", r.Method, r.URL)
+	rec := p.beginRequest(r, r.URL.Scheme, r.URL.Host)
+	ctx := p.traceRequest(r.Context(), rec, r)
+	defer p.finishRequest(rec)
+
+	upgrade := isUpgradeRequest(r)
 
 	// Try to get from cache
 	cacheKey := cache.GenerateKey(r)
-	if entry, found := p.httpCache.Get(cacheKey); found {
-		log.Printf("💾 Cache HIT: %s", r.URL)
-		if err := entry.WriteToResponse(w); err != nil {
-			log.Printf("✗ Error writing cached response: %v", err)
-		}
+	entry, found := p.lookupCache(ctx, rec, r, cacheKey, upgrade)
+	// A disk entry evicted since the lookup is fetched again instead
+	if found && p.writeCached(w, r, rec, entry) {
+		return
+	}
+	if !found && cache.OnlyIfCached(r) {
+		rec.status = http.StatusGatewayTimeout
+		http.Error(w, "Not in cache", http.StatusGatewayTimeout)
 		return
 	}
 
-	log.Printf("💿 Cache MISS: %s", r.URL)
+	// Per-route timeouts replace the server-wide write timeout; upgraded
+	// connections are long-lived and only end when either side closes
+	rule := p.timeoutsFor(r.URL)
+	if upgrade {
+		rule = TimeoutRule{}
+	}
+	ctx, cancel := upstreamContext(ctx, rule)
+	defer cancel()
 
 	// Create new request to target
-	req, err := http.NewRequest(r.Method, r.URL.String(), r.Body)
+	req, err := http.NewRequestWithContext(ctx, r.Method, r.URL.String(), r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		rec.status = http.StatusBadRequest
+		http.Error(w, p.redactor.Error(err), http.StatusBadRequest)
 		return
 	}
 
@@ -109,22 +203,43 @@ func (p *ProxyServer) handleHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Send request
-	resp, err := p.transport.RoundTrip(req)
+	// Send request; a stale entry is revalidated instead of fetched again
+	var resp *http.Response
+	if p.canRevalidate(r, entry, found) {
+		var refreshed *cache.CacheEntry
+		resp, refreshed, err = p.revalidate(rec, req, entry)
+		if refreshed != nil {
+			if p.writeCached(w, r, rec, refreshed) {
+				p.storeRevalidated(ctx, rec, cacheKey, refreshed)
+				return
+			}
+			// The body left the disk meanwhile: fetch it in full
+			resp, err = p.roundTrip(req)
+		}
+	} else {
+		resp, err = p.roundTrip(req)
+	}
 	if err != nil {
-		log.Printf("✗ Error forwarding request: %v", err)
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		rec.err = err
+		http.Error(w, p.redactor.Error(err), http.StatusBadGateway)
 		return
 	}
 	defer resp.Body.Close()
+	rec.headersReceived(resp)
 
-	// Check if cacheable
-	if cache.IsCacheable(r, resp) {
-		// Create cache entry
-		if entry, err := cache.CreateCacheEntry(resp, p.cacheMaxAge); err == nil {
-			p.httpCache.Set(cacheKey, entry)
-			log.Printf("💾 Cached response for: %s (size: %d bytes)", r.URL, entry.Size)
-		}
+	// Protocol switch (e.g. WebSocket): relay the 101 and splice connections
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		p.handleUpgradeResponse(w, r, resp, rec)
+		return
+	}
+
+	resp.Body = newIdleTimeoutBody(resp.Body, rule.Idle, cancel)
+
+	// Stream to the client while filling the cache or recording
+	if rec.fixtureKey != "" && !isEventStream(resp) {
+		resp.Body = p.newFixtureBody(ctx, rec, r, resp)
+	} else if p.fixtures == nil && cache.IsCacheable(r, resp) && !isEventStream(resp) {
+		resp.Body = p.newFillBody(ctx, rec, resp, cacheKey, r.URL.String())
 	}
 
 	// Copy response headers
@@ -138,21 +253,29 @@ func (p *ProxyServer) handleHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("X-Cache", "MISS")
 	w.WriteHeader(resp.StatusCode)
 
-	// Copy response body with pooled buffer
+	// Copy response body with pooled buffer, flushing each chunk of
+	// event streams and unknown-length bodies
 	buf := pool.GetBuffer()
 	defer pool.PutBuffer(buf)
 
-	_, err = io.CopyBuffer(w, resp.Body, buf.Bytes()[:cap(buf.Bytes())])
+	sw := newStreamWriter(w, isStreamingResponse(resp), rule)
+	rec.bytes, err = io.CopyBuffer(sw, resp.Body, buf.Bytes()[:cap(buf.Bytes())])
 	if err != nil && err != io.EOF {
-		log.Printf("✗ Error copying response: %v", err)
+		rec.err = err
 	}
-
-	log.Printf("← %d %s", resp.StatusCode, r.URL)
 }
 
 // handleConnect handles HTTPS CONNECT requests for MITM
 func (p *ProxyServer) handleConnect(w http.ResponseWriter, r *http.Request) {
-	log.Printf("🔍 CONNECT %s", r.Host)
+	tun := p.beginTunnel("connect", r.RemoteAddr, proxyUser(r.Header), r.Host)
+	defer p.finishTunnel(tun)
+
+	// The span covers tunnel setup up to the end of the MITM handshake;
+	// requests inside the tunnel get their own traces
+	ctx, span := p.tracer.Start(tracing.Extract(r.Context(), r.Header), "CONNECT",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("server.address", r.Host)))
+	defer span.End()
 
 	// Hijack the connection
 	hijacker, ok := w.(http.Hijacker)
@@ -163,15 +286,16 @@ func (p *ProxyServer) handleConnect(w http.ResponseWriter, r *http.Request) {
 
 	clientConn, _, err := hijacker.Hijack()
 	if err != nil {
-		log.Printf("✗ Hijack error: %v", err)
+		tun.err = fmt.Errorf("hijack: %w", err)
 		return
 	}
 	defer clientConn.Close()
+	defer p.tunnels.add(clientConn)()
 
 	// Send 200 Connection Established
 	_, err = clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
 	if err != nil {
-		log.Printf("✗ Failed to send 200: %v", err)
+		tun.err = fmt.Errorf("send 200: %w", err)
 		return
 	}
 
@@ -181,117 +305,243 @@ func (p *ProxyServer) handleConnect(w http.ResponseWriter, r *http.Request) {
 		host = r.Host
 	}
 
-	// Get certificate for host
-	tlsCert, err := p.certManager.GetCertificate(host)
+	tlsConn, err := p.mitmHandshake(ctx, clientConn, host, nil)
 	if err != nil {
-		log.Printf("✗ Failed to get certificate for %s: %v", host, err)
+		tun.err = fmt.Errorf("TLS handshake: %w", err)
+		tracing.SetError(span, err)
 		return
 	}
+	defer tlsConn.Close()
+	span.End()
+
+	tun.tls = tlsInfo(tlsConn.ConnectionState())
+	p.serveTLS(context.Background(), tlsConn, r.Host, tun)
+}
+
+// mitmHandshake terminates TLS on clientConn with a certificate minted for
+// the SNI server name, falling back to host when the client sent none. A
+// non-nil check may reject the name before any certificate is issued.
+func (p *ProxyServer) mitmHandshake(ctx context.Context, clientConn net.Conn, host string, check func(name string) error) (*tls.Conn, error) {
+	ctx, span := tracing.Start(ctx, "TLS handshake", trace.SpanKindInternal)
+	defer span.End()
 
-	// Wrap connection with TLS
 	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{*tlsCert},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := hello.ServerName
+			if name == "" {
+				name = host
+			}
+			if check != nil {
+				if err := check(name); err != nil {
+					return nil, err
+				}
+			}
+			return p.certManager.GetCertificateContext(hello.Context(), name)
+		},
 	}
 
 	tlsConn := tls.Server(clientConn, tlsConfig)
-	defer tlsConn.Close()
-
-	// Perform TLS handshake
-	if err := tlsConn.Handshake(); err != nil {
-		log.Printf("✗ TLS handshake failed: %v", err)
-		return
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		tracing.SetError(span, err)
+		return nil, err
 	}
 
+	return tlsConn, nil
+}
+
+// serveTLS proxies a decrypted request from tlsConn to target (host:port);
+// ctx carries values for the upstream request such as a pinned dial
+func (p *ProxyServer) serveTLS(ctx context.Context, tlsConn *tls.Conn, target string, tun *tunnelRecord) {
 	// Read HTTP request from TLS connection
 	reader := bufio.NewReader(tlsConn)
 	req, err := http.ReadRequest(reader)
 	if err != nil {
-		log.Printf("✗ Failed to read request: %v", err)
+		if err != io.EOF {
+			tun.err = fmt.Errorf("read request: %w", err)
+		}
 		return
 	}
 
 	// Fix request URL
 	req.URL.Scheme = "https"
-	req.URL.Host = r.Host
+	req.URL.Host = target
+	req.RemoteAddr = tun.client
+
+	rec := p.beginRequest(req, "https", target)
+	rec.tunnelID, rec.tls = tun.id, tun.tls
+	if rec.user == "" {
+		rec.user = tun.user
+	}
+	ctx = p.traceRequest(ctx, rec, req)
+	defer p.finishRequest(rec)
+
+	upgrade := isUpgradeRequest(req)
 
 	// Check cache for HTTPS requests
 	cacheKey := cache.GenerateKey(req)
-	if entry, found := p.httpCache.Get(cacheKey); found {
-		log.Printf("💾 Cache HIT (HTTPS): %s", req.URL)
-		// Write cached response
-		var buf bytes.Buffer
-		resp := &http.Response{
-			StatusCode: entry.StatusCode,
-			Header:     entry.Headers,
-			Body:       io.NopCloser(bytes.NewReader(entry.Body)),
-		}
-		resp.Write(&buf)
-		tlsConn.Write(buf.Bytes())
+	entry, found := p.lookupCache(ctx, rec, req, cacheKey, upgrade)
+	// A disk entry evicted since the lookup is fetched again instead
+	if found && p.writeCachedTLS(tlsConn, req, rec, entry) {
+		return
+	}
+	if !found && cache.OnlyIfCached(req) {
+		writeNotCachedTLS(tlsConn, req, rec)
 		return
 	}
 
-	log.Printf("💿 Cache MISS (HTTPS): %s", req.URL)
-
-	// Forward request
-	resp, err := p.transport.RoundTrip(req)
+	rule := p.timeoutsFor(req.URL)
+	if upgrade {
+		rule = TimeoutRule{}
+	}
+	ctx, cancel := upstreamContext(ctx, rule)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	// Forward request; a stale entry is revalidated instead of fetched again
+	var resp *http.Response
+	if p.canRevalidate(req, entry, found) {
+		var refreshed *cache.CacheEntry
+		resp, refreshed, err = p.revalidate(rec, req, entry)
+		if refreshed != nil {
+			if p.writeCachedTLS(tlsConn, req, rec, refreshed) {
+				p.storeRevalidated(ctx, rec, cacheKey, refreshed)
+				return
+			}
+			// The body left the disk meanwhile: fetch it in full
+			resp, err = p.roundTrip(req)
+		}
+	} else {
+		resp, err = p.roundTrip(req)
+	}
 	if err != nil {
-		log.Printf("✗ Error forwarding HTTPS request: %v", err)
+		rec.err = err
 		return
 	}
 	defer resp.Body.Close()
+	rec.headersReceived(resp)
 
-	// Check if cacheable and cache it
-	if cache.IsCacheable(req, resp) {
-		if entry, err := cache.CreateCacheEntry(resp, p.cacheMaxAge); err == nil {
-			p.httpCache.Set(cacheKey, entry)
-			log.Printf("💾 Cached HTTPS response for: %s (size: %d bytes)", req.URL, entry.Size)
-		}
+	// WebSocket over the intercepted tunnel (wss://)
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		p.relayUpgradeTLS(tlsConn, reader, req, resp, rec)
+		return
+	}
+
+	resp.Body = newIdleTimeoutBody(resp.Body, rule.Idle, cancel)
+
+	// Stream to the client while filling the cache or recording
+	if rec.fixtureKey != "" && !isEventStream(resp) {
+		resp.Body = p.newFixtureBody(ctx, rec, req, resp)
+	} else if p.fixtures == nil && cache.IsCacheable(req, resp) && !isEventStream(resp) {
+		resp.Body = p.newFillBody(ctx, rec, resp, cacheKey, req.URL.String())
+	}
+
+	resp.Body = &countingBody{ReadCloser: resp.Body, n: &rec.bytes}
+
+	if rule.Total > 0 {
+		tlsConn.SetWriteDeadline(time.Now().Add(rule.Total))
 	}
 
-	// Write response
+	// Write response; chunks are written through as they arrive
 	if err := resp.Write(tlsConn); err != nil {
-		log.Printf("✗ Error writing response: %v", err)
+		rec.err = err
+	}
+}
+
+// newFillBody wraps resp.Body so the response is cached as it streams,
+// unless it grows past the per-object size limit. The finished entry is
+// stored in the background.
+func (p *ProxyServer) newFillBody(ctx context.Context, rec *requestRecord, resp *http.Response, cacheKey, url string) io.ReadCloser {
+	// The store outlives the request
+	ctx = context.WithoutCancel(ctx)
+	return cache.NewFillBody(resp, p.current().cacheMaxAge, p.current().maxObjectSize, func(entry *cache.CacheEntry) {
+		entry.URL = url
+		stored := p.stores.run(cacheKey, func() {
+			ctx, span := tracing.Start(ctx, "HTTPCache.Set", trace.SpanKindInternal, attribute.Int64("cache.entry_size", entry.Size))
+			defer span.End()
+
+			if err := p.cache.SetContext(ctx, cacheKey, entry); errors.Is(err, cache.ErrNotAdmitted) {
+				rec.log.Debug("not admitted to the cache: " + url)
+				return
+			} else if err != nil {
+				rec.log.Warn("not caching " + url + ": " + err.Error())
+				tracing.SetError(span, err)
+				return
+			}
+			p.metrics.cacheStored.Add(float64(entry.Size))
+			rec.log.LogCacheOperation("set", cacheKey, false, entry.Size)
+		})
+		if !stored {
+			rec.log.Debug("cache writes busy, not caching " + url)
+		}
+	})
+}
+
+// lookupCache returns a cached entry for key, or the fixture for r in
+// record/replay mode; protocol upgrades always miss. A stale entry that
+// can be revalidated is returned with found false.
+func (p *ProxyServer) lookupCache(ctx context.Context, rec *requestRecord, r *http.Request, key string, upgrade bool) (*cache.CacheEntry, bool) {
+	if p.fixtures != nil {
+		return p.lookupFixture(ctx, rec, r, upgrade)
+	}
+	if upgrade {
+		return nil, false
 	}
+	ctx, span := tracing.Start(ctx, "HTTPCache.Get", trace.SpanKindInternal)
+	defer span.End()
+
+	p.stores.wait(ctx, key)
+	entry, found := p.cache.GetContext(ctx, key)
+	fresh := found && entry.Satisfies(r)
+	span.SetAttributes(attribute.Bool("cache.hit", fresh))
+
+	var size int64
+	if fresh {
+		size = entry.Size
+	}
+	rec.log.LogCacheOperation("get", key, fresh, size)
+	return entry, fresh
 }
 
 // GetCacheStats returns cache statistics
 func (p *ProxyServer) GetCacheStats() (hits, misses uint64, size int64, entries int, hitRate float64) {
-	hits, misses, size, entries = p.httpCache.Stats()
-	hitRate = p.httpCache.HitRate()
+	hits, misses, _, _, hitRate = p.cache.Stats()
+	if memory := p.cache.Memory(); memory != nil {
+		_, _, size, entries = memory.Stats()
+	} else if disk := p.cache.Disk(); disk != nil {
+		_, _, size, entries = disk.Stats()
+	}
 	return
 }
 
-// GetCACertificate returns the CA certificate in PEM format
-func (p *ProxyServer) GetCACertificate() []byte {
-	return p.certManager.GetCACertPEM()
+// GetCacheRejections returns how many responses the memory tier's
+// admission policy kept out
+func (p *ProxyServer) GetCacheRejections() uint64 {
+	if memory := p.cache.Memory(); memory != nil {
+		return memory.Rejections()
+	}
+	return 0
 }
 
-// getEnvInt gets an integer from environment variable with default
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
-		}
-	}
-	return defaultValue
+// PurgeCache removes all cached variants of url from every cache tier and
+// tells other instances sharing Redis to do the same. It returns how many
+// were removed.
+func (p *ProxyServer) PurgeCache(url string) (int, error) {
+	return p.cache.PurgeURL(context.Background(), url)
 }
 
-// getEnvInt64 gets an int64 from environment variable with default
-func getEnvInt64(key string, defaultValue int64) int64 {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.ParseInt(value, 10, 64); err == nil {
-			return intValue
-		}
-	}
-	return defaultValue
+// ClearCache empties the cache; with Redis, every instance sharing its
+// namespace sees an empty cache
+func (p *ProxyServer) ClearCache() error {
+	return p.cache.Clear()
 }
 
-// getEnvDuration gets a duration from environment variable with default
-func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
-	if value := os.Getenv(key); value != "" {
-		if duration, err := time.ParseDuration(value); err == nil {
-			return duration
-		}
-	}
-	return defaultValue
+// InvalidateCache removes entries matching pattern from every cache tier
+// and tells other instances sharing Redis to do the same
+func (p *ProxyServer) InvalidateCache(pattern string) (memory, disk, redis int, err error) {
+	return p.cache.InvalidatePattern(context.Background(), pattern)
+}
+
+// GetCACertificate returns the CA certificate in PEM format
+func (p *ProxyServer) GetCACertificate() []byte {
+	return p.certManager.GetCACertPEM()
 }
@@ -3,8 +3,12 @@ package proxy
 import (
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/onixus/4ebur-net/internal/config"
 )
 
 func TestNewProxyServer(t *testing.T) {
@@ -85,7 +89,9 @@ func TestProxyServerInvalidRequest(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest(tt.method, tt.url, nil)
+			// httptest.NewRequest panics on unparsable URLs, so set it afterwards
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.URL = &url.URL{Opaque: tt.url}
 			rr := httptest.NewRecorder()
 
 			server.ServeHTTP(rr, req)
@@ -98,6 +104,53 @@ func TestProxyServerInvalidRequest(t *testing.T) {
 	}
 }
 
+func TestProxyServerMaxObjectSize(t *testing.T) {
+	server, err := NewProxyServer()
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	cfg := config.Default()
+	cfg.Cache.MaxObjectSizeMB = 1
+	if _, err := server.ApplyConfig(cfg); err != nil {
+		t.Fatalf("ApplyConfig failed: %v", err)
+	}
+	const largeSize = 1<<20 + 64
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/large" {
+			w.Write([]byte(strings.Repeat("x", largeSize)))
+			return
+		}
+		w.Write([]byte("small"))
+	}))
+	defer backend.Close()
+
+	fetch := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", backend.URL+path, nil)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, path := range []string{"/small", "/large"} {
+		if rr := fetch(path); rr.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("%s: expected first request to miss", path)
+		}
+	}
+
+	if rr := fetch("/small"); rr.Header().Get("X-Cache") != "HIT" {
+		t.Error("Small response should be served from cache")
+	}
+
+	rr := fetch("/large")
+	if rr.Header().Get("X-Cache") != "MISS" {
+		t.Error("Response over the per-object limit should not be cached")
+	}
+	if rr.Body.Len() != largeSize {
+		t.Errorf("Large response should stream fully, got %d bytes", rr.Body.Len())
+	}
+}
+
 func TestProxyServerConcurrency(t *testing.T) {
 	server, err := NewProxyServer()
 	if err != nil {
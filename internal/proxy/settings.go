@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/onixus/4ebur-net/internal/config"
+)
+
+// runtimeSettings holds everything that can change on a config reload.
+// Handlers read a consistent snapshot via ProxyServer.settings.
+type runtimeSettings struct {
+	cacheMaxAge     time.Duration
+	maxObjectSize   int64         // Larger responses stream through uncached
+	timeoutRules    []TimeoutRule // Per-route response timeouts, first match wins
+	defaultTimeouts TimeoutRule
+	wsLogFrames     bool
+	allowClients    []*net.IPNet
+	denyHosts       []string
+	parentProxy     *url.URL
+	metricsMaxHosts int // Distinct host labels on /metrics, 0 disables them
+}
+
+// newRuntimeSettings converts the reloadable part of cfg
+func newRuntimeSettings(cfg *config.Config) (*runtimeSettings, error) {
+	s := &runtimeSettings{
+		cacheMaxAge:   cfg.Cache.MaxAge.Std(),
+		maxObjectSize: cfg.Cache.MaxObjectSizeMB * 1024 * 1024,
+		defaultTimeouts: TimeoutRule{
+			Idle:  cfg.Upstream.IdleTimeout.Std(),
+			Total: cfg.Upstream.TotalTimeout.Std(),
+		},
+		wsLogFrames:     cfg.WebSocket.LogFrames,
+		denyHosts:       cfg.ACL.DenyHosts,
+		metricsMaxHosts: cfg.Metrics.MaxHosts,
+	}
+
+	for _, route := range cfg.Upstream.Routes {
+		s.timeoutRules = append(s.timeoutRules, TimeoutRule{
+			Pattern: route.Pattern,
+			Idle:    route.IdleTimeout.Std(),
+			Total:   route.TotalTimeout.Std(),
+		})
+	}
+
+	for _, cidr := range cfg.ACL.AllowClients {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client CIDR %q: %w", cidr, err)
+		}
+		s.allowClients = append(s.allowClients, network)
+	}
+
+	if cfg.Upstream.ParentProxy != "" {
+		parent, err := url.Parse(cfg.Upstream.ParentProxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parent proxy: %w", err)
+		}
+		s.parentProxy = parent
+	}
+
+	return s, nil
+}
+
+// current returns the active runtime settings
+func (p *ProxyServer) current() *runtimeSettings {
+	return p.settings.Load()
+}
+
+// ApplyConfig hot-reloads the settings that can change at runtime: cache
+// max-age and object size, timeouts, ACLs, the parent proxy and WebSocket
+// logging. It returns the changed settings that still need a restart.
+func (p *ProxyServer) ApplyConfig(cfg *config.Config) ([]string, error) {
+	next, err := newRuntimeSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	restart := p.config.RestartRequired(cfg)
+	p.config = cfg
+	p.settings.Store(next)
+	p.mu.Unlock()
+
+	for _, field := range restart {
+		log.Printf("⚠️  Config change to %s takes effect after a restart", field)
+	}
+	return restart, nil
+}
+
+// upstreamProxy is the transport's Proxy func; it follows config reloads
+func (p *ProxyServer) upstreamProxy(*http.Request) (*url.URL, error) {
+	return p.current().parentProxy, nil
+}
+
+// errForbidden is returned when an ACL rejects a request
+var errForbidden = errors.New("forbidden by proxy ACL")
+
+// checkACL verifies that clientAddr may use the proxy to reach host
+func (p *ProxyServer) checkACL(clientAddr, host string) error {
+	s := p.current()
+
+	if len(s.allowClients) > 0 {
+		clientHost, _, err := net.SplitHostPort(clientAddr)
+		if err != nil {
+			clientHost = clientAddr
+		}
+		ip := net.ParseIP(clientHost)
+
+		allowed := false
+		for _, network := range s.allowClients {
+			if ip != nil && network.Contains(ip) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%w: client %s not allowed", errForbidden, clientHost)
+		}
+	}
+
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.ToLower(host)
+	for _, pattern := range s.denyHosts {
+		if ok, _ := path.Match(pattern, host); ok {
+			return fmt.Errorf("%w: host %s denied", errForbidden, host)
+		}
+	}
+
+	return nil
+}
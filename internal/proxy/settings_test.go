@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/onixus/4ebur-net/internal/config"
+)
+
+func TestCheckACL(t *testing.T) {
+	cfg := config.Default()
+	cfg.ACL.AllowClients = []string{"10.0.0.0/8", "127.0.0.1/32"}
+	cfg.ACL.DenyHosts = []string{"*.ads.example", "tracker.example"}
+
+	server, err := NewProxyServerWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	defer server.Close()
+
+	tests := []struct {
+		client  string
+		host    string
+		allowed bool
+	}{
+		{"10.1.2.3:5000", "example.com", true},
+		{"127.0.0.1:5000", "example.com:443", true},
+		{"192.168.1.1:5000", "example.com", false},
+		{"10.1.2.3:5000", "banner.ads.example", false},
+		{"10.1.2.3:5000", "TRACKER.example:443", false},
+	}
+
+	for _, tt := range tests {
+		err := server.checkACL(tt.client, tt.host)
+		if allowed := err == nil; allowed != tt.allowed {
+			t.Errorf("checkACL(%s, %s) = %v, want allowed=%v", tt.client, tt.host, err, tt.allowed)
+		}
+		if err != nil && !errors.Is(err, errForbidden) {
+			t.Errorf("Expected errForbidden, got %v", err)
+		}
+	}
+}
+
+func TestApplyConfigReloadsSettings(t *testing.T) {
+	server, err := NewProxyServer()
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	defer server.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	proxy := httptest.NewServer(server)
+	defer proxy.Close()
+
+	resp, err := proxyClient(proxy).Get(backend.URL)
+	if err != nil {
+		t.Fatalf("Request through proxy failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 before reload, got %d", resp.StatusCode)
+	}
+
+	next := config.Default()
+	next.Cache.MaxAge = config.Duration(time.Hour)
+	next.ACL.DenyHosts = []string{"127.0.0.1"}
+	next.Listen.Proxy = ":9999"
+
+	restart, err := server.ApplyConfig(next)
+	if err != nil {
+		t.Fatalf("ApplyConfig failed: %v", err)
+	}
+	if len(restart) != 1 || restart[0] != "listen" {
+		t.Errorf("Expected listen to need a restart, got %v", restart)
+	}
+	if server.current().cacheMaxAge != time.Hour {
+		t.Errorf("Expected reloaded max age, got %v", server.current().cacheMaxAge)
+	}
+
+	resp, err = proxyClient(proxy).Get(backend.URL + "/after")
+	if err != nil {
+		t.Fatalf("Request through proxy failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 after reload, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// connTracker keeps track of connections that left http.Server's control
+// (hijacked CONNECT tunnels, upgrades, transparent TLS) so they can be drained
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{conns: make(map[net.Conn]struct{})}
+}
+
+// add registers c; the returned func must be called when c is done
+func (t *connTracker) add(c net.Conn) func() {
+	t.mu.Lock()
+	t.conns[c] = struct{}{}
+	t.wg.Add(1)
+	t.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			delete(t.conns, c)
+			t.mu.Unlock()
+			t.wg.Done()
+		})
+	}
+}
+
+// count returns the number of active connections
+func (t *connTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.conns)
+}
+
+// wait blocks until all connections are done or ctx expires
+func (t *connTracker) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closeAll force-closes every tracked connection
+func (t *connTracker) closeAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for c := range t.conns {
+		c.Close()
+	}
+}
+
+// transparentServer is a running ServeTransparent instance
+type transparentServer struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+// Draining reports whether Shutdown has been called; /health should report
+// not-ready so load balancers stop sending new clients
+func (p *ProxyServer) Draining() bool {
+	return p.draining.Load()
+}
+
+// BeginDrain marks the proxy as not ready without stopping anything yet
+func (p *ProxyServer) BeginDrain() {
+	p.draining.Store(true)
+}
+
+// ActiveTunnels returns the number of hijacked connections in flight
+func (p *ProxyServer) ActiveTunnels() int {
+	return p.tunnels.count()
+}
+
+// Shutdown stops transparent listeners and waits for tunnels and upgraded
+// connections to finish. When ctx expires the remaining ones are closed.
+// Requests served by the caller's http.Server are drained by its own
+// Shutdown, which should be called alongside this one.
+func (p *ProxyServer) Shutdown(ctx context.Context) error {
+	p.BeginDrain()
+
+	p.mu.Lock()
+	servers := p.transparentServers
+	p.transparentServers = nil
+	p.mu.Unlock()
+
+	var errs []error
+	for _, ts := range servers {
+		ts.listener.Close()
+		if err := ts.server.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if n := p.tunnels.count(); n > 0 {
+		log.Printf("⏳ Waiting for %d active tunnels to finish", n)
+	}
+	if err := p.tunnels.wait(ctx); err != nil {
+		log.Printf("⚠️  Drain period over, closing %d tunnels", p.tunnels.count())
+		p.tunnels.closeAll()
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Close finishes pending cache writes and releases background resources
+// such as the cache cleanup goroutine
+func (p *ProxyServer) Close() error {
+	p.stores.waitAll()
+	p.cache.Close()
+	p.transport.CloseIdleConnections()
+	if p.accessLog != nil {
+		p.accessLog.Close()
+	}
+
+	// Flush spans still queued for the collector
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if p.provider == nil {
+		return nil
+	}
+	return p.provider.Shutdown(ctx)
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// openTunnel establishes a CONNECT tunnel through proxy and returns the
+// client side of it
+func openTunnel(t *testing.T, proxy *httptest.Server) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial proxy: %v", err)
+	}
+
+	conn.Write([]byte("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT failed: %v", err)
+	}
+	return conn
+}
+
+func waitForTunnels(t *testing.T, server *ProxyServer, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for server.ActiveTunnels() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d active tunnels, got %d", n, server.ActiveTunnels())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestShutdownWaitsForTunnels(t *testing.T) {
+	server, err := NewProxyServer()
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	defer server.Close()
+
+	proxy := httptest.NewServer(server)
+	defer proxy.Close()
+
+	conn := openTunnel(t, proxy)
+	waitForTunnels(t, server, 1)
+
+	// Client finishes shortly after shutdown starts
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		conn.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown should drain cleanly, got %v", err)
+	}
+	if !server.Draining() {
+		t.Error("Server should report draining after Shutdown")
+	}
+	if n := server.ActiveTunnels(); n != 0 {
+		t.Errorf("Expected no active tunnels, got %d", n)
+	}
+}
+
+func TestShutdownClosesTunnelsAfterDrainTimeout(t *testing.T) {
+	server, err := NewProxyServer()
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	defer server.Close()
+
+	proxy := httptest.NewServer(server)
+	defer proxy.Close()
+
+	conn := openTunnel(t, proxy)
+	defer conn.Close()
+	waitForTunnels(t, server, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err == nil {
+		t.Error("Expected drain timeout error")
+	}
+
+	// The stuck tunnel must have been closed by the proxy
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected tunnel to be closed after drain timeout")
+	}
+	waitForTunnels(t, server, 0)
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+)
+
+// maxPendingStores bounds the cache fills written in the background; fills
+// beyond it are dropped rather than queued
+const maxPendingStores = 64
+
+// storeTracker writes finished cache fills in the background, so a client
+// reading a response gets EOF without waiting for the disk or Redis. A
+// lookup of a key still being written waits for it, and Close waits for
+// all of them.
+type storeTracker struct {
+	mu      sync.Mutex
+	pending map[string]chan struct{}
+	slots   chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newStoreTracker() *storeTracker {
+	return &storeTracker{
+		pending: make(map[string]chan struct{}),
+		slots:   make(chan struct{}, maxPendingStores),
+	}
+}
+
+// run calls store for key in its own goroutine. It reports false, calling
+// nothing, when maxPendingStores writes are already in flight.
+func (t *storeTracker) run(key string, store func()) bool {
+	select {
+	case t.slots <- struct{}{}:
+	default:
+		return false
+	}
+
+	done := make(chan struct{})
+	t.mu.Lock()
+	t.pending[key] = done
+	t.wg.Add(1)
+	t.mu.Unlock()
+
+	go func() {
+		defer func() {
+			t.mu.Lock()
+			if t.pending[key] == done {
+				delete(t.pending, key)
+			}
+			t.mu.Unlock()
+			close(done)
+			<-t.slots
+			t.wg.Done()
+		}()
+		store()
+	}()
+	return true
+}
+
+// wait blocks until a pending write of key finishes or ctx expires
+func (t *storeTracker) wait(ctx context.Context, key string) {
+	t.mu.Lock()
+	done, ok := t.pending[key]
+	t.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// waitAll blocks until every pending write has finished
+func (t *storeTracker) waitAll() {
+	t.wg.Wait()
+}
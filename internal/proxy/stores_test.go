@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStoreTrackerRunsInBackground(t *testing.T) {
+	stores := newStoreTracker()
+	release := make(chan struct{})
+	stored := make(chan struct{})
+
+	if !stores.run("key", func() {
+		<-release
+		close(stored)
+	}) {
+		t.Fatal("Expected the store to be accepted")
+	}
+
+	// A lookup of the key waits for the write, others don't
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stores.wait(context.Background(), "other")
+	stores.wait(ctx, "key")
+	if ctx.Err() == nil {
+		t.Error("Expected wait to block while the key is being stored")
+	}
+
+	close(release)
+	stores.wait(context.Background(), "key")
+	select {
+	case <-stored:
+	default:
+		t.Error("wait returned before the store finished")
+	}
+	stores.waitAll()
+}
+
+func TestStoreTrackerLimit(t *testing.T) {
+	stores := newStoreTracker()
+	release := make(chan struct{})
+	defer stores.waitAll()
+	defer close(release)
+
+	for i := 0; i < maxPendingStores; i++ {
+		if !stores.run("key", func() { <-release }) {
+			t.Fatalf("Store %d rejected below the limit", i)
+		}
+	}
+	if stores.run("key", func() {}) {
+		t.Error("Expected stores over the limit to be dropped")
+	}
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TimeoutRule bounds how long a proxied response may take
+type TimeoutRule struct {
+	// Pattern is a host glob with an optional path prefix,
+	// e.g. "*.example.com" or "api.example.com/events"
+	Pattern string
+	// Idle is the longest gap allowed between chunks (0 = unlimited)
+	Idle time.Duration
+	// Total is the longest a whole response may take (0 = unlimited)
+	Total time.Duration
+}
+
+// Matches reports whether the rule applies to u
+func (r TimeoutRule) Matches(u *url.URL) bool {
+	hostPattern, pathPrefix := r.Pattern, ""
+	if i := strings.Index(r.Pattern, "/"); i >= 0 {
+		hostPattern, pathPrefix = r.Pattern[:i], r.Pattern[i:]
+	}
+
+	if ok, _ := path.Match(hostPattern, u.Hostname()); !ok {
+		return false
+	}
+	return strings.HasPrefix(u.Path, pathPrefix)
+}
+
+// timeoutsFor returns the first rule matching u, or the default rule
+func (p *ProxyServer) timeoutsFor(u *url.URL) TimeoutRule {
+	settings := p.current()
+	for _, rule := range settings.timeoutRules {
+		if rule.Matches(u) {
+			return rule
+		}
+	}
+	return settings.defaultTimeouts
+}
+
+// upstreamContext derives the context for an upstream request under rule
+func upstreamContext(parent context.Context, rule TimeoutRule) (context.Context, context.CancelFunc) {
+	if rule.Total > 0 {
+		return context.WithTimeout(parent, rule.Total)
+	}
+	return context.WithCancel(parent)
+}
+
+// isEventStream reports whether resp is a Server-Sent Events stream
+func isEventStream(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// isStreamingResponse reports whether resp should be flushed chunk by chunk
+func isStreamingResponse(resp *http.Response) bool {
+	return isEventStream(resp) || resp.ContentLength < 0
+}
+
+// idleTimeoutBody cancels the upstream request when no data arrives for idle
+type idleTimeoutBody struct {
+	io.ReadCloser
+	idle  time.Duration
+	timer *time.Timer
+	once  sync.Once
+}
+
+// newIdleTimeoutBody wraps body so that cancel fires after idle without data
+func newIdleTimeoutBody(body io.ReadCloser, idle time.Duration, cancel context.CancelFunc) io.ReadCloser {
+	if idle <= 0 {
+		return body
+	}
+	return &idleTimeoutBody{
+		ReadCloser: body,
+		idle:       idle,
+		timer:      time.AfterFunc(idle, cancel),
+	}
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.idle)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.once.Do(func() { b.timer.Stop() })
+	return b.ReadCloser.Close()
+}
+
+// streamWriter writes to the client, optionally flushing after every chunk
+// and pushing the write deadline forward by idle on each write
+type streamWriter struct {
+	w        http.ResponseWriter
+	rc       *http.ResponseController
+	flush    bool
+	idle     time.Duration
+	deadline time.Time // Zero when the total time is unlimited
+}
+
+func newStreamWriter(w http.ResponseWriter, flush bool, rule TimeoutRule) *streamWriter {
+	sw := &streamWriter{
+		w:     w,
+		rc:    http.NewResponseController(w),
+		flush: flush,
+		idle:  rule.Idle,
+	}
+	if rule.Total > 0 {
+		sw.deadline = time.Now().Add(rule.Total)
+	}
+
+	// Write deadlines are best effort: not every ResponseWriter supports them
+	_ = sw.rc.SetWriteDeadline(sw.deadline)
+
+	return sw
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if sw.idle > 0 {
+		next := time.Now().Add(sw.idle)
+		if !sw.deadline.IsZero() && next.After(sw.deadline) {
+			next = sw.deadline
+		}
+		_ = sw.rc.SetWriteDeadline(next)
+	}
+
+	n, err := sw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	if sw.flush {
+		if err := sw.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/onixus/4ebur-net/internal/config"
+)
+
+func TestTimeoutRuleMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		url     string
+		want    bool
+	}{
+		{"*.example.com", "https://api.example.com/x", true},
+		{"*.example.com", "https://example.org/x", false},
+		{"api.example.com/events", "https://api.example.com/events/stream", true},
+		{"api.example.com/events", "https://api.example.com/other", false},
+	}
+
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		if got := (TimeoutRule{Pattern: tt.pattern}).Matches(u); got != tt.want {
+			t.Errorf("%q matches %q = %v, want %v", tt.pattern, tt.url, got, tt.want)
+		}
+	}
+}
+
+// proxyClient returns a client that sends requests through proxy
+func proxyClient(proxy *httptest.Server) *http.Client {
+	proxyURL, _ := url.Parse(proxy.URL)
+	return &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+}
+
+func TestServerSentEventsAreFlushed(t *testing.T) {
+	server, err := NewProxyServer()
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("data: second\n\n"))
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	proxy := httptest.NewServer(server)
+	defer proxy.Close()
+
+	resp, err := proxyClient(proxy).Get(backend.URL + "/events")
+	if err != nil {
+		t.Fatalf("Request through proxy failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The first event must arrive while upstream is still holding the stream
+	lines := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		lines <- line
+	}()
+
+	select {
+	case line := <-lines:
+		if line != "data: first\n" {
+			t.Errorf("Unexpected first line: %q", line)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("First event was not flushed to the client")
+	}
+}
+
+func TestIdleTimeoutAbortsStalledStream(t *testing.T) {
+	server, err := NewProxyServer()
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	cfg := config.Default()
+	cfg.Upstream.Routes = []config.TimeoutRoute{
+		{Pattern: "127.0.0.1/stall", IdleTimeout: config.Duration(200 * time.Millisecond)},
+	}
+	if _, err := server.ApplyConfig(cfg); err != nil {
+		t.Fatalf("ApplyConfig failed: %v", err)
+	}
+
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	proxy := httptest.NewServer(server)
+	defer proxy.Close()
+
+	resp, err := proxyClient(proxy).Get(backend.URL + "/stall")
+	if err != nil {
+		t.Fatalf("Request through proxy failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	done := make(chan string, 1)
+	go func() {
+		body, _ := io.ReadAll(resp.Body)
+		done <- string(body)
+	}()
+
+	select {
+	case body := <-done:
+		if !strings.HasPrefix(body, "partial") {
+			t.Errorf("Unexpected body: %q", body)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Stalled stream was not aborted by the idle timeout")
+	}
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/onixus/4ebur-net/internal/config"
+	"github.com/onixus/4ebur-net/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// newTracer builds the OTLP tracer provider and the proxy's tracer. The
+// provider is nil when tracing is disabled and the tracer is then a no-op.
+func newTracer(cfg config.TracingConfig) (trace.Tracer, *sdktrace.TracerProvider, error) {
+	if cfg.Endpoint == "" {
+		return noop.NewTracerProvider().Tracer(tracing.ScopeName), nil, nil
+	}
+	provider, err := tracing.NewProvider(cfg.Endpoint, cfg.ServiceName, cfg.SampleRatio)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Printf("🔭 Tracing enabled: OTLP %s, sample ratio %g", cfg.Endpoint, cfg.SampleRatio)
+	return provider.Tracer(tracing.ScopeName), provider, nil
+}
+
+// traceRequest starts the server span of a proxied request, continuing
+// the client's trace when it sent a traceparent
+func (p *ProxyServer) traceRequest(ctx context.Context, rec *requestRecord, r *http.Request) context.Context {
+	ctx, rec.span = p.tracer.Start(tracing.Extract(ctx, r.Header), "proxy "+r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.full", p.redactor.String(r.URL.String())),
+			attribute.String("server.address", rec.host)))
+	return ctx
+}
+
+// roundTrip forwards req upstream inside a client span and propagates the
+// trace context with a traceparent header
+func (p *ProxyServer) roundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := tracing.Start(req.Context(), "upstream "+req.Method, trace.SpanKindClient,
+		attribute.String("http.request.method", req.Method),
+		attribute.String("url.full", p.redactor.String(req.URL.String())))
+	defer span.End()
+
+	tracing.Inject(ctx, req.Header)
+	resp, err := p.transport.RoundTrip(req)
+	if err != nil {
+		tracing.SetError(span, p.redactedError(err))
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	return resp, nil
+}
+
+// Tracer returns the tracer; it is a no-op when tracing is disabled
+func (p *ProxyServer) Tracer() trace.Tracer {
+	return p.tracer
+}
@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/onixus/4ebur-net/internal/config"
+	"github.com/onixus/4ebur-net/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// stubSpan is the subset of an OTLP span the tests look at, with hex IDs
+type stubSpan struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+}
+
+// stubCollector accepts OTLP/HTTP protobuf exports and keeps the spans by name
+type stubCollector struct {
+	*httptest.Server
+	mu    sync.Mutex
+	spans map[string]stubSpan
+}
+
+func newStubCollector(t *testing.T) *stubCollector {
+	c := &stubCollector{spans: make(map[string]stubSpan)}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req coltracepb.ExportTraceServiceRequest
+		if err := proto.Unmarshal(body, &req); err != nil {
+			t.Errorf("Collector got an invalid export: %v", err)
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					c.spans[s.Name] = stubSpan{
+						TraceID:      hex.EncodeToString(s.TraceId),
+						SpanID:       hex.EncodeToString(s.SpanId),
+						ParentSpanID: hex.EncodeToString(s.ParentSpanId),
+						Name:         s.Name,
+					}
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	return c
+}
+
+func (c *stubCollector) span(t *testing.T, name string) stubSpan {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.spans[name]
+	if !ok {
+		names := make([]string, 0, len(c.spans))
+		for n := range c.spans {
+			names = append(names, n)
+		}
+		t.Fatalf("No %q span exported; got %v", name, names)
+	}
+	return s
+}
+
+// newTracedProxy starts a proxy exporting every span to collector
+func newTracedProxy(t *testing.T, collector *stubCollector) (*ProxyServer, *httptest.Server) {
+	cfg := config.Default()
+	cfg.Tracing.Endpoint = collector.URL
+	server, err := NewProxyServerWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	return server, httptest.NewServer(server)
+}
+
+// flushSpans waits for in-flight requests and tunnels, then exports
+func flushSpans(t *testing.T, server *ProxyServer, proxy *httptest.Server) {
+	proxy.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if err := server.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestTracingPropagatesTraceparent(t *testing.T) {
+	collector := newStubCollector(t)
+	defer collector.Close()
+
+	const clientTrace = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const clientSpan = "00f067aa0ba902b7"
+
+	var upstreamParent string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamParent = r.Header.Get("traceparent")
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("traced"))
+	}))
+	defer backend.Close()
+
+	server, proxy := newTracedProxy(t, collector)
+
+	req, _ := http.NewRequest(http.MethodGet, backend.URL+"/traced", nil)
+	req.Header.Set("traceparent", "00-"+clientTrace+"-"+clientSpan+"-01")
+	resp, err := proxyClient(proxy).Do(req)
+	if err != nil {
+		t.Fatalf("Request through proxy failed: %v", err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	flushSpans(t, server, proxy)
+
+	root := collector.span(t, "proxy GET")
+	upstream := collector.span(t, "upstream GET")
+	if root.TraceID != clientTrace || root.ParentSpanID != clientSpan {
+		t.Errorf("Server span should continue the client's trace: %+v", root)
+	}
+	for _, name := range []string{"HTTPCache.Get", "upstream GET", "HTTPCache.Set"} {
+		if s := collector.span(t, name); s.ParentSpanID != root.SpanID || s.TraceID != clientTrace {
+			t.Errorf("%s should be a child of the server span: %+v", name, s)
+		}
+	}
+
+	h := http.Header{"Traceparent": {upstreamParent}}
+	sc := trace.SpanContextFromContext(tracing.Extract(context.Background(), h))
+	if !sc.IsValid() {
+		t.Fatalf("Upstream got invalid traceparent %q", upstreamParent)
+	}
+	if sc.TraceID().String() != clientTrace || sc.SpanID().String() != upstream.SpanID || !sc.IsSampled() {
+		t.Errorf("Upstream traceparent %q should name the upstream span %s", upstreamParent, upstream.SpanID)
+	}
+}
+
+func TestTracingCONNECTSpans(t *testing.T) {
+	collector := newStubCollector(t)
+	defer collector.Close()
+
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure"))
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	server, proxy := newTracedProxy(t, collector)
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("CONNECT " + backendURL.Host + " HTTP/1.1\r\nHost: " + backendURL.Host + "\r\n\r\n"))
+	connectResp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil || connectResp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT failed: %v", err)
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	tlsConn.Write([]byte("GET /secure HTTP/1.1\r\nHost: " + backendURL.Host + "\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(tlsConn), nil)
+	if err != nil {
+		t.Fatalf("Request through tunnel failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "secure") {
+		t.Fatalf("Unexpected body %q", body)
+	}
+	tlsConn.Close()
+
+	flushSpans(t, server, proxy)
+
+	connect := collector.span(t, "CONNECT")
+	handshake := collector.span(t, "TLS handshake")
+	certSpan := collector.span(t, "CertManager.GetCertificate")
+	if handshake.ParentSpanID != connect.SpanID {
+		t.Errorf("TLS handshake should be a child of CONNECT: %+v", handshake)
+	}
+	if certSpan.ParentSpanID != handshake.SpanID {
+		t.Errorf("Certificate span should be a child of the handshake: %+v", certSpan)
+	}
+
+	// Requests inside the tunnel start their own trace
+	inner := collector.span(t, "proxy GET")
+	if inner.TraceID == connect.TraceID || inner.ParentSpanID != "" {
+		t.Errorf("Tunnel request should be a new root span: %+v", inner)
+	}
+	if collector.span(t, "upstream GET").ParentSpanID != inner.SpanID {
+		t.Error("Upstream span should be a child of the tunnel request span")
+	}
+}
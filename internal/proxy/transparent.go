@@ -0,0 +1,340 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/onixus/4ebur-net/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tlsRecordHandshake is the first byte of a TLS ClientHello record
+const tlsRecordHandshake = 0x16
+
+// sniffTimeout bounds how long a transparent client may stay silent
+// before we give up on detecting its protocol
+const sniffTimeout = 10 * time.Second
+
+// originalDstKey is the context key holding the pre-NAT destination
+type originalDstKey struct{}
+
+// lookupOriginalDst is replaced in tests that cannot use iptables
+var lookupOriginalDst = originalDst
+
+// ServeTransparent accepts redirected connections from l (iptables/nftables
+// REDIRECT or TPROXY) and proxies them without any client-side proxy
+// settings. TLS connections are intercepted via MITM using the SNI server
+// name and plain HTTP is named by its Host header, but both are always sent
+// to the original destination and rejected when the name does not match it.
+func (p *ProxyServer) ServeTransparent(l net.Listener) error {
+	httpConns := newConnListener(l.Addr())
+	defer httpConns.Close()
+
+	server := &http.Server{
+		Handler:           http.HandlerFunc(p.handleTransparentHTTP),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		ConnState:         p.ConnState,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			if pc, ok := c.(*peekedConn); ok {
+				return context.WithValue(ctx, originalDstKey{}, pc.dst)
+			}
+			return ctx
+		},
+	}
+	go server.Serve(httpConns)
+
+	p.mu.Lock()
+	p.transparentServers = append(p.transparentServers, &transparentServer{listener: l, server: server})
+	p.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go p.handleTransparentConn(conn, httpConns)
+	}
+}
+
+// handleTransparentConn sniffs the first bytes of conn and dispatches it
+// to the MITM path or the plain HTTP server
+func (p *ProxyServer) handleTransparentConn(conn net.Conn, httpConns *connListener) {
+	dst, err := lookupOriginalDst(conn)
+	if err != nil {
+		p.logger.ErrorWithFields(err, "original destination lookup failed",
+			map[string]interface{}{"client_addr": conn.RemoteAddr().String()})
+		conn.Close()
+		return
+	}
+
+	pc := &peekedConn{Conn: conn, reader: bufio.NewReader(conn), dst: dst}
+
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	first, err := pc.reader.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	if first[0] != tlsRecordHandshake {
+		if err := httpConns.push(pc); err != nil {
+			conn.Close()
+		}
+		return
+	}
+
+	defer conn.Close()
+	defer p.tunnels.add(conn)()
+
+	tun := p.beginTunnel("transparent", conn.RemoteAddr().String(), "", dst)
+	defer p.finishTunnel(tun)
+
+	if err := p.checkACL(tun.client, dst); err != nil {
+		p.logDenied(tun.client, dst, err)
+		tun.err = err
+		return
+	}
+
+	host, _, err := net.SplitHostPort(dst)
+	if err != nil {
+		tun.err = fmt.Errorf("invalid original destination: %w", err)
+		return
+	}
+
+	// The SNI name is checked against dst and the ACL before a certificate
+	// is minted for it; it never decides where the connection goes
+	ctx, span := p.tracer.Start(context.Background(), "TRANSPARENT",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("server.address", dst)))
+	target := dst
+	tlsConn, err := p.mitmHandshake(ctx, pc, host, func(name string) error {
+		t, err := matchDestination(ctx, name, dst)
+		if err != nil {
+			return err
+		}
+		if err := p.checkACL(tun.client, t); err != nil {
+			p.logDenied(tun.client, t, err)
+			return err
+		}
+		target = t
+		return nil
+	})
+	if err != nil {
+		tun.err = fmt.Errorf("TLS handshake: %w", err)
+		tracing.SetError(span, err)
+		span.End()
+		return
+	}
+	defer tlsConn.Close()
+	span.End()
+
+	tun.tls = tlsInfo(tlsConn.ConnectionState())
+	tun.host = target
+
+	p.serveTLS(pinDial(context.Background(), target, dst), tlsConn, target, tun)
+}
+
+// handleTransparentHTTP turns an origin-form request into a proxy request.
+// The Host header names the request but the connection always goes to the
+// original destination.
+func (p *ProxyServer) handleTransparentHTTP(w http.ResponseWriter, r *http.Request) {
+	dst, _ := r.Context().Value(originalDstKey{}).(string)
+	if err := p.checkACL(r.RemoteAddr, dst); err != nil {
+		p.logDenied(r.RemoteAddr, dst, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	target, err := matchDestination(r.Context(), r.Host, dst)
+	if err != nil {
+		p.logDenied(r.RemoteAddr, r.Host, err)
+		status := http.StatusBadGateway
+		if errors.Is(err, errDestinationMismatch) {
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	r.URL.Scheme = "http"
+	r.URL.Host = target
+	if err := p.checkACL(r.RemoteAddr, target); err != nil {
+		p.logDenied(r.RemoteAddr, target, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	p.handleHTTP(w, r.WithContext(pinDial(r.Context(), target, dst)))
+}
+
+// resolveTimeout bounds the lookup that matches a Host or SNI name to the
+// original destination
+const resolveTimeout = 5 * time.Second
+
+// errDestinationMismatch is returned when a client names a host that is not
+// the destination it actually connected to
+var errDestinationMismatch = errors.New("host does not match original destination")
+
+// matchDestination checks that name (a Host header or SNI server name)
+// refers to the original destination dst and returns it as host:port with
+// the port of dst. An empty name yields dst itself.
+func matchDestination(ctx context.Context, name, dst string) (string, error) {
+	dstHost, dstPort, err := net.SplitHostPort(dst)
+	if err != nil {
+		return "", fmt.Errorf("invalid original destination %q: %w", dst, err)
+	}
+	if name == "" {
+		return dst, nil
+	}
+
+	host, port, err := net.SplitHostPort(name)
+	if err != nil {
+		host, port = strings.Trim(name, "[]"), dstPort
+	}
+	if port != dstPort {
+		return "", fmt.Errorf("%w: %s is not %s", errDestinationMismatch, name, dst)
+	}
+
+	dstIP := net.ParseIP(dstHost)
+	if ip := net.ParseIP(host); ip != nil {
+		if !ip.Equal(dstIP) {
+			return "", fmt.Errorf("%w: %s is not %s", errDestinationMismatch, name, dst)
+		}
+		return net.JoinHostPort(host, dstPort), nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", fmt.Errorf("resolve %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if addr.IP.Equal(dstIP) {
+			return net.JoinHostPort(host, dstPort), nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s does not resolve to %s", errDestinationMismatch, host, dstHost)
+}
+
+// pinnedDialKey is the context key for a dial redirected to a fixed address
+type pinnedDialKey struct{}
+
+// pinnedDial sends upstream connections for addr to dst
+type pinnedDial struct {
+	addr, dst string
+}
+
+// pinDial makes the transport connect to dst whenever a request under ctx
+// dials addr; dials to a parent proxy are left alone
+func pinDial(ctx context.Context, addr, dst string) context.Context {
+	return context.WithValue(ctx, pinnedDialKey{}, pinnedDial{addr: addr, dst: dst})
+}
+
+// dialPinned is the transport's DialContext; it honours pinDial
+func dialPinned(ctx context.Context, network, addr string) (net.Conn, error) {
+	if pin, ok := ctx.Value(pinnedDialKey{}).(pinnedDial); ok && addr == pin.addr {
+		addr = pin.dst
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+// peekedConn is a net.Conn whose initial bytes were buffered while sniffing
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+	dst    string
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// connListener is a net.Listener fed with already accepted connections
+type connListener struct {
+	addr      net.Addr
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *connListener) push(c net.Conn) error {
+	select {
+	case l.conns <- c:
+		return nil
+	case <-l.done:
+		return net.ErrClosed
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
+
+// ListenTransparent opens a listener for redirected traffic on addr. With
+// tproxy the socket is marked IP_TRANSPARENT so TPROXY rules can deliver
+// connections addressed to foreign IPs; otherwise the original destination
+// is recovered from the REDIRECT conntrack entry.
+func ListenTransparent(addr string, tproxy bool) (net.Listener, error) {
+	if !tproxy {
+		return net.Listen("tcp", addr)
+	}
+
+	lc := net.ListenConfig{Control: transparentControl}
+	l, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &tproxyListener{Listener: l}, nil
+}
+
+// tproxyListener marks accepted connections as TPROXY-delivered
+type tproxyListener struct {
+	net.Listener
+}
+
+func (l *tproxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &tproxyConn{Conn: c}, nil
+}
+
+// tproxyConn is a connection whose local address is the original destination
+type tproxyConn struct {
+	net.Conn
+}
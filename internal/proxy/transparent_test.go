@@ -0,0 +1,271 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startTransparent runs ServeTransparent with the original destination
+// stubbed to dst, since tests cannot rely on iptables
+func startTransparent(t *testing.T, server *ProxyServer, dst string) net.Listener {
+	t.Helper()
+
+	orig := lookupOriginalDst
+	lookupOriginalDst = func(net.Conn) (string, error) { return dst, nil }
+	t.Cleanup(func() { lookupOriginalDst = orig })
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go server.ServeTransparent(listener)
+	return listener
+}
+
+func TestTransparentHTTP(t *testing.T) {
+	server, err := NewProxyServer()
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("transparent " + r.URL.Path))
+	}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	listener := startTransparent(t, server, backendURL.Host)
+
+	// Client talks to the "origin" directly, unaware of the proxy
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	req, _ := http.NewRequest("GET", "http://"+backendURL.Host+"/path", nil)
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("Failed to write request: %v", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "transparent /path" {
+		t.Errorf("Unexpected body: %q", body)
+	}
+	if resp.Header.Get("X-Cache") != "MISS" {
+		t.Errorf("Expected response to go through the proxy, X-Cache=%q", resp.Header.Get("X-Cache"))
+	}
+}
+
+func TestTransparentTLSUsesSNI(t *testing.T) {
+	server, err := NewProxyServer()
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure " + r.URL.Path))
+	}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	_, port, _ := net.SplitHostPort(backendURL.Host)
+	listener := startTransparent(t, server, backendURL.Host)
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(server.GetCACertificate()) {
+		t.Fatal("Failed to load proxy CA")
+	}
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+		ServerName: "localhost",
+		RootCAs:    roots,
+	})
+	if err != nil {
+		t.Fatalf("TLS dial through transparent proxy failed: %v", err)
+	}
+	defer conn.Close()
+
+	if cn := conn.ConnectionState().PeerCertificates[0].Subject.CommonName; cn != "localhost" {
+		t.Errorf("Expected certificate for SNI name localhost, got %q", cn)
+	}
+
+	req, _ := http.NewRequest("GET", "https://localhost:"+port+"/secure", nil)
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("Failed to write request: %v", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "secure /secure" {
+		t.Errorf("Unexpected body: %q", body)
+	}
+}
+
+func TestTransparentHTTPKeepsOriginalPort(t *testing.T) {
+	server, err := NewProxyServer()
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("host " + r.Host))
+	}))
+	defer backend.Close()
+
+	// REDIRECT of traffic to a non-80 port; the Host header names no port
+	backendURL, _ := url.Parse(backend.URL)
+	_, port, _ := net.SplitHostPort(backendURL.Host)
+	listener := startTransparent(t, server, backendURL.Host)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("Failed to write request: %v", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "host localhost:"+port {
+		t.Errorf("Expected the request on port %s, got %d %q", port, resp.StatusCode, body)
+	}
+}
+
+func TestTransparentHTTPRejectsHostMismatch(t *testing.T) {
+	server, err := NewProxyServer()
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+
+	hit := make(chan struct{}, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit <- struct{}{}
+	}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	_, port, _ := net.SplitHostPort(backendURL.Host)
+	listener := startTransparent(t, server, backendURL.Host)
+
+	tests := []string{
+		"127.0.0.2:" + port, // another address
+		"127.0.0.1:1",       // another port
+	}
+	for _, host := range tests {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+
+		req, _ := http.NewRequest("GET", "http://"+host+"/", nil)
+		if err := req.Write(conn); err != nil {
+			t.Fatalf("Failed to write request: %v", err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		resp.Body.Close()
+		conn.Close()
+
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Host %s: expected 403, got %d", host, resp.StatusCode)
+		}
+	}
+
+	select {
+	case <-hit:
+		t.Error("Mismatched requests must not reach the original destination")
+	default:
+	}
+}
+
+func TestTransparentTLSRejectsSNIMismatch(t *testing.T) {
+	server, err := NewProxyServer()
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+
+	var minted []string
+	var mu sync.Mutex
+	server.certManager.SetGenerateHook(func(hostname string, _ time.Duration, _ error) {
+		mu.Lock()
+		minted = append(minted, hostname)
+		mu.Unlock()
+	})
+
+	// localhost does not resolve to 127.0.0.2
+	listener := startTransparent(t, server, "127.0.0.2:443")
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+		ServerName:         "localhost",
+		InsecureSkipVerify: true,
+	})
+	if err == nil {
+		conn.Close()
+		t.Fatal("Expected the handshake to fail for an SNI name that is not the destination")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(minted) != 0 {
+		t.Errorf("No certificate may be minted before the SNI check, got %v", minted)
+	}
+}
+
+func TestMatchDestination(t *testing.T) {
+	tests := []struct {
+		name, host, dst string
+		want            string
+		wantErr         bool
+	}{
+		{"empty host", "", "10.0.0.1:8080", "10.0.0.1:8080", false},
+		{"port from destination", "10.0.0.1", "10.0.0.1:8080", "10.0.0.1:8080", false},
+		{"same port", "10.0.0.1:8080", "10.0.0.1:8080", "10.0.0.1:8080", false},
+		{"other port", "10.0.0.1:80", "10.0.0.1:8080", "", true},
+		{"other address", "10.0.0.2:8080", "10.0.0.1:8080", "", true},
+		{"bracketed IPv6", "[::1]", "[::1]:8443", "[::1]:8443", false},
+		{"resolved name", "localhost", "127.0.0.1:8080", "localhost:8080", false},
+		{"name elsewhere", "localhost:8080", "10.0.0.1:8080", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := matchDestination(context.Background(), tt.host, tt.dst)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: matchDestination(%q, %q) = %q, %v", tt.name, tt.host, tt.dst, got, err)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// WebSocket opcodes (RFC 6455, section 5.2)
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// maxInspectPayload caps how much of each frame is handed to hooks
+const maxInspectPayload = 64 * 1024
+
+// WebSocketFrame describes a frame relayed through an upgraded connection
+type WebSocketFrame struct {
+	Host       string
+	FromClient bool
+	Fin        bool
+	Opcode     byte
+	Length     int64  // Full payload length
+	Payload    []byte // Unmasked payload, truncated to maxInspectPayload
+}
+
+// OpcodeName returns a readable name for the frame opcode
+func (f *WebSocketFrame) OpcodeName() string {
+	switch f.Opcode {
+	case wsOpContinuation:
+		return "continuation"
+	case wsOpText:
+		return "text"
+	case wsOpBinary:
+		return "binary"
+	case wsOpClose:
+		return "close"
+	case wsOpPing:
+		return "ping"
+	case wsOpPong:
+		return "pong"
+	default:
+		return fmt.Sprintf("0x%x", f.Opcode)
+	}
+}
+
+// WebSocketFrameHook is called for every relayed WebSocket frame
+type WebSocketFrameHook func(frame *WebSocketFrame)
+
+// SetWebSocketFrameHook installs a hook that inspects WebSocket frames
+// relayed through the proxy, including intercepted wss:// traffic
+func (p *ProxyServer) SetWebSocketFrameHook(hook WebSocketFrameHook) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.wsFrameHook = hook
+}
+
+// frameObserver returns the hook to run for frames, if any
+func (p *ProxyServer) frameObserver() WebSocketFrameHook {
+	p.mu.RLock()
+	hook := p.wsFrameHook
+	p.mu.RUnlock()
+
+	if !p.current().wsLogFrames {
+		return hook
+	}
+
+	return func(frame *WebSocketFrame) {
+		direction := "to_client"
+		if frame.FromClient {
+			direction = "to_upstream"
+		}
+		p.logger.WithFields(map[string]interface{}{
+			"host":        frame.Host,
+			"direction":   direction,
+			"opcode":      frame.OpcodeName(),
+			"frame_bytes": frame.Length,
+		}).Info("websocket_frame")
+		if hook != nil {
+			hook(frame)
+		}
+	}
+}
+
+// isUpgradeRequest reports whether r asks to switch protocols
+func isUpgradeRequest(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleUpgradeResponse relays a 101 response to a plain HTTP client and
+// splices the hijacked connection with the upstream one
+func (p *ProxyServer) handleUpgradeResponse(w http.ResponseWriter, r *http.Request, resp *http.Response, rec *requestRecord) {
+	upstream, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		http.Error(w, "upstream did not return a writable body for 101", http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		rec.err = fmt.Errorf("hijack: %w", err)
+		return
+	}
+	defer clientConn.Close()
+	defer p.tunnels.add(clientConn)()
+
+	if err := writeResponseHeader(clientBuf.Writer, resp); err != nil {
+		rec.err = fmt.Errorf("relay 101: %w", err)
+		return
+	}
+
+	rec.log.Info("upgraded to " + resp.Header.Get("Upgrade"))
+	rec.bytesIn, rec.bytes = p.spliceUpgraded(clientConn, clientBuf.Reader, upstream, r.URL.Host)
+}
+
+// relayUpgradeTLS relays a 101 response on an intercepted TLS connection and
+// splices it with the upstream one
+func (p *ProxyServer) relayUpgradeTLS(clientConn net.Conn, clientReader *bufio.Reader, req *http.Request, resp *http.Response, rec *requestRecord) {
+	upstream, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		rec.err = errors.New("upstream did not return a writable body for 101")
+		return
+	}
+	defer upstream.Close()
+
+	bw := bufio.NewWriter(clientConn)
+	if err := writeResponseHeader(bw, resp); err != nil {
+		rec.err = fmt.Errorf("relay 101: %w", err)
+		return
+	}
+
+	rec.log.Info("upgraded to " + resp.Header.Get("Upgrade"))
+	rec.bytesIn, rec.bytes = p.spliceUpgraded(clientConn, clientReader, upstream, req.URL.Host)
+}
+
+// writeResponseHeader writes the status line and headers of resp and flushes
+func writeResponseHeader(w *bufio.Writer, resp *http.Response) error {
+	if _, err := fmt.Fprintf(w, "HTTP/1.1 %s\r\n", resp.Status); err != nil {
+		return err
+	}
+	if err := resp.Header.Write(w); err != nil {
+		return err
+	}
+	if _, err := w.WriteString("\r\n"); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// spliceUpgraded copies data in both directions until either side closes
+// and returns the bytes sent upstream and to the client. clientReader holds
+// bytes the client may have sent right after the request.
+func (p *ProxyServer) spliceUpgraded(clientConn net.Conn, clientReader io.Reader, upstream io.ReadWriteCloser, host string) (in, out int64) {
+	var toUpstream io.Reader = clientReader
+	var toClient io.Reader = upstream
+
+	if hook := p.frameObserver(); hook != nil {
+		toUpstream = io.TeeReader(clientReader, newFrameParser(host, true, hook))
+		toClient = io.TeeReader(upstream, newFrameParser(host, false, hook))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		in, _ = io.Copy(upstream, toUpstream)
+		// Unblock the other direction once the client stops sending
+		upstream.Close()
+	}()
+
+	go func() {
+		defer wg.Done()
+		out, _ = io.Copy(clientConn, toClient)
+		clientConn.Close()
+	}()
+
+	wg.Wait()
+	return in, out
+}
+
+// frameParser incrementally decodes WebSocket frames from a byte stream.
+// It is fed via io.TeeReader and never alters the relayed data.
+type frameParser struct {
+	host       string
+	fromClient bool
+	hook       WebSocketFrameHook
+
+	header    []byte
+	frame     *WebSocketFrame
+	remaining int64
+	mask      [4]byte
+	masked    bool
+	offset    int64
+}
+
+func newFrameParser(host string, fromClient bool, hook WebSocketFrameHook) *frameParser {
+	return &frameParser{
+		host:       host,
+		fromClient: fromClient,
+		hook:       hook,
+		header:     make([]byte, 0, 14),
+	}
+}
+
+// Write consumes relayed bytes; it never fails so the tee never stalls
+func (fp *frameParser) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		if fp.frame == nil {
+			b = fp.readHeader(b)
+			continue
+		}
+
+		chunk := b
+		if int64(len(chunk)) > fp.remaining {
+			chunk = chunk[:fp.remaining]
+		}
+		fp.consumePayload(chunk)
+		b = b[len(chunk):]
+
+		if fp.remaining == 0 {
+			fp.emit()
+		}
+	}
+	return n, nil
+}
+
+// readHeader accumulates header bytes and starts a frame once complete
+func (fp *frameParser) readHeader(b []byte) []byte {
+	for len(b) > 0 {
+		fp.header = append(fp.header, b[0])
+		b = b[1:]
+
+		need := headerLength(fp.header)
+		if need < 0 || len(fp.header) < need {
+			continue
+		}
+
+		fp.startFrame()
+		if fp.remaining == 0 {
+			fp.emit()
+		}
+		return b
+	}
+	return b
+}
+
+// headerLength returns the full header length implied by the bytes seen so
+// far, or -1 if more bytes are needed to know it
+func headerLength(h []byte) int {
+	if len(h) < 2 {
+		return -1
+	}
+	n := 2
+	switch h[1] & 0x7f {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	if h[1]&0x80 != 0 {
+		n += 4
+	}
+	return n
+}
+
+func (fp *frameParser) startFrame() {
+	h := fp.header
+	length := int64(h[1] & 0x7f)
+	pos := 2
+	switch length {
+	case 126:
+		length = int64(binary.BigEndian.Uint16(h[2:4]))
+		pos = 4
+	case 127:
+		length = int64(binary.BigEndian.Uint64(h[2:10]) & (1<<63 - 1))
+		pos = 10
+	}
+
+	fp.masked = h[1]&0x80 != 0
+	if fp.masked {
+		copy(fp.mask[:], h[pos:pos+4])
+	}
+
+	fp.frame = &WebSocketFrame{
+		Host:       fp.host,
+		FromClient: fp.fromClient,
+		Fin:        h[0]&0x80 != 0,
+		Opcode:     h[0] & 0x0f,
+		Length:     length,
+	}
+	fp.remaining = length
+	fp.offset = 0
+	fp.header = fp.header[:0]
+}
+
+func (fp *frameParser) consumePayload(chunk []byte) {
+	room := maxInspectPayload - int64(len(fp.frame.Payload))
+	keep := chunk
+	if int64(len(keep)) > room {
+		keep = keep[:room]
+	}
+
+	for i, c := range keep {
+		if fp.masked {
+			c ^= fp.mask[(fp.offset+int64(i))%4]
+		}
+		fp.frame.Payload = append(fp.frame.Payload, c)
+	}
+
+	fp.offset += int64(len(chunk))
+	fp.remaining -= int64(len(chunk))
+}
+
+func (fp *frameParser) emit() {
+	fp.hook(fp.frame)
+	fp.frame = nil
+}
@@ -0,0 +1,92 @@
+// Package tracing sets up OpenTelemetry tracing: spans are exported to a
+// collector over OTLP/HTTP and W3C trace context (traceparent) is read from
+// and written to HTTP headers.
+//
+// Without a provider spans come from the no-op tracer, so instrumented code
+// does not need to check whether tracing is enabled.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope of the proxy's spans
+const ScopeName = "github.com/onixus/4ebur-net"
+
+// propagator handles the traceparent header; baggage is not used
+var propagator = propagation.TraceContext{}
+
+// TracesURL returns the OTLP/HTTP traces URL for endpoint: a bare
+// collector address such as http://otel:4318 gets /v1/traces appended
+func TracesURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("expected http(s)://host[:port][/path], got %q", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	return u.String(), nil
+}
+
+// NewProvider creates a tracer provider that batches spans to the OTLP/HTTP
+// collector at endpoint (see TracesURL) with service as service.name. It
+// samples the given ratio of new traces; requests that arrive with a
+// traceparent keep the caller's decision.
+func NewProvider(endpoint, service string, sampleRatio float64) (*sdktrace.TracerProvider, error) {
+	tracesURL, err := TracesURL(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(tracesURL))
+	if err != nil {
+		return nil, err
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+	), nil
+}
+
+// Extract returns ctx carrying the remote parent found in h, if any
+func Extract(ctx context.Context, h http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(h))
+}
+
+// Inject sets traceparent on h from the current span of ctx; without a
+// span h is left untouched so client-supplied context passes through
+func Inject(ctx context.Context, h http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// Start begins a child of the current span of ctx with that span's tracer
+// provider; without a current span the span does nothing. Packages that do
+// not own a tracer use it to add detail to an existing trace.
+func Start(ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := trace.SpanFromContext(ctx).TracerProvider().Tracer(ScopeName)
+	return tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// SetError marks span as failed; a nil err is ignored
+func SetError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.SetStatus(codes.Error, err.Error())
+}
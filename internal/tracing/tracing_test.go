@@ -0,0 +1,93 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracesURL(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{"http://otel:4318", "http://otel:4318/v1/traces"},
+		{"http://otel:4318/", "http://otel:4318/v1/traces"},
+		{"https://collector.example/custom/path", "https://collector.example/custom/path"},
+		{"otel:4318", ""},
+		{"ftp://otel", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := TracesURL(tt.endpoint)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("TracesURL(%q): expected error, got %q", tt.endpoint, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("TracesURL(%q) = %q, %v; want %q", tt.endpoint, got, err, tt.want)
+		}
+	}
+}
+
+func TestStartContinuesRemoteTrace(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(rec),
+		// The caller's sampled flag overrides the ratio
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(0))),
+	)
+
+	h := http.Header{}
+	h.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, server := provider.Tracer(ScopeName).Start(Extract(context.Background(), h), "server")
+	childCtx, child := Start(ctx, "child", trace.SpanKindClient)
+
+	out := http.Header{}
+	Inject(childCtx, out)
+	child.End()
+	server.End()
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 recorded spans, got %d", len(spans))
+	}
+	childSpan, serverSpan := spans[0], spans[1]
+
+	if serverSpan.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Server span should continue the remote trace, got %s", serverSpan.SpanContext().TraceID())
+	}
+	if serverSpan.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("Server span parent should be the remote span, got %s", serverSpan.Parent().SpanID())
+	}
+	if childSpan.Parent().SpanID() != serverSpan.SpanContext().SpanID() {
+		t.Error("Child span not linked to server span")
+	}
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + childSpan.SpanContext().SpanID().String() + "-01"
+	if got := out.Get("traceparent"); got != want {
+		t.Errorf("Injected traceparent %q, want %q", got, want)
+	}
+}
+
+func TestStartWithoutSpan(t *testing.T) {
+	// Without a current span Start records nothing
+	ctx, span := Start(context.Background(), "orphan", trace.SpanKindInternal)
+	if span.IsRecording() || span.SpanContext().IsValid() {
+		t.Error("Start without a parent span should not record")
+	}
+	SetError(span, nil)
+	span.End()
+
+	h := http.Header{}
+	h.Set("traceparent", "client-value")
+	Inject(ctx, h)
+	if h.Get("traceparent") != "client-value" {
+		t.Error("Inject without a span should leave the header alone")
+	}
+}